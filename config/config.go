@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +19,21 @@ type WatchdogConfig struct {
 	ContentType      string
 	InjectCGIHeaders bool
 	OperationalMode  int
+
+	// UpstreamURL is where requests are forwarded to in http mode
+	UpstreamURL string
+
+	// HealthcheckInterval is how long the watchdog keeps serving after the lock
+	// file is removed on SIGTERM, so health checks can route traffic away.
+	HealthcheckInterval time.Duration
+
+	// GracefulWait bounds how long shutdown waits for in-flight invocations
+	// to complete.
+	GracefulWait time.Duration
+
+	// ForwardSignals are relayed to the function's processes when the
+	// watchdog receives them.
+	ForwardSignals []os.Signal
 }
 
 // Process returns a string for the process and a slice for the arguments from the FunctionProcess.
@@ -59,12 +75,24 @@ func New(env []string) (WatchdogConfig, error) {
 		ExecTimeout:      getDuration(envMap, "exec_timeout", time.Second*10),
 		OperationalMode:  ModeStreaming,
 		ContentType:      contentType,
+		UpstreamURL:      envMap["upstream_url"],
 	}
 
 	if val := envMap["mode"]; len(val) > 0 {
 		config.OperationalMode = WatchdogModeConst(val)
 	}
 
+	var err error
+	if config.HealthcheckInterval, err = parseDuration(envMap, "healthcheck_interval", config.HTTPWriteTimeout); err != nil {
+		return config, err
+	}
+	if config.GracefulWait, err = parseDuration(envMap, "graceful_wait", config.HTTPWriteTimeout); err != nil {
+		return config, err
+	}
+	if config.ForwardSignals, err = parseSignals(envMap, "forward_signals"); err != nil {
+		return config, err
+	}
+
 	return config, nil
 }
 
@@ -93,6 +121,45 @@ func getDuration(env map[string]string, key string, defaultValue time.Duration)
 	return result
 }
 
+// parseDuration is getDuration, except that a value which cannot be parsed is an error.
+func parseDuration(env map[string]string, key string, defaultValue time.Duration) (time.Duration, error) {
+	val, exists := env[key]
+	if !exists {
+		return defaultValue, nil
+	}
+
+	parsed, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q is not a duration i.e. 10s", key, val)
+	}
+	if parsed < 0 {
+		return 0, fmt.Errorf("invalid %s: %q must not be negative", key, val)
+	}
+	return parsed, nil
+}
+
+// parseSignals reads a comma separated list of signal names i.e. SIGHUP,USR1,
+// the SIG prefix is optional and the names are not case sensitive.
+func parseSignals(env map[string]string, key string) ([]os.Signal, error) {
+	var signals []os.Signal
+	for _, name := range strings.Split(env[key], ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+
+		sig, ok := forwardableSignals[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: %s cannot be forwarded", key, name)
+		}
+		signals = append(signals, sig)
+	}
+	return signals, nil
+}
+
 func getInt(env map[string]string, key string, defaultValue int) int {
 	result := defaultValue
 	if val, exists := env[key]; exists {
@@ -1,5 +1,6 @@
 package config
 
+import "strings"
 import "testing"
 import "time"
 
@@ -168,3 +169,78 @@ func Test_Timeouts(t *testing.T) {
 	}
 
 }
+
+func Test_UpstreamURL(t *testing.T) {
+	env := []string{
+		"upstream_url=http://127.0.0.1:3000",
+	}
+
+	actual, err := New(env)
+	if err != nil {
+		t.Errorf("Expected no errors")
+	}
+
+	if actual.UpstreamURL != "http://127.0.0.1:3000" {
+		t.Errorf("Want %s. got: %s", "http://127.0.0.1:3000", actual.UpstreamURL)
+	}
+}
+
+func Test_ShutdownDurations(t *testing.T) {
+	cases := []struct {
+		name                string
+		env                 []string
+		healthcheckInterval time.Duration
+		gracefulWait        time.Duration
+	}{
+		{
+			name:                "Defaults to write_timeout",
+			env:                 []string{"write_timeout=7s"},
+			healthcheckInterval: time.Second * 7,
+			gracefulWait:        time.Second * 7,
+		},
+		{
+			name:                "Overridden",
+			env:                 []string{"healthcheck_interval=2s", "graceful_wait=1m"},
+			healthcheckInterval: time.Second * 2,
+			gracefulWait:        time.Minute,
+		},
+		{
+			name:                "Zero",
+			env:                 []string{"healthcheck_interval=0s", "graceful_wait=0s"},
+			healthcheckInterval: 0,
+			gracefulWait:        0,
+		},
+	}
+
+	for _, testCase := range cases {
+		actual, err := New(testCase.env)
+		if err != nil {
+			t.Errorf("(%s) Expected no errors, got: %s", testCase.name, err)
+		}
+		if actual.HealthcheckInterval != testCase.healthcheckInterval {
+			t.Errorf("(%s) HealthcheckInterval want: %s, got: %s", testCase.name, testCase.healthcheckInterval, actual.HealthcheckInterval)
+		}
+		if actual.GracefulWait != testCase.gracefulWait {
+			t.Errorf("(%s) GracefulWait want: %s, got: %s", testCase.name, testCase.gracefulWait, actual.GracefulWait)
+		}
+	}
+}
+
+func Test_ShutdownDurations_Invalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     []string
+		wantErr string
+	}{
+		{name: "Missing unit", env: []string{"graceful_wait=30"}, wantErr: "invalid graceful_wait"},
+		{name: "Not a duration", env: []string{"healthcheck_interval=soon"}, wantErr: "invalid healthcheck_interval"},
+		{name: "Negative", env: []string{"graceful_wait=-5s"}, wantErr: "must not be negative"},
+	}
+
+	for _, testCase := range cases {
+		_, err := New(testCase.env)
+		if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+			t.Errorf("(%s) want error containing %q, got: %v", testCase.name, testCase.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func Test_ForwardSignals(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     []string
+		signals []os.Signal
+	}{
+		{name: "Default", env: []string{}, signals: nil},
+		{name: "Full names", env: []string{"forward_signals=SIGHUP,SIGUSR1"}, signals: []os.Signal{syscall.SIGHUP, syscall.SIGUSR1}},
+		{name: "Short lower case names", env: []string{"forward_signals=usr2, winch"}, signals: []os.Signal{syscall.SIGUSR2, syscall.SIGWINCH}},
+	}
+
+	for _, testCase := range cases {
+		actual, err := New(testCase.env)
+		if err != nil {
+			t.Errorf("(%s) Expected no errors, got: %s", testCase.name, err)
+		}
+		if !reflect.DeepEqual(actual.ForwardSignals, testCase.signals) {
+			t.Errorf("(%s) ForwardSignals want: %v, got: %v", testCase.name, testCase.signals, actual.ForwardSignals)
+		}
+	}
+}
+
+func Test_ForwardSignals_Invalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     []string
+		wantErr string
+	}{
+		{name: "Unknown", env: []string{"forward_signals=SIGFOO"}, wantErr: "SIGFOO cannot be forwarded"},
+		{name: "Used for shutdown", env: []string{"forward_signals=SIGHUP,SIGTERM"}, wantErr: "SIGTERM cannot be forwarded"},
+	}
+
+	for _, testCase := range cases {
+		_, err := New(testCase.env)
+		if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+			t.Errorf("(%s) want error containing %q, got: %v", testCase.name, testCase.wantErr, err)
+		}
+	}
+}
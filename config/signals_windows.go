@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package config
+
+import "os"
+
+// forwardableSignals is empty as Windows processes cannot be sent signals
+var forwardableSignals = map[string]os.Signal{}
@@ -0,0 +1,103 @@
+package executor
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// backpressure pauses reading the request body while a write of the
+// response has been blocked for longer than threshold, so a function which
+// buffers its input is not fed faster than its output is consumed.
+type backpressure struct {
+	threshold time.Duration
+
+	mutex    sync.Mutex
+	writing  bool
+	since    time.Time
+	released chan struct{}
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newBackpressure(threshold time.Duration) *backpressure {
+	return &backpressure{
+		threshold: threshold,
+		done:      make(chan struct{}),
+	}
+}
+
+// writer returns w with each Write timed as a possible stall.
+func (b *backpressure) writer(w io.Writer) io.Writer {
+	return &backpressureWriter{writer: w, backpressure: b}
+}
+
+// reader returns r, which waits before each Read while a write is stalled.
+func (b *backpressure) reader(r io.Reader) io.Reader {
+	return &backpressureReader{reader: r, backpressure: b}
+}
+
+// stop releases any paused reader for good, once nothing more is written.
+func (b *backpressure) stop() {
+	b.stopOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+func (b *backpressure) beginWrite() {
+	b.mutex.Lock()
+	b.writing = true
+	b.since = time.Now()
+	b.released = make(chan struct{})
+	b.mutex.Unlock()
+}
+
+func (b *backpressure) endWrite() {
+	b.mutex.Lock()
+	b.writing = false
+	close(b.released)
+	b.mutex.Unlock()
+}
+
+// wait blocks while the current write has been in progress for longer than
+// threshold.
+func (b *backpressure) wait() {
+	for {
+		b.mutex.Lock()
+		stalled := b.writing && time.Since(b.since) >= b.threshold
+		released := b.released
+		b.mutex.Unlock()
+
+		if !stalled {
+			return
+		}
+
+		select {
+		case <-released:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+type backpressureWriter struct {
+	writer       io.Writer
+	backpressure *backpressure
+}
+
+func (w *backpressureWriter) Write(p []byte) (int, error) {
+	w.backpressure.beginWrite()
+	defer w.backpressure.endWrite()
+	return w.writer.Write(p)
+}
+
+type backpressureReader struct {
+	reader       io.Reader
+	backpressure *backpressure
+}
+
+func (r *backpressureReader) Read(p []byte) (int, error) {
+	r.backpressure.wait()
+	return r.reader.Read(p)
+}
@@ -0,0 +1,138 @@
+package executor
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBufferingProcess is not a real test, it is started by the tests below
+// as a function which reads all of its input into memory without waiting for
+// its output to be written, then echoes it.
+func TestBufferingProcess(t *testing.T) {
+	if os.Getenv("WANT_BUFFERING_PROCESS") != "1" {
+		return
+	}
+
+	var mutex sync.Mutex
+	cond := sync.NewCond(&mutex)
+	var queue [][]byte
+	eof := false
+
+	go func() {
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := os.Stdin.Read(buf)
+			mutex.Lock()
+			if n > 0 {
+				queue = append(queue, buf[:n])
+			}
+			if err != nil {
+				eof = true
+			}
+			cond.Signal()
+			mutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	out := bufio.NewWriter(os.Stdout)
+	for {
+		mutex.Lock()
+		for len(queue) == 0 && !eof {
+			cond.Wait()
+		}
+		if len(queue) == 0 {
+			mutex.Unlock()
+			out.Flush()
+			os.Exit(0)
+		}
+		chunk := queue[0]
+		queue = queue[1:]
+		mutex.Unlock()
+
+		out.Write(chunk)
+		out.Flush()
+	}
+}
+
+// slowReader returns chunks of size bytes with a pause before each.
+type slowReader struct {
+	remaining int64
+	read      int64
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt64(&s.remaining) <= 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(time.Millisecond)
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	atomic.AddInt64(&s.remaining, -int64(len(p)))
+	atomic.AddInt64(&s.read, int64(len(p)))
+	return len(p), nil
+}
+
+// blockedWriter blocks every Write until release is closed.
+type blockedWriter struct {
+	release chan struct{}
+	written int64
+}
+
+func (b *blockedWriter) Write(p []byte) (int, error) {
+	<-b.release
+	b.written += int64(len(p))
+	return len(p), nil
+}
+
+func TestForkFunctionRunner_Run_BackpressureThreshold(t *testing.T) {
+	const size = 32 * 1024 * 1024
+
+	f := ForkFunctionRunner{BackpressureThreshold: time.Millisecond * 20}
+	input := &slowReader{remaining: size}
+	output := &blockedWriter{release: make(chan struct{})}
+	req := FunctionRequest{
+		Process:      os.Args[0],
+		ProcessArgs:  []string{"-test.run=^TestBufferingProcess$"},
+		Environment:  append(os.Environ(), "WANT_BUFFERING_PROCESS=1"),
+		InputReader:  ioutil.NopCloser(input),
+		OutputWriter: output,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Run(req)
+		done <- err
+	}()
+
+	time.Sleep(time.Millisecond * 300)
+	paused := atomic.LoadInt64(&input.read)
+	time.Sleep(time.Millisecond * 300)
+
+	if read := atomic.LoadInt64(&input.read); read != paused {
+		t.Errorf("want reading paused while the output is blocked, got: %d then %d bytes", paused, read)
+	}
+	if paused >= size/4 {
+		t.Errorf("want at most %d bytes read before pausing, got: %d", size/4, paused)
+	}
+
+	close(output.release)
+	if err := <-done; err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if output.written != size {
+		t.Errorf("want %d bytes written once released, got: %d", size, output.written)
+	}
+}
@@ -0,0 +1,260 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// defaultBatchSize is the number of requests sent to each process when BatchSize is not set
+const defaultBatchSize = 10
+
+// defaultBatchWindow is how long a partial batch waits for more requests when BatchWindow is not set
+const defaultBatchWindow = time.Millisecond * 10
+
+// BatchForkFunctionRunner forks one process for each batch of up to
+// BatchSize requests. Each request body is written to the process' stdin as
+// a 4-byte big-endian length followed by the body, then stdin is closed.
+// The process replies to each request in order with a status byte, zero for
+// success, a 4-byte big-endian length and the response body. A non-zero
+// status fails only that request, with the body as the reason.
+type BatchForkFunctionRunner struct {
+	Process     string
+	ProcessArgs []string
+	Environment []string
+
+	// BatchSize is the most requests sent to one process, defaults to 10.
+	BatchSize int
+
+	// BatchWindow is how long the first request of a batch waits for others
+	// to join it before the process is started anyway, defaults to 10ms.
+	BatchWindow time.Duration
+
+	// ExecTimeout bounds the lifetime of each batch's process.
+	ExecTimeout time.Duration
+
+	mutex   sync.Mutex
+	pending []*batchCall
+	batch   int
+}
+
+// batchCall is one request waiting for its batch to be processed.
+type batchCall struct {
+	body   []byte
+	output bytes.Buffer
+	err    error
+	done   chan struct{}
+}
+
+// Run calls RunContext with req.Context, or context.Background() when it is not set
+func (f *BatchForkFunctionRunner) Run(req FunctionRequest) (RunResult, error) {
+	return f.RunContext(requestContext(req), req)
+}
+
+// RunContext adds the request to the next batch and writes its response to
+// req.OutputWriter once the batch has been processed.
+func (f *BatchForkFunctionRunner) RunContext(ctx context.Context, req FunctionRequest) (RunResult, error) {
+	req.Context = ctx
+
+	start := time.Now()
+	result := RunResult{ExitCode: -1}
+
+	call := &batchCall{done: make(chan struct{})}
+	if req.InputReader != nil {
+		defer req.InputReader.Close()
+
+		var err error
+		if call.body, err = ioutil.ReadAll(req.InputReader); err != nil {
+			return result, err
+		}
+	}
+
+	f.enqueue(call)
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		// The response is dropped when the batch completes.
+		result.Duration = time.Since(start)
+		return result, ctx.Err()
+	}
+
+	result.Duration = time.Since(start)
+	if call.err != nil {
+		return result, call.err
+	}
+
+	output := req.OutputWriter
+	if output == nil {
+		output = ioutil.Discard
+	}
+	written, err := output.Write(call.output.Bytes())
+	result.BytesWritten = int64(written)
+	if err != nil {
+		return result, err
+	}
+
+	result.ExitCode = 0
+	return result, nil
+}
+
+// enqueue adds call to the pending batch, starting the batch once it is full
+// or BatchWindow after its first call.
+func (f *BatchForkFunctionRunner) enqueue(call *batchCall) {
+	size := f.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	window := f.BatchWindow
+	if window <= 0 {
+		window = defaultBatchWindow
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pending = append(f.pending, call)
+	if len(f.pending) >= size {
+		go f.runBatch(f.takeBatch())
+		return
+	}
+
+	if len(f.pending) == 1 {
+		batch := f.batch
+		time.AfterFunc(window, func() {
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+
+			// The batch may have filled up and been started already.
+			if f.batch == batch && len(f.pending) > 0 {
+				go f.runBatch(f.takeBatch())
+			}
+		})
+	}
+}
+
+// takeBatch removes the pending calls so that the next call starts a new
+// batch, f.mutex must be held.
+func (f *BatchForkFunctionRunner) takeBatch() []*batchCall {
+	calls := f.pending
+	f.pending = nil
+	f.batch++
+	return calls
+}
+
+// runBatch forks a process for calls and hands each its response, or an
+// error when the process did not reply to it.
+func (f *BatchForkFunctionRunner) runBatch(calls []*batchCall) {
+	defer func() {
+		for _, call := range calls {
+			close(call.done)
+		}
+	}()
+
+	fail := func(from int, err error) {
+		for _, call := range calls[from:] {
+			if call.err == nil {
+				call.err = err
+			}
+		}
+	}
+
+	ctx := context.Background()
+	if f.ExecTimeout > time.Millisecond*0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.ExecTimeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, f.Process, f.ProcessArgs...)
+	cmd.Env = f.Environment
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		fail(0, err)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fail(0, err)
+		return
+	}
+	errPipe := openStderr(cmd, "")
+
+	if err := cmd.Start(); err != nil {
+		fail(0, err)
+		return
+	}
+
+	stderrDone := make(chan struct{})
+	if errPipe != nil {
+		go func() {
+			defer close(stderrDone)
+			logStderr(errPipe, ioutil.Discard, nil, nil, "")
+		}()
+	} else {
+		close(stderrDone)
+	}
+
+	go func() {
+		defer stdin.Close()
+
+		header := make([]byte, 4)
+		for _, call := range calls {
+			binary.BigEndian.PutUint32(header, uint32(len(call.body)))
+			if _, err := stdin.Write(append(header, call.body...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(stdout)
+	answered := 0
+	for _, call := range calls {
+		if err := readBatchResponse(reader, call); err != nil {
+			fail(answered, fmt.Errorf("unable to read response from batch process: %w", err))
+			break
+		}
+		answered++
+	}
+
+	// Any output past the last response must be read before calling Wait.
+	io.Copy(ioutil.Discard, reader)
+	<-stderrDone
+
+	// Responses already read stand even if the process then fails.
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("function killed: %w", ctx.Err())
+		}
+		fail(answered, err)
+	}
+}
+
+// readBatchResponse reads one response frame into call.
+func readBatchResponse(reader *bufio.Reader, call *batchCall) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return err
+	}
+
+	size := int64(binary.BigEndian.Uint32(header[1:]))
+	if header[0] == 0 {
+		_, err := io.CopyN(&call.output, reader, size)
+		return err
+	}
+
+	reason := &bytes.Buffer{}
+	if _, err := io.CopyN(reason, reader, size); err != nil {
+		return err
+	}
+	call.err = fmt.Errorf("%w: %s", ErrBatchRequestFailed, reason.String())
+	return nil
+}
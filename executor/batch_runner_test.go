@@ -0,0 +1,152 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBatchWorkerProcess is not a real test, it is started by the tests below
+// to reply to each request of a batch with its pid and the body. A body of
+// "fail" is failed and one of "exit" ends the process without replying.
+func TestBatchWorkerProcess(t *testing.T) {
+	if os.Getenv("WANT_BATCH_WORKER") != "1" {
+		return
+	}
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(os.Stdin, header); err != nil {
+			os.Exit(0)
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(os.Stdin, body); err != nil {
+			os.Exit(1)
+		}
+
+		status := byte(0)
+		response := []byte(fmt.Sprintf("%d %s", os.Getpid(), body))
+		switch string(body) {
+		case "fail":
+			status, response = 1, []byte("cannot handle fail")
+		case "exit":
+			os.Exit(2)
+		}
+
+		frame := make([]byte, 5)
+		frame[0] = status
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(response)))
+		os.Stdout.Write(append(frame, response...))
+	}
+}
+
+func newBatchTestRunner(f *BatchForkFunctionRunner) *BatchForkFunctionRunner {
+	f.Process = os.Args[0]
+	f.ProcessArgs = []string{"-test.run=^TestBatchWorkerProcess$"}
+	f.Environment = append(os.Environ(), "WANT_BATCH_WORKER=1")
+	return f
+}
+
+type batchReply struct {
+	output string
+	err    error
+}
+
+// runBatched sends each body through f concurrently and returns the replies in the same order.
+func runBatched(f *BatchForkFunctionRunner, bodies ...string) []batchReply {
+	replies := make([]batchReply, len(bodies))
+
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		go func(i int, body string) {
+			defer wg.Done()
+
+			out := &bytes.Buffer{}
+			_, err := f.Run(FunctionRequest{
+				InputReader:  ioutil.NopCloser(strings.NewReader(body)),
+				OutputWriter: out,
+			})
+			replies[i] = batchReply{output: out.String(), err: err}
+		}(i, body)
+	}
+	wg.Wait()
+	return replies
+}
+
+func TestBatchForkFunctionRunner_RoutesResponses(t *testing.T) {
+	f := newBatchTestRunner(&BatchForkFunctionRunner{BatchSize: 4, BatchWindow: time.Second * 5})
+
+	bodies := []string{"a", "b", "fail", "c"}
+	replies := runBatched(f, bodies...)
+
+	pids := map[string]bool{}
+	for i, body := range bodies {
+		reply := replies[i]
+		if body == "fail" {
+			if !errors.Is(reply.err, ErrBatchRequestFailed) || !strings.Contains(reply.err.Error(), "cannot handle fail") {
+				t.Errorf("(%s) want ErrBatchRequestFailed with the reason, got: %v", body, reply.err)
+			}
+			continue
+		}
+
+		if reply.err != nil {
+			t.Errorf("(%s) want no error, got: %s", body, reply.err)
+			continue
+		}
+		parts := strings.SplitN(reply.output, " ", 2)
+		if len(parts) != 2 || parts[1] != body {
+			t.Errorf("(%s) want its own response, got: %q", body, reply.output)
+			continue
+		}
+		pids[parts[0]] = true
+	}
+
+	if len(pids) != 1 {
+		t.Errorf("want a full batch handled by one process, got pids: %v", pids)
+	}
+}
+
+func TestBatchForkFunctionRunner_BatchWindow(t *testing.T) {
+	f := newBatchTestRunner(&BatchForkFunctionRunner{BatchSize: 100, BatchWindow: time.Millisecond * 50})
+
+	start := time.Now()
+	replies := runBatched(f, "only")
+	if replies[0].err != nil {
+		t.Fatalf("want no error, got: %s", replies[0].err)
+	}
+	if !strings.HasSuffix(replies[0].output, " only") {
+		t.Errorf("want response, got: %q", replies[0].output)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Errorf("want a partial batch to wait for BatchWindow, took: %s", elapsed)
+	}
+}
+
+func TestBatchForkFunctionRunner_ProcessExitsEarly(t *testing.T) {
+	f := newBatchTestRunner(&BatchForkFunctionRunner{BatchSize: 3, BatchWindow: time.Second * 5})
+
+	// Requests are written in the order they joined the batch, so send the
+	// answered one first.
+	first := make(chan batchReply, 1)
+	go func() { first <- runBatched(f, "answered")[0] }()
+	time.Sleep(time.Millisecond * 100)
+	rest := runBatched(f, "exit", "exit")
+
+	if reply := <-first; reply.err != nil || !strings.HasSuffix(reply.output, " answered") {
+		t.Errorf("want the answered request to succeed, got: %q %v", reply.output, reply.err)
+	}
+	for _, reply := range rest {
+		if reply.err == nil {
+			t.Errorf("want an error for each unanswered request, got output: %q", reply.output)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package executor
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxResponseHeaderBytes bounds how much of stdout is scanned for CGI-style headers
+const defaultMaxResponseHeaderBytes = 8 * 1024
+
+// sniffLen is the most http.DetectContentType looks at
+const sniffLen = 512
+
+// cgiHeaderWriter strips CGI-style "Header: value" lines, terminated by a
+// blank line, from the start of the output and writes the rest as the body.
+type cgiHeaderWriter struct {
+	body           io.Writer
+	responseWriter http.ResponseWriter
+	maxHeaderBytes int
+
+	// writeHeader sends the Status header, defaults to responseWriter.WriteHeader.
+	writeHeader func(int)
+
+	// sniff detects the Content-Type of the body when the headers have none.
+	sniff bool
+
+	buffer  bytes.Buffer
+	parsed  bool
+	done    bool
+	headers http.Header
+	status  int
+}
+
+func newCGIHeaderWriter(body io.Writer, destination io.Writer, maxHeaderBytes int) *cgiHeaderWriter {
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = defaultMaxResponseHeaderBytes
+	}
+
+	c := &cgiHeaderWriter{
+		body:           body,
+		maxHeaderBytes: maxHeaderBytes,
+	}
+
+	if responseWriter, ok := destination.(http.ResponseWriter); ok {
+		c.responseWriter = responseWriter
+		c.writeHeader = responseWriter.WriteHeader
+	}
+	return c
+}
+
+func (c *cgiHeaderWriter) Write(p []byte) (int, error) {
+	if c.done {
+		return c.body.Write(p)
+	}
+
+	c.buffer.Write(p)
+
+	if !c.parsed {
+		if end, separatorLen := headerEnd(c.buffer.Bytes()); end >= 0 && end <= c.maxHeaderBytes {
+			if headers, status, ok := parseCGIHeaders(c.buffer.Bytes()[:end]); ok {
+				c.headers = headers
+				c.status = status
+				c.buffer.Next(end + separatorLen)
+			}
+			c.parsed = true
+		} else if c.buffer.Len() > c.maxHeaderBytes {
+			c.parsed = true
+		} else {
+			return len(p), nil
+		}
+	}
+
+	if c.sniffing() && c.buffer.Len() < sniffLen {
+		return len(p), nil
+	}
+	return len(p), c.flush()
+}
+
+// sniffing reports whether the body is held back to detect its Content-Type.
+func (c *cgiHeaderWriter) sniffing() bool {
+	return c.sniff && len(c.headers.Get("Content-Type")) == 0
+}
+
+// Close writes out anything still buffered once the output has ended.
+func (c *cgiHeaderWriter) Close() error {
+	if c.done {
+		return nil
+	}
+	return c.flush()
+}
+
+func (c *cgiHeaderWriter) flush() error {
+	c.done = true
+
+	if c.sniffing() && c.buffer.Len() > 0 {
+		prefix := c.buffer.Bytes()
+		if len(prefix) > sniffLen {
+			prefix = prefix[:sniffLen]
+		}
+		if c.headers == nil {
+			c.headers = http.Header{}
+		}
+		c.headers.Set("Content-Type", http.DetectContentType(prefix))
+	}
+
+	if c.responseWriter != nil && c.headers != nil {
+		for k, v := range c.headers {
+			c.responseWriter.Header()[k] = v
+		}
+		if c.status > 0 {
+			c.writeHeader(c.status)
+		}
+	}
+
+	if c.buffer.Len() == 0 {
+		return nil
+	}
+
+	_, err := c.body.Write(c.buffer.Bytes())
+	c.buffer.Reset()
+	return err
+}
+
+// headerEnd finds the blank line ending the headers and the length of the separator.
+func headerEnd(data []byte) (int, int) {
+	lf := bytes.Index(data, []byte("\n\n"))
+	crlf := bytes.Index(data, []byte("\r\n\r\n"))
+
+	if crlf >= 0 && (lf < 0 || crlf < lf) {
+		return crlf, 4
+	}
+	if lf >= 0 {
+		return lf, 2
+	}
+	return -1, 0
+}
+
+// parseCGIHeaders parses header lines, a "Status" header sets the HTTP status
+// code. ok is false when any line is not a header, or the Status is not a
+// code from 100 to 599, meaning the output has none.
+func parseCGIHeaders(block []byte) (http.Header, int, bool) {
+	headers := http.Header{}
+	status := 0
+
+	for _, line := range strings.Split(string(block), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+
+		index := strings.Index(line, ":")
+		if index <= 0 || strings.ContainsAny(line[:index], " \t") {
+			return nil, 0, false
+		}
+
+		key := http.CanonicalHeaderKey(line[:index])
+		value := strings.TrimSpace(line[index+1:])
+
+		if key == "Status" {
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				return nil, 0, false
+			}
+
+			code, err := strconv.Atoi(fields[0])
+			if err != nil || code < 100 || code > 599 {
+				return nil, 0, false
+			}
+			status = code
+			continue
+		}
+
+		headers.Add(key, value)
+	}
+
+	return headers, status, true
+}
+
+// cgiTrailerWriter passes the output through to body until a line holding
+// only marker, after which it collects CGI-style trailer lines. It does
+// nothing when responseWriter has a Content-Length, as trailers can only be
+// sent with a chunked response.
+type cgiTrailerWriter struct {
+	body            io.Writer
+	responseWriter  http.ResponseWriter
+	marker          string
+	maxTrailerBytes int
+
+	started     bool
+	passthrough bool
+	inTrailers  bool
+	lineStart   bool
+	pending     []byte
+	markerLine  []byte
+	buffer      bytes.Buffer
+	trailers    http.Header
+}
+
+func newCGITrailerWriter(body io.Writer, destination io.Writer, marker string, maxTrailerBytes int) *cgiTrailerWriter {
+	if maxTrailerBytes <= 0 {
+		maxTrailerBytes = defaultMaxResponseHeaderBytes
+	}
+
+	c := &cgiTrailerWriter{
+		body:            body,
+		marker:          marker,
+		maxTrailerBytes: maxTrailerBytes,
+		lineStart:       true,
+	}
+	if responseWriter, ok := destination.(http.ResponseWriter); ok {
+		c.responseWriter = responseWriter
+	}
+	return c
+}
+
+func (c *cgiTrailerWriter) Write(p []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		c.passthrough = c.responseWriter != nil && len(c.responseWriter.Header().Get("Content-Length")) > 0
+	}
+
+	if c.passthrough {
+		return c.body.Write(p)
+	}
+
+	if c.inTrailers {
+		c.buffer.Write(p)
+		if c.buffer.Len() > c.maxTrailerBytes {
+			return len(p), c.abandon()
+		}
+		return len(p), nil
+	}
+
+	data := append(c.pending, p...)
+	c.pending = nil
+
+	for i := 0; i < len(data); {
+		if i > 0 || c.lineStart {
+			if end, found, partial := c.matchMarker(data[i:]); found {
+				if err := c.writeBody(data[:i]); err != nil {
+					return len(p), err
+				}
+				c.inTrailers = true
+				c.markerLine = append([]byte{}, data[i:i+end]...)
+				c.buffer.Write(data[i+end:])
+				return len(p), nil
+			} else if partial {
+				c.pending = append([]byte{}, data[i:]...)
+				c.lineStart = true
+				return len(p), c.writeBody(data[:i])
+			}
+		}
+
+		next := bytes.IndexByte(data[i:], '\n')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	c.lineStart = len(data) > 0 && data[len(data)-1] == '\n'
+	return len(p), c.writeBody(data)
+}
+
+// matchMarker reports whether line starts with the marker line, the length of
+// that line, and whether line could still become one with more output.
+func (c *cgiTrailerWriter) matchMarker(line []byte) (int, bool, bool) {
+	for _, ending := range []string{"\n", "\r\n"} {
+		if bytes.HasPrefix(line, []byte(c.marker+ending)) {
+			return len(c.marker) + len(ending), true, false
+		}
+	}
+	return 0, false, strings.HasPrefix(c.marker+"\r\n", string(line))
+}
+
+func (c *cgiTrailerWriter) writeBody(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := c.body.Write(p)
+	return err
+}
+
+// abandon writes the marker and everything after it to the body when it
+// turns out not to be followed by trailers.
+func (c *cgiTrailerWriter) abandon() error {
+	c.inTrailers = false
+	c.passthrough = true
+
+	if err := c.writeBody(c.markerLine); err != nil {
+		return err
+	}
+	err := c.writeBody(c.buffer.Bytes())
+	c.buffer.Reset()
+	return err
+}
+
+// Close parses the trailers once the output has ended.
+func (c *cgiTrailerWriter) Close() error {
+	if len(c.pending) > 0 {
+		pending := c.pending
+		c.pending = nil
+		if strings.TrimSuffix(string(pending), "\r") != c.marker {
+			return c.writeBody(pending)
+		}
+		c.inTrailers = true
+		c.markerLine = pending
+	}
+
+	if !c.inTrailers {
+		return nil
+	}
+
+	block := bytes.TrimRight(c.buffer.Bytes(), "\r\n")
+	if len(block) == 0 {
+		c.inTrailers = false
+		return nil
+	}
+
+	trailers, status, ok := parseCGIHeaders(block)
+	if !ok || status != 0 {
+		return c.abandon()
+	}
+	c.trailers = trailers
+	return nil
+}
@@ -0,0 +1,353 @@
+package executor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func runWithResponseHeaders(t *testing.T, output string) (RunResult, string) {
+	f := ForkFunctionRunner{ParseResponseHeaders: true, MaxResponseHeaderBytes: 64}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "printf",
+		ProcessArgs:  []string{"%s", output},
+		OutputWriter: out,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	return result, out.String()
+}
+
+func TestForkFunctionRunner_ParseResponseHeaders_HeaderOnly(t *testing.T) {
+	result, body := runWithResponseHeaders(t, "Content-Type: application/json\nX-Custom: 1\n\n")
+
+	if body != "" {
+		t.Errorf("want empty body, got: %q", body)
+	}
+
+	if got := result.Headers.Get("Content-Type"); got != "application/json" {
+		t.Errorf("want Content-Type: application/json, got: %q", got)
+	}
+
+	if got := result.Headers.Get("X-Custom"); got != "1" {
+		t.Errorf("want X-Custom: 1, got: %q", got)
+	}
+}
+
+func TestForkFunctionRunner_ParseResponseHeaders_BodyOnly(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+	}{
+		{name: "No blank line", output: "hello world"},
+		{name: "Not a header", output: "hello world\n\nsecond paragraph"},
+		{name: "Beyond header limit", output: strings.Repeat("X-Long: value\n", 10) + "\nbody"},
+		{name: "Status below 100", output: "Status: 42\n\nbody"},
+		{name: "Status above 599", output: "Status: 1000\n\nbody"},
+	}
+
+	for _, testCase := range cases {
+		result, body := runWithResponseHeaders(t, testCase.output)
+
+		if body != testCase.output {
+			t.Errorf("(%s) want body: %q, got: %q", testCase.name, testCase.output, body)
+		}
+
+		if len(result.Headers) != 0 {
+			t.Errorf("(%s) want no headers, got: %v", testCase.name, result.Headers)
+		}
+	}
+}
+
+func TestForkFunctionRunner_ParseResponseHeaders_Mixed(t *testing.T) {
+	result, body := runWithResponseHeaders(t, "Status: 500 Internal Server Error\r\nContent-Type: text/plain\r\n\r\nsomething failed")
+
+	if body != "something failed" {
+		t.Errorf("want body: %q, got: %q", "something failed", body)
+	}
+
+	if result.HTTPStatus != 500 {
+		t.Errorf("want HTTPStatus: 500, got: %d", result.HTTPStatus)
+	}
+
+	if result.ExitCode != 0 {
+		t.Errorf("want ExitCode: 0, got: %d", result.ExitCode)
+	}
+
+	if result.BytesWritten != int64(len("something failed")) {
+		t.Errorf("want BytesWritten to count the body only, got: %d", result.BytesWritten)
+	}
+
+	if result.Headers.Get("Status") != "" {
+		t.Errorf("want Status to be removed from Headers, got: %v", result.Headers)
+	}
+}
+
+func TestForkFunctionRunner_ParseResponseHeaders_ResponseWriter(t *testing.T) {
+	f := ForkFunctionRunner{ParseResponseHeaders: true}
+
+	w := httptest.NewRecorder()
+	req := FunctionRequest{
+		Process:      "printf",
+		ProcessArgs:  []string{"%s", "Status: 201\nX-Custom: 1\n\ncreated"},
+		OutputWriter: w,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if w.Code != 201 {
+		t.Errorf("want status: 201, got: %d", w.Code)
+	}
+
+	if w.Header().Get("X-Custom") != "1" {
+		t.Errorf("want X-Custom header set on the response, got: %v", w.Header())
+	}
+
+	if w.Body.String() != "created" {
+		t.Errorf("want body: %q, got: %q", "created", w.Body.String())
+	}
+}
+
+func TestForkFunctionRunner_ParseResponseHeaders_InvalidStatus(t *testing.T) {
+	f := ForkFunctionRunner{ParseResponseHeaders: true}
+
+	output := "Status: 42\nX-Custom: 1\n\nbody"
+	w := httptest.NewRecorder()
+	req := FunctionRequest{
+		Process:      "printf",
+		ProcessArgs:  []string{"%s", output},
+		OutputWriter: w,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if w.Code != 200 {
+		t.Errorf("want status: 200, got: %d", w.Code)
+	}
+	if w.Body.String() != output {
+		t.Errorf("want the headers passed through as body %q, got: %q", output, w.Body.String())
+	}
+}
+
+func TestForkFunctionRunner_TrailerMarker(t *testing.T) {
+	cases := []struct {
+		name         string
+		script       string
+		wantBody     string
+		wantTrailers map[string]string
+	}{
+		{
+			name:         "trailers after body",
+			script:       `printf 'body line\n--trailers--\nGrpc-Status: 0\nGrpc-Message: ok\n'`,
+			wantBody:     "body line\n",
+			wantTrailers: map[string]string{"Grpc-Status": "0", "Grpc-Message": "ok"},
+		},
+		{
+			name:         "marker split across writes",
+			script:       `printf 'body\n--trail'; sleep 0.05; printf 'ers--\r\nX-Done: 1'`,
+			wantBody:     "body\n",
+			wantTrailers: map[string]string{"X-Done": "1"},
+		},
+		{
+			name:     "marker without trailers",
+			script:   `printf 'body\n--trailers--\n'`,
+			wantBody: "body\n",
+		},
+		{
+			name:     "marker within a line",
+			script:   `printf 'body --trailers--\nX-Done: 1\n'`,
+			wantBody: "body --trailers--\nX-Done: 1\n",
+		},
+		{
+			name:     "marker followed by body",
+			script:   `printf 'body\n--trailers--\nnot a trailer\n'`,
+			wantBody: "body\n--trailers--\nnot a trailer\n",
+		},
+		{
+			name:     "partial marker at end",
+			script:   `printf 'body\n--trail'`,
+			wantBody: "body\n--trail",
+		},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{TrailerMarker: "--trailers--"}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", c.script},
+			OutputWriter: out,
+		}
+
+		result, err := f.Run(req)
+		if err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+			continue
+		}
+
+		if out.String() != c.wantBody {
+			t.Errorf("(%s) want body %q, got: %q", c.name, c.wantBody, out.String())
+		}
+		if result.BytesWritten != int64(len(c.wantBody)) {
+			t.Errorf("(%s) want BytesWritten %d, got: %d", c.name, len(c.wantBody), result.BytesWritten)
+		}
+		if len(result.Trailers) != len(c.wantTrailers) {
+			t.Errorf("(%s) want trailers %v, got: %v", c.name, c.wantTrailers, result.Trailers)
+		}
+		for k, v := range c.wantTrailers {
+			if got := result.Trailers.Get(k); got != v {
+				t.Errorf("(%s) want trailer %s: %q, got: %q", c.name, k, v, got)
+			}
+		}
+	}
+}
+
+func TestForkFunctionRunner_TrailerMarker_ResponseWriter(t *testing.T) {
+	cases := []struct {
+		name        string
+		output      string
+		wantBody    string
+		wantTrailer string
+	}{
+		{name: "chunked response", output: "body\n--trailers--\nGrpc-Status: 0\n", wantBody: "body\n", wantTrailer: "0"},
+		{name: "fixed length response", output: "Content-Length: 33\n\nbody\n--trailers--\nGrpc-Status: 0\n", wantBody: "body\n--trailers--\nGrpc-Status: 0\n"},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{ParseResponseHeaders: true, TrailerMarker: "--trailers--"}
+		w := httptest.NewRecorder()
+		req := FunctionRequest{
+			Process:      "printf",
+			ProcessArgs:  []string{"%s", c.output},
+			OutputWriter: w,
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+
+		response := w.Result()
+		if w.Body.String() != c.wantBody {
+			t.Errorf("(%s) want body %q, got: %q", c.name, c.wantBody, w.Body.String())
+		}
+		if got := response.Trailer.Get("Grpc-Status"); got != c.wantTrailer {
+			t.Errorf("(%s) want Grpc-Status trailer %q, got: %q", c.name, c.wantTrailer, got)
+		}
+	}
+}
+
+func TestForkFunctionRunner_SniffContentType(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 16)
+
+	cases := []struct {
+		name            string
+		output          string
+		wantBody        string
+		wantContentType string
+	}{
+		{name: "sniffed text", output: "hello world", wantBody: "hello world", wantContentType: "text/plain; charset=utf-8"},
+		{name: "sniffed text after headers", output: "X-Custom: 1\n\n<html><body>hi</body></html>", wantBody: "<html><body>hi</body></html>", wantContentType: "text/html; charset=utf-8"},
+		{name: "sniffed binary", output: png, wantBody: png, wantContentType: "image/png"},
+		{name: "sniffed past the first 512 bytes", output: strings.Repeat("a", 600), wantBody: strings.Repeat("a", 600), wantContentType: "text/plain; charset=utf-8"},
+		{name: "explicit content type", output: "Content-Type: application/json\n\n" + png, wantBody: png, wantContentType: "application/json"},
+		{name: "empty body", output: "X-Custom: 1\n\n", wantBody: "", wantContentType: ""},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{ParseResponseHeaders: true, SniffContentType: true}
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		req := FunctionRequest{
+			Process:      "cat",
+			InputReader:  ioutil.NopCloser(strings.NewReader(c.output)),
+			OutputWriter: w,
+		}
+
+		result, err := f.Run(req)
+		if err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+
+		if w.Body.String() != c.wantBody {
+			t.Errorf("(%s) want body unchanged by sniffing %q, got: %q", c.name, c.wantBody, w.Body.String())
+		}
+		if got := result.Headers.Get("Content-Type"); got != c.wantContentType {
+			t.Errorf("(%s) want Content-Type %q, got: %q", c.name, c.wantContentType, got)
+		}
+		if len(c.wantContentType) > 0 && w.Header().Get("Content-Type") != c.wantContentType {
+			t.Errorf("(%s) want Content-Type %q on the response, got: %q", c.name, c.wantContentType, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestForkFunctionRunner_StatusLineMode(t *testing.T) {
+	cases := []struct {
+		name       string
+		output     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "status line", output: "HTTP 404\nnot found", wantStatus: 404, wantBody: "not found"},
+		{name: "with reason and CRLF", output: "HTTP 201 Created\r\ncreated", wantStatus: 201, wantBody: "created"},
+		{name: "status only", output: "HTTP 204\n", wantStatus: 204, wantBody: ""},
+		{name: "absent", output: "hello world\nsecond line", wantBody: "hello world\nsecond line"},
+		{name: "no newline", output: "HTTP 404", wantBody: "HTTP 404"},
+		{name: "malformed code", output: "HTTP 40x\nbody", wantBody: "HTTP 40x\nbody"},
+		{name: "out of range", output: "HTTP 999\nbody", wantBody: "HTTP 999\nbody"},
+		{name: "not first line", output: "body\nHTTP 404\n", wantBody: "body\nHTTP 404\n"},
+		{name: "long first line", output: "HTTP 200 " + strings.Repeat("x", 80) + "\nbody", wantBody: "HTTP 200 " + strings.Repeat("x", 80) + "\nbody"},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{StatusLineMode: true}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "printf",
+			ProcessArgs:  []string{"%s", c.output},
+			OutputWriter: out,
+		}
+
+		result, err := f.Run(req)
+		if err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+		if result.HTTPStatus != c.wantStatus {
+			t.Errorf("(%s) want HTTPStatus %d, got: %d", c.name, c.wantStatus, result.HTTPStatus)
+		}
+		if out.String() != c.wantBody {
+			t.Errorf("(%s) want body: %q, got: %q", c.name, c.wantBody, out.String())
+		}
+	}
+}
+
+func TestForkFunctionRunner_StatusLineMode_ResponseWriter(t *testing.T) {
+	f := ForkFunctionRunner{StatusLineMode: true}
+
+	w := httptest.NewRecorder()
+	req := FunctionRequest{
+		Process:      "printf",
+		ProcessArgs:  []string{"%s", "HTTP 418\nteapot"},
+		OutputWriter: w,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if w.Code != 418 {
+		t.Errorf("want status: 418, got: %d", w.Code)
+	}
+	if w.Body.String() != "teapot" {
+		t.Errorf("want body: %q, got: %q", "teapot", w.Body.String())
+	}
+}
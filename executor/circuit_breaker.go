@@ -0,0 +1,110 @@
+package executor
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a ForkFunctionRunner's circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every invocation through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects invocations with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe through to test for recovery.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitState returns the current state of the circuit breaker, it is always
+// CircuitClosed when FailureThreshold is not set.
+func (f *ForkFunctionRunner) CircuitState() CircuitState {
+	return f.breaker.current(f.OpenDuration)
+}
+
+// circuitBreaker counts consecutive failures, its zero value is closed.
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// current returns the state, reporting an open breaker as half-open once
+// openDuration has passed.
+func (b *circuitBreaker) current(openDuration time.Duration) CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= openDuration {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether an invocation may go ahead, when it does its outcome
+// must be passed to record.
+func (b *circuitBreaker) allow(openDuration time.Duration) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < openDuration {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of an allowed invocation.
+func (b *circuitBreaker) record(success bool, threshold int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.probing = false
+		if success {
+			b.state = CircuitClosed
+			b.failures = 0
+		} else {
+			b.state = CircuitOpen
+			b.openedAt = time.Now()
+		}
+		return
+	}
+
+	if success {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitClosed && b.failures >= threshold {
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,72 @@
+package executor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestForkFunctionRunner_Run_CircuitBreaker(t *testing.T) {
+	f := &ForkFunctionRunner{
+		FailureThreshold: 2,
+		OpenDuration:     100 * time.Millisecond,
+	}
+
+	invoke := func(script string) error {
+		_, err := f.Run(FunctionRequest{
+			Process:     "sh",
+			ProcessArgs: []string{"-c", script},
+		})
+		return err
+	}
+
+	steps := []struct {
+		name      string
+		script    string
+		wait      time.Duration
+		wantErr   error
+		wantState CircuitState
+	}{
+		{name: "first failure keeps it closed", script: "exit 1", wantState: CircuitClosed},
+		{name: "threshold opens it", script: "exit 1", wantState: CircuitOpen},
+		{name: "open rejects without running", script: "exit 0", wantErr: ErrCircuitOpen, wantState: CircuitOpen},
+		{name: "failed probe reopens it", script: "exit 1", wait: 150 * time.Millisecond, wantState: CircuitOpen},
+		{name: "reopened rejects", script: "exit 0", wantErr: ErrCircuitOpen, wantState: CircuitOpen},
+		{name: "successful probe closes it", script: "exit 0", wait: 150 * time.Millisecond, wantState: CircuitClosed},
+		{name: "failure count was reset", script: "exit 1", wantState: CircuitClosed},
+	}
+
+	for _, step := range steps {
+		if step.wait > 0 {
+			time.Sleep(step.wait)
+			if state := f.CircuitState(); state != CircuitHalfOpen {
+				t.Fatalf("(%s) want half-open after OpenDuration, got: %s", step.name, state)
+			}
+		}
+
+		err := invoke(step.script)
+		if step.wantErr != nil && !errors.Is(err, step.wantErr) {
+			t.Errorf("(%s) want error %v, got: %v", step.name, step.wantErr, err)
+		}
+		if state := f.CircuitState(); state != step.wantState {
+			t.Errorf("(%s) want state %s, got: %s", step.name, step.wantState, state)
+		}
+	}
+}
+
+func Test_circuitBreaker_SingleProbe(t *testing.T) {
+	b := &circuitBreaker{}
+	b.record(false, 1)
+
+	if !b.allow(0) {
+		t.Fatalf("want probe allowed after the open duration")
+	}
+	if b.allow(0) {
+		t.Errorf("want a second call rejected while the probe is in flight")
+	}
+
+	b.record(true, 1)
+	if !b.allow(0) || b.current(0) != CircuitClosed {
+		t.Errorf("want breaker closed after a successful probe, got: %s", b.current(0))
+	}
+}
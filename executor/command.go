@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"errors"
+	"strings"
+)
+
+// ParseCommand splits cmd into a process and its arguments the way a POSIX
+// shell would, without expanding variables or globs. Single quotes keep their
+// contents as they are, a backslash escapes the next character outside quotes
+// and ", \, $ and ` inside double quotes.
+func ParseCommand(cmd string) (string, []string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range cmd {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		return "", nil, errors.New("invalid command: trailing backslash")
+	}
+	if quote != 0 {
+		return "", nil, errors.New("invalid command: unbalanced " + string(quote) + " quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return "", nil, errors.New("invalid command: no process given")
+	}
+
+	return words[0], words[1:], nil
+}
+
+// NewFunctionRequest returns a FunctionRequest for cmd, see ParseCommand.
+func NewFunctionRequest(cmd string) (FunctionRequest, error) {
+	process, args, err := ParseCommand(cmd)
+	if err != nil {
+		return FunctionRequest{}, err
+	}
+
+	return FunctionRequest{
+		Process:     process,
+		ProcessArgs: args,
+	}, nil
+}
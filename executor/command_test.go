@@ -0,0 +1,66 @@
+package executor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		name        string
+		cmd         string
+		wantProcess string
+		wantArgs    []string
+		wantErr     string
+	}{
+		{name: "process only", cmd: "cat", wantProcess: "cat", wantArgs: []string{}},
+		{name: "arguments", cmd: "python index.py --flag", wantProcess: "python", wantArgs: []string{"index.py", "--flag"}},
+		{name: "repeated whitespace", cmd: "  node \t index.js  ", wantProcess: "node", wantArgs: []string{"index.js"}},
+		{name: "double quoted spaces", cmd: `sh -c "echo hello world"`, wantProcess: "sh", wantArgs: []string{"-c", "echo hello world"}},
+		{name: "single quotes are literal", cmd: `echo '$HOME \n "x"'`, wantProcess: "echo", wantArgs: []string{`$HOME \n "x"`}},
+		{name: "escaped space", cmd: `cat my\ file`, wantProcess: "cat", wantArgs: []string{"my file"}},
+		{name: "escapes in double quotes", cmd: `echo "a \"b\" \$c \d"`, wantProcess: "echo", wantArgs: []string{`a "b" $c \d`}},
+		{name: "adjacent quotes join", cmd: `echo a"b c"'d'`, wantProcess: "echo", wantArgs: []string{"ab cd"}},
+		{name: "empty quoted argument", cmd: `echo "" ''`, wantProcess: "echo", wantArgs: []string{"", ""}},
+		{name: "unbalanced double quote", cmd: `echo "hello`, wantErr: `unbalanced " quote`},
+		{name: "unbalanced single quote", cmd: `echo 'hello`, wantErr: "unbalanced ' quote"},
+		{name: "trailing backslash", cmd: `echo hello\`, wantErr: "trailing backslash"},
+		{name: "empty", cmd: "   ", wantErr: "no process given"},
+	}
+
+	for _, c := range cases {
+		process, args, err := ParseCommand(c.cmd)
+		if len(c.wantErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("(%s) want error %q, got: %v", c.name, c.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+			continue
+		}
+
+		if process != c.wantProcess {
+			t.Errorf("(%s) want process %q, got: %q", c.name, c.wantProcess, process)
+		}
+		if !reflect.DeepEqual(args, c.wantArgs) {
+			t.Errorf("(%s) want args %q, got: %q", c.name, c.wantArgs, args)
+		}
+	}
+}
+
+func TestNewFunctionRequest(t *testing.T) {
+	req, err := NewFunctionRequest(`sh -c "exit 0"`)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if req.Process != "sh" || !reflect.DeepEqual(req.ProcessArgs, []string{"-c", "exit 0"}) {
+		t.Errorf("want process and args from the command, got: %q %q", req.Process, req.ProcessArgs)
+	}
+
+	if _, err := NewFunctionRequest(`sh -c "exit 0`); err == nil {
+		t.Errorf("want error for malformed command")
+	}
+}
@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// compressWriter gzips the output once at least minBytes have been written,
+// smaller output is written as-is when the writer is closed. When the
+// destination is a http.ResponseWriter the Content-Encoding header is set
+// and any status is held back until it has been decided.
+type compressWriter struct {
+	writer         *countingWriter
+	responseWriter http.ResponseWriter
+	minBytes       int
+
+	buffer     bytes.Buffer
+	decided    bool
+	gzipWriter *gzip.Writer
+	status     int
+}
+
+func newCompressWriter(writer io.Writer, destination io.Writer, minBytes int) *compressWriter {
+	c := &compressWriter{
+		writer:   &countingWriter{writer: writer},
+		minBytes: minBytes,
+	}
+
+	if responseWriter, ok := destination.(http.ResponseWriter); ok {
+		c.responseWriter = responseWriter
+	}
+	return c
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.decided {
+		if c.gzipWriter != nil {
+			return c.gzipWriter.Write(p)
+		}
+		return c.writer.Write(p)
+	}
+
+	c.buffer.Write(p)
+	if c.buffer.Len() >= c.minBytes {
+		return len(p), c.decide(true)
+	}
+	return len(p), nil
+}
+
+// WriteHeader holds back status until the Content-Encoding is known.
+func (c *compressWriter) WriteHeader(status int) {
+	if c.decided {
+		c.responseWriter.WriteHeader(status)
+		return
+	}
+	c.status = status
+}
+
+// Close writes out anything still buffered and ends the gzip stream.
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if err := c.decide(false); err != nil {
+			return err
+		}
+	}
+
+	if c.gzipWriter != nil {
+		return c.gzipWriter.Close()
+	}
+	return nil
+}
+
+// Compressed is true once the output is being gzipped.
+func (c *compressWriter) Compressed() bool {
+	return c.gzipWriter != nil
+}
+
+func (c *compressWriter) decide(compress bool) error {
+	c.decided = true
+
+	if c.responseWriter != nil {
+		header := c.responseWriter.Header()
+
+		// Output the function has already encoded is left alone.
+		if len(header.Get("Content-Encoding")) > 0 {
+			compress = false
+		}
+
+		if compress {
+			header.Set("Content-Encoding", "gzip")
+			header.Add("Vary", "Accept-Encoding")
+			header.Del("Content-Length")
+		}
+
+		if c.status > 0 {
+			c.responseWriter.WriteHeader(c.status)
+		}
+	}
+
+	if compress {
+		c.gzipWriter = gzip.NewWriter(c.writer)
+	}
+
+	if c.buffer.Len() == 0 {
+		return nil
+	}
+
+	_, err := c.Write(c.buffer.Bytes())
+	c.buffer.Reset()
+	return err
+}
+
+// acceptsGzip is true when the request's Accept-Encoding header includes gzip.
+func acceptsGzip(headers map[string][]string) bool {
+	for _, value := range http.Header(headers).Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0])
+			if strings.EqualFold(encoding, "gzip") {
+				return true
+			}
+		}
+	}
+	return false
+}
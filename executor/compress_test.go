@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_CompressResponse(t *testing.T) {
+	large := strings.Repeat("hello world ", 100)
+
+	cases := []struct {
+		name           string
+		output         string
+		acceptEncoding string
+		wantCompressed bool
+	}{
+		{name: "large", output: large, acceptEncoding: "gzip, deflate", wantCompressed: true},
+		{name: "small", output: "small", acceptEncoding: "gzip"},
+		{name: "not accepted", output: large, acceptEncoding: "br"},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{
+			CompressResponse: true,
+			MinCompressBytes: 100,
+		}
+
+		recorder := httptest.NewRecorder()
+		req := FunctionRequest{
+			Process:      "printf",
+			ProcessArgs:  []string{"%s", c.output},
+			OutputWriter: recorder,
+			Headers: map[string][]string{
+				"Accept-Encoding": {c.acceptEncoding},
+			},
+		}
+
+		result, err := f.Run(req)
+		if err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+
+		if result.BytesWritten != int64(len(c.output)) {
+			t.Errorf("(%s) want BytesWritten %d, got: %d", c.name, len(c.output), result.BytesWritten)
+		}
+
+		body := recorder.Body.String()
+		if !c.wantCompressed {
+			if body != c.output {
+				t.Errorf("(%s) want uncompressed output %q, got: %q", c.name, c.output, body)
+			}
+			if result.CompressedBytes != 0 {
+				t.Errorf("(%s) want CompressedBytes 0, got: %d", c.name, result.CompressedBytes)
+			}
+			if encoding := recorder.Header().Get("Content-Encoding"); len(encoding) > 0 {
+				t.Errorf("(%s) want no Content-Encoding, got: %q", c.name, encoding)
+			}
+			continue
+		}
+
+		if encoding := recorder.Header().Get("Content-Encoding"); encoding != "gzip" {
+			t.Errorf("(%s) want Content-Encoding gzip, got: %q", c.name, encoding)
+		}
+		if result.CompressedBytes != int64(recorder.Body.Len()) {
+			t.Errorf("(%s) want CompressedBytes %d, got: %d", c.name, recorder.Body.Len(), result.CompressedBytes)
+		}
+
+		reader, err := gzip.NewReader(strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("(%s) want valid gzip, got: %s", c.name, err)
+		}
+		decompressed, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("(%s) want valid gzip, got: %s", c.name, err)
+		}
+		if string(decompressed) != c.output {
+			t.Errorf("(%s) want decompressed output %q, got: %q", c.name, c.output, decompressed)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_CompressResponseHoldsBackStatus(t *testing.T) {
+	f := ForkFunctionRunner{
+		CompressResponse:     true,
+		ParseResponseHeaders: true,
+	}
+
+	recorder := httptest.NewRecorder()
+	req := FunctionRequest{
+		Process:      "printf",
+		ProcessArgs:  []string{"Status: 201\n\ncreated"},
+		OutputWriter: recorder,
+		Headers: map[string][]string{
+			"Accept-Encoding": {"gzip"},
+		},
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if recorder.Code != 201 {
+		t.Errorf("want status 201, got: %d", recorder.Code)
+	}
+	if encoding := recorder.Result().Header.Get("Content-Encoding"); encoding != "gzip" {
+		t.Errorf("want Content-Encoding gzip sent with the status, got: %q", encoding)
+	}
+}
@@ -0,0 +1,38 @@
+package executor
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// maxContentTypeFileBytes bounds how much of a ContentTypeFile is read.
+const maxContentTypeFileBytes = 1024
+
+// contentTypeFile returns ContentTypeFile with {request_id} replaced by the
+// request's RequestID.
+func (f *ForkFunctionRunner) contentTypeFile(req FunctionRequest) string {
+	return strings.Replace(f.ContentTypeFile, "{request_id}", req.RequestID, -1)
+}
+
+// readContentTypeFile returns the trimmed content of path and removes it,
+// ok is false when it does not exist or is empty.
+func readContentTypeFile(path string) (contentType string, ok bool, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	defer os.Remove(path)
+	defer file.Close()
+
+	buf := make([]byte, maxContentTypeFileBytes)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", false, err
+	}
+
+	contentType = strings.TrimSpace(string(buf[:n]))
+	return contentType, len(contentType) > 0, nil
+}
@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_ContentTypeFile(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := []struct {
+		name   string
+		script string
+		stale  bool
+		want   string
+	}{
+		{name: "present", script: `printf 'application/json\n' > "$CT_DIR/ct-$X_Call_Id"; echo '{}'`, want: "application/json"},
+		{name: "absent", script: `echo '{}'`},
+		{name: "empty", script: `: > "$CT_DIR/ct-$X_Call_Id"`},
+		{name: "stale file removed", script: `echo '{}'`, stale: true},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{ContentTypeFile: filepath.Join(dir, "ct-{request_id}")}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", c.script},
+			Environment:  []string{"CT_DIR=" + dir},
+			OutputWriter: ioutil.Discard,
+			RequestID:    "call-1",
+		}
+
+		path := filepath.Join(dir, "ct-call-1")
+		if c.stale {
+			if err := ioutil.WriteFile(path, []byte("text/html"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result, err := f.Run(req)
+		if err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+
+		if got := result.Headers.Get("Content-Type"); got != c.want {
+			t.Errorf("(%s) want Content-Type %q, got: %q", c.name, c.want, got)
+		}
+		if len(c.want) == 0 && result.Headers != nil {
+			t.Errorf("(%s) want no headers, got: %v", c.name, result.Headers)
+		}
+		if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+			t.Errorf("(%s) want content type file removed, got: %v", c.name, statErr)
+		}
+	}
+}
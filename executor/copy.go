@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"io"
+	"sync"
+)
+
+// copyBufferSize matches the buffer io.Copy would otherwise allocate.
+const copyBufferSize = 32 * 1024
+
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// copyBuffered copies src to dst through a pooled buffer. The io.WriterTo and
+// io.ReaderFrom of pipes are hidden because they fall back to io.Copy, which
+// allocates a buffer of its own.
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// chunkBuffers holds a pool of buffers for each chunk size larger than
+// copyBufferSize, keyed by the size.
+var chunkBuffers sync.Map
+
+// copyChunked copies src to dst reading at most size bytes at a time, so
+// that each Write is no larger. A size of zero or less uses copyBuffered.
+func copyChunked(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		return copyBuffered(dst, src)
+	}
+
+	pool := &copyBuffers
+	if size > copyBufferSize {
+		shared, ok := chunkBuffers.Load(size)
+		if !ok {
+			shared, _ = chunkBuffers.LoadOrStore(size, &sync.Pool{
+				New: func() interface{} {
+					buf := make([]byte, size)
+					return &buf
+				},
+			})
+		}
+		pool = shared.(*sync.Pool)
+	}
+
+	buf := pool.Get().(*[]byte)
+	defer pool.Put(buf)
+
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, (*buf)[:size])
+}
@@ -0,0 +1,212 @@
+package executor
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_LargeOutput(t *testing.T) {
+	const size = 4*1024*1024 + 7
+
+	f := ForkFunctionRunner{}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "yes abcdefgh | head -c 4194311"},
+		OutputWriter: out,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	want := strings.Repeat("abcdefgh\n", size/9+1)[:size]
+	if out.String() != want {
+		t.Errorf("want %d bytes copied unchanged, got: %d bytes", size, out.Len())
+	}
+	if result.BytesWritten != size {
+		t.Errorf("want BytesWritten %d, got: %d", size, result.BytesWritten)
+	}
+}
+
+// benchmarkPipeCopy copies 1MB through a pipe with copy.
+func benchmarkPipeCopy(b *testing.B, copy func(io.Writer, io.Reader) (int64, error)) {
+	payload := bytes.Repeat([]byte("x"), 1024*1024)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			b.Fatal(err)
+		}
+		go func() {
+			w.Write(payload)
+			w.Close()
+		}()
+		if _, err := copy(struct{ io.Writer }{ioutil.Discard}, r); err != nil {
+			b.Fatal(err)
+		}
+		r.Close()
+	}
+}
+
+func Benchmark_copyBuffered(b *testing.B) {
+	benchmarkPipeCopy(b, copyBuffered)
+}
+
+func Benchmark_ioCopy(b *testing.B) {
+	benchmarkPipeCopy(b, io.Copy)
+}
+
+func TestForkFunctionRunner_Run_StdinBufferSize(t *testing.T) {
+	body := make([]byte, 256*1024+3)
+	for i := range body {
+		body[i] = byte(i * 7)
+	}
+
+	for _, size := range []int{0, 1, 7, 4096, 1024 * 1024} {
+		f := ForkFunctionRunner{StdinBufferSize: size}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "cat",
+			InputReader:  ioutil.NopCloser(&chunkedReader{data: body, size: 13}),
+			OutputWriter: out,
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("(%d) want no error, got: %s", size, err)
+		}
+		if !bytes.Equal(out.Bytes(), body) {
+			t.Errorf("(%d) want %d bytes delivered exactly, got: %d bytes", size, len(body), out.Len())
+		}
+	}
+}
+
+// benchmarkSmallChunkStdin sends 1MB to the process 64 bytes at a time.
+func benchmarkSmallChunkStdin(b *testing.B, bufferSize int) {
+	body := bytes.Repeat([]byte("x"), 1024*1024)
+	f := ForkFunctionRunner{StdinBufferSize: bufferSize}
+
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", "cat >/dev/null"},
+			InputReader:  ioutil.NopCloser(&chunkedReader{data: body, size: 64}),
+			OutputWriter: ioutil.Discard,
+		}
+		if _, err := f.Run(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_smallChunkStdin_Unbuffered(b *testing.B) {
+	benchmarkSmallChunkStdin(b, 0)
+}
+
+func Benchmark_smallChunkStdin_Buffered(b *testing.B) {
+	benchmarkSmallChunkStdin(b, 64*1024)
+}
+
+func TestForkFunctionRunner_Run_ResponseChunkSize(t *testing.T) {
+	const size = 10000
+
+	for _, chunkSize := range []int{1000, 4096} {
+		f := ForkFunctionRunner{ResponseChunkSize: chunkSize}
+		w := &recordingFlusher{}
+		req := FunctionRequest{
+			Process:      "head",
+			ProcessArgs:  []string{"-c", strconv.Itoa(size), "/dev/zero"},
+			OutputWriter: w,
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("(%d) want no error, got: %s", chunkSize, err)
+		}
+		if w.Len() != size {
+			t.Errorf("(%d) want %d bytes, got: %d", chunkSize, size, w.Len())
+		}
+
+		flushed := 0
+		for _, at := range w.flushedAt {
+			if chunk := at - flushed; chunk > chunkSize {
+				t.Errorf("(%d) want no chunk larger than %d, got: %d", chunkSize, chunkSize, chunk)
+			}
+			flushed = at
+		}
+		if flushed != size || len(w.flushedAt) < (size+chunkSize-1)/chunkSize {
+			t.Errorf("(%d) want %d bytes flushed in chunks of at most %d, got: %v", chunkSize, size, chunkSize, w.flushedAt)
+		}
+	}
+}
+
+func Test_copyChunked(t *testing.T) {
+	payload := bytes.Repeat([]byte("abcdefg"), 20000)
+
+	for _, size := range []int{0, 1000, copyBufferSize, 64 * 1024} {
+		w := &recordingWriter{}
+		n, err := copyChunked(w, bytes.NewReader(payload), size)
+		if err != nil || n != int64(len(payload)) || !bytes.Equal(w.Bytes(), payload) {
+			t.Errorf("(%d) want %d bytes copied, got: %d %v", size, len(payload), n, err)
+		}
+		for _, write := range w.writes {
+			if size > 0 && write > size {
+				t.Errorf("(%d) want no write larger than %d, got: %d", size, size, write)
+			}
+		}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			copyChunked(ioutil.Discard, bytes.NewReader(payload), size)
+		})
+		if allocs > 4 {
+			t.Errorf("(%d) want the buffer reused between copies, got %.0f allocations", size, allocs)
+		}
+	}
+}
+
+// recordingWriter records the size of each Write.
+type recordingWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (r *recordingWriter) Write(p []byte) (int, error) {
+	r.writes = append(r.writes, len(p))
+	return r.Buffer.Write(p)
+}
+
+// benchmarkResponseChunkSize copies 4MB of output to a flushing writer.
+func benchmarkResponseChunkSize(b *testing.B, chunkSize int) {
+	f := ForkFunctionRunner{ResponseChunkSize: chunkSize}
+
+	b.SetBytes(4 * 1024 * 1024)
+	for i := 0; i < b.N; i++ {
+		req := FunctionRequest{
+			Process:      "head",
+			ProcessArgs:  []string{"-c", "4194304", "/dev/zero"},
+			OutputWriter: &recordingFlusher{},
+		}
+		if _, err := f.Run(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_ResponseChunkSize_512(b *testing.B) {
+	benchmarkResponseChunkSize(b, 512)
+}
+
+func Benchmark_ResponseChunkSize_4KB(b *testing.B) {
+	benchmarkResponseChunkSize(b, 4*1024)
+}
+
+func Benchmark_ResponseChunkSize_64KB(b *testing.B) {
+	benchmarkResponseChunkSize(b, 64*1024)
+}
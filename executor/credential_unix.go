@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// checkCredential returns ErrRunAsNotPermitted when a process running as
+// euid and egid cannot switch to uid and gid, only root can change them.
+func checkCredential(uid, gid *uint32, euid, egid int) error {
+	if euid == 0 {
+		return nil
+	}
+	if uid != nil && int(*uid) != euid {
+		return fmt.Errorf("%w: uid %d, running as uid %d", ErrRunAsNotPermitted, *uid, euid)
+	}
+	if gid != nil && int(*gid) != egid {
+		return fmt.Errorf("%w: gid %d, running as gid %d", ErrRunAsNotPermitted, *gid, egid)
+	}
+	return nil
+}
+
+// setCredential runs the command as uid and gid, either of which defaults to
+// the watchdog's own when nil.
+func setCredential(cmd *exec.Cmd, uid, gid *uint32) error {
+	if uid == nil && gid == nil {
+		return nil
+	}
+
+	euid, egid := os.Geteuid(), os.Getegid()
+	if err := checkCredential(uid, gid, euid, egid); err != nil {
+		return err
+	}
+
+	credential := &syscall.Credential{
+		Uid: uint32(euid),
+		Gid: uint32(egid),
+		// Only root may clear the supplementary groups.
+		NoSetGroups: euid != 0,
+	}
+	if uid != nil {
+		credential.Uid = *uid
+	}
+	if gid != nil {
+		credential.Gid = *gid
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = credential
+	return nil
+}
@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_RunAsUID(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root to switch user")
+	}
+
+	uid, gid := uint32(65534), uint32(65534)
+	f := ForkFunctionRunner{RunAsUID: &uid, RunAsGID: &gid}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "id -u; id -g"},
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if got := strings.Fields(out.String()); len(got) != 2 || got[0] != "65534" || got[1] != "65534" {
+		t.Errorf("want uid and gid 65534, got: %q", out.String())
+	}
+}
+
+func Test_checkCredential(t *testing.T) {
+	other, same := uint32(65534), uint32(1000)
+
+	cases := []struct {
+		name    string
+		uid     *uint32
+		gid     *uint32
+		euid    int
+		wantErr bool
+	}{
+		{name: "root can switch", uid: &other, gid: &other, euid: 0},
+		{name: "same user", uid: &same, gid: &same, euid: 1000},
+		{name: "other uid", uid: &other, euid: 1000, wantErr: true},
+		{name: "other gid", gid: &other, euid: 1000, wantErr: true},
+	}
+
+	for _, c := range cases {
+		err := checkCredential(c.uid, c.gid, c.euid, 1000)
+		if c.wantErr && !errors.Is(err, ErrRunAsNotPermitted) {
+			t.Errorf("(%s) want ErrRunAsNotPermitted, got: %v", c.name, err)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+		}
+	}
+}
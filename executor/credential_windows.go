@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// setCredential is not supported on Windows.
+func setCredential(cmd *exec.Cmd, uid, gid *uint32) error {
+	if uid == nil && gid == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: not supported on Windows", ErrRunAsNotPermitted)
+}
@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NewForkFunctionRunnerFromEnv returns a ForkFunctionRunner with the process
+// settings found in env, a list of NAME=value pairs such as os.Environ():
+//
+//	exec_nice      Nice, from -20 to 19
+//	exec_uid       RunAsUID
+//	exec_gid       RunAsGID
+//	mem_limit      MemoryLimitBytes, in bytes or with a K, M or G suffix
+//	cpu_limit      CPUTimeLimitSeconds
+//
+// Any other variable is ignored, and an error names the first which is set
+// to a value that cannot be used.
+func NewForkFunctionRunnerFromEnv(env []string) (*ForkFunctionRunner, error) {
+	values := map[string]string{}
+	for _, e := range env {
+		if i := strings.Index(e, "="); i > 0 {
+			values[e[:i]] = e[i+1:]
+		}
+	}
+
+	f := &ForkFunctionRunner{}
+
+	if val, ok := values["exec_nice"]; ok {
+		nice, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exec_nice: %q is not a whole number", val)
+		}
+		if nice < -20 || nice > 19 {
+			return nil, fmt.Errorf("invalid exec_nice: %q must be between -20 and 19", val)
+		}
+		f.Nice = nice
+	}
+
+	for _, key := range []string{"exec_uid", "exec_gid"} {
+		val, ok := values[key]
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q is not a numeric id", key, val)
+		}
+		id32 := uint32(id)
+		if key == "exec_uid" {
+			f.RunAsUID = &id32
+		} else {
+			f.RunAsGID = &id32
+		}
+	}
+
+	if val, ok := values["mem_limit"]; ok {
+		limit, err := parseByteSize(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mem_limit: %q is not a size i.e. 134217728 or 128M", val)
+		}
+		f.MemoryLimitBytes = limit
+	}
+
+	if val, ok := values["cpu_limit"]; ok {
+		seconds, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu_limit: %q is not a whole number of seconds", val)
+		}
+		f.CPUTimeLimitSeconds = seconds
+	}
+
+	return f, nil
+}
+
+// parseByteSize parses a number of bytes with an optional K, M or G suffix,
+// or Ki, Mi or Gi, each a power of 1024.
+func parseByteSize(val string) (uint64, error) {
+	multipliers := []struct {
+		suffix string
+		scale  uint64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	}
+
+	scale := uint64(1)
+	for _, m := range multipliers {
+		if strings.HasSuffix(val, m.suffix) {
+			val, scale = strings.TrimSuffix(val, m.suffix), m.scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > ^uint64(0)/scale {
+		return 0, strconv.ErrRange
+	}
+	return n * scale, nil
+}
@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewForkFunctionRunnerFromEnv(t *testing.T) {
+	f, err := NewForkFunctionRunnerFromEnv([]string{
+		"exec_nice=10",
+		"exec_uid=1000",
+		"exec_gid=2000",
+		"mem_limit=128M",
+		"cpu_limit=30",
+		"PATH=/bin",
+	})
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if f.Nice != 10 {
+		t.Errorf("want Nice 10, got: %d", f.Nice)
+	}
+	if f.RunAsUID == nil || *f.RunAsUID != 1000 {
+		t.Errorf("want RunAsUID 1000, got: %v", f.RunAsUID)
+	}
+	if f.RunAsGID == nil || *f.RunAsGID != 2000 {
+		t.Errorf("want RunAsGID 2000, got: %v", f.RunAsGID)
+	}
+	if f.MemoryLimitBytes != 128*1024*1024 {
+		t.Errorf("want MemoryLimitBytes %d, got: %d", 128*1024*1024, f.MemoryLimitBytes)
+	}
+	if f.CPUTimeLimitSeconds != 30 {
+		t.Errorf("want CPUTimeLimitSeconds 30, got: %d", f.CPUTimeLimitSeconds)
+	}
+}
+
+func TestNewForkFunctionRunnerFromEnv_Unset(t *testing.T) {
+	f, err := NewForkFunctionRunnerFromEnv([]string{"PATH=/bin", "malformed"})
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if f.Nice != 0 || f.RunAsUID != nil || f.RunAsGID != nil || f.MemoryLimitBytes != 0 || f.CPUTimeLimitSeconds != 0 {
+		t.Errorf("want zero settings, got: %+v", f)
+	}
+}
+
+func TestNewForkFunctionRunnerFromEnv_Invalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     string
+		wantErr string
+	}{
+		{name: "nice not a number", env: "exec_nice=low", wantErr: "invalid exec_nice"},
+		{name: "nice out of range", env: "exec_nice=20", wantErr: "must be between -20 and 19"},
+		{name: "negative uid", env: "exec_uid=-1", wantErr: "invalid exec_uid"},
+		{name: "uid too large", env: "exec_uid=4294967296", wantErr: "invalid exec_uid"},
+		{name: "gid not a number", env: "exec_gid=staff", wantErr: "invalid exec_gid"},
+		{name: "unknown size suffix", env: "mem_limit=128MB", wantErr: "invalid mem_limit"},
+		{name: "size overflows", env: "mem_limit=20000000000G", wantErr: "invalid mem_limit"},
+		{name: "fractional cpu limit", env: "cpu_limit=1.5", wantErr: "invalid cpu_limit"},
+	}
+
+	for _, c := range cases {
+		_, err := NewForkFunctionRunnerFromEnv([]string{c.env})
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("(%s) want error containing %q, got: %v", c.name, c.wantErr, err)
+		}
+	}
+}
+
+func Test_parseByteSize(t *testing.T) {
+	cases := []struct {
+		val  string
+		want uint64
+	}{
+		{val: "0", want: 0},
+		{val: "512", want: 512},
+		{val: "2K", want: 2 << 10},
+		{val: "64Mi", want: 64 << 20},
+		{val: "1G", want: 1 << 30},
+	}
+
+	for _, c := range cases {
+		got, err := parseByteSize(c.val)
+		if err != nil || got != c.want {
+			t.Errorf("(%s) want %d, got: %d, %v", c.val, c.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// envTemplateFuncs are the only functions EnvTemplates can call besides the
+// text/template builtins.
+var envTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// envTemplateData is what an EnvTemplates value is rendered with.
+type envTemplateData struct {
+	Method      string
+	Path        string
+	QueryString string
+	RequestID   string
+
+	headers http.Header
+}
+
+// Header returns the first value of the named request header, or an empty
+// string when it was not sent.
+func (d envTemplateData) Header(name string) string {
+	return d.headers.Get(name)
+}
+
+// parsedEnvTemplates is EnvTemplates parsed once and sorted by name.
+type parsedEnvTemplates struct {
+	names     []string
+	templates map[string]*template.Template
+	err       error
+}
+
+func parseEnvTemplates(envTemplates map[string]string) parsedEnvTemplates {
+	parsed := parsedEnvTemplates{templates: make(map[string]*template.Template, len(envTemplates))}
+
+	for name, text := range envTemplates {
+		tmpl, err := template.New(name).Funcs(envTemplateFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return parsedEnvTemplates{err: fmt.Errorf("invalid env template %s: %w", name, err)}
+		}
+		parsed.names = append(parsed.names, name)
+		parsed.templates[name] = tmpl
+	}
+	sort.Strings(parsed.names)
+	return parsed
+}
+
+// renderEnvTemplates adds the rendered EnvTemplates to req.Environment,
+// which inherits the watchdog's own environment when it is nil.
+func (f *ForkFunctionRunner) renderEnvTemplates(req *FunctionRequest) error {
+	if len(f.EnvTemplates) == 0 {
+		return nil
+	}
+
+	f.envTemplatesOnce.Do(func() {
+		f.envTemplates = parseEnvTemplates(f.EnvTemplates)
+	})
+	parsed := f.envTemplates
+	if parsed.err != nil {
+		return parsed.err
+	}
+
+	data := envTemplateData{
+		Method:      req.Method,
+		Path:        req.Path,
+		QueryString: req.QueryString,
+		RequestID:   req.RequestID,
+		headers:     http.Header(req.Headers),
+	}
+
+	envs := req.Environment
+	if envs == nil {
+		envs = os.Environ()
+	}
+	envs = append([]string{}, envs...)
+
+	value := &bytes.Buffer{}
+	for _, name := range parsed.names {
+		value.Reset()
+		if err := parsed.templates[name].Execute(value, data); err != nil {
+			return fmt.Errorf("unable to render env template %s: %w", name, err)
+		}
+		envs = append(envs, name+"="+value.String())
+	}
+
+	req.Environment = envs
+	return nil
+}
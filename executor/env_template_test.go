@@ -0,0 +1,92 @@
+package executor
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_EnvTemplates(t *testing.T) {
+	cases := []struct {
+		name      string
+		templates map[string]string
+		headers   map[string][]string
+		want      []string
+	}{
+		{
+			name: "request values",
+			templates: map[string]string{
+				"ROUTE":  "{{.Method}} {{.Path}}",
+				"TENANT": `{{.Header "X-Tenant" | lower}}`,
+				"CALL":   "{{.RequestID}}?{{.QueryString}}",
+			},
+			headers: map[string][]string{"X-Tenant": {"ACME", "other"}},
+			want:    []string{"CALL=call-1?a=1", "ROUTE=GET /function/env", "TENANT=acme"},
+		},
+		{
+			name:      "missing header renders empty",
+			templates: map[string]string{"TENANT": `tenant-{{.Header "X-Tenant"}}`},
+			want:      []string{"TENANT=tenant-"},
+		},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{EnvTemplates: c.templates}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "env",
+			Environment:  []string{},
+			Headers:      c.headers,
+			Method:       "GET",
+			Path:         "/function/env",
+			QueryString:  "a=1",
+			RequestID:    "call-1",
+			OutputWriter: out,
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+
+		// The rendered variables come before those describing the request.
+		got := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(got) > len(c.want) {
+			got = got[:len(c.want)]
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("(%s) want %q, got: %q", c.name, c.want, got)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_MalformedEnvTemplate(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+	}{
+		{name: "unterminated action", template: "{{.Path"},
+		{name: "unknown function", template: "{{exec .Path}}"},
+		{name: "unknown field", template: "{{.Body}}"},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{
+			EnvTemplates: map[string]string{"BROKEN": c.template},
+		}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "env",
+			Environment:  []string{},
+			OutputWriter: out,
+		}
+
+		_, err := f.Run(req)
+		if err == nil || !strings.Contains(err.Error(), "env template BROKEN") {
+			t.Errorf("(%s) want env template error, got: %v", c.name, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("(%s) want process not started, got output: %q", c.name, out.String())
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package executor
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// buildEnvironment returns the environment for the process, adding CGI-style
+// Http_ variables for the request's headers, method, path and query, and
+// X_Call_Id for its RequestID, and secret_<name>_path for each of
+// SecretMounts. When req.Environment is nil the watchdog's own
+// environment is inherited, either is merged over BaseEnvironment, renamed
+// by EnvRename then filtered by EnvAllowPrefixes and EnvDenyPrefixes first.
+func (f *ForkFunctionRunner) buildEnvironment(req FunctionRequest) []string {
+	if len(req.Headers) == 0 && len(req.Method) == 0 && len(req.Path) == 0 && len(req.QueryString) == 0 && len(req.RequestID) == 0 && len(f.SecretMounts) == 0 &&
+		len(f.EnvAllowPrefixes) == 0 && len(f.EnvDenyPrefixes) == 0 && len(f.EnvRename) == 0 && len(f.BaseEnvironment) == 0 {
+		return req.Environment
+	}
+
+	envs := req.Environment
+	if envs == nil {
+		envs = os.Environ()
+	}
+	if len(f.BaseEnvironment) > 0 {
+		envs = mergeEnvironment(f.BaseEnvironment, envs)
+	}
+	envs = renameEnvironment(envs, f.EnvRename, f.EnvRenameDropOriginal)
+	envs = filterEnvironment(envs, f.EnvAllowPrefixes, f.EnvDenyPrefixes)
+
+	keys := make([]string, 0, len(req.Headers))
+	for k := range req.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if v := req.Headers[k]; len(v) > 0 {
+			envs = append(envs, fmt.Sprintf("Http_%s=%s", strings.Replace(k, "-", "_", -1), v[0]))
+		}
+	}
+
+	if len(req.Method) > 0 {
+		envs = append(envs, fmt.Sprintf("Http_Method=%s", req.Method))
+	}
+
+	if len(req.Path) > 0 {
+		envs = append(envs, fmt.Sprintf("Http_Path=%s", req.Path))
+	}
+
+	if len(req.QueryString) > 0 {
+		envs = append(envs, fmt.Sprintf("Http_Query=%s", req.QueryString))
+		envs = append(envs, fmt.Sprintf("QUERY_STRING=%s", req.QueryString))
+
+		if f.ExplodeQueryParams {
+			envs = append(envs, queryParamEnvironment(req.QueryString)...)
+		}
+	}
+
+	if len(req.RequestID) > 0 {
+		envs = append(envs, fmt.Sprintf("X_Call_Id=%s", req.RequestID))
+	}
+
+	envs = append(envs, secretEnvironment(f.SecretMounts)...)
+
+	return envs
+}
+
+// queryParamEnvironment decodes query into Http_Param_<name> variables with
+// "-" in names replaced by "_". Only the first value of a repeated parameter
+// is used, and when two names map to the same variable the first in sorted
+// order wins. Parameters which cannot be represented are skipped.
+func queryParamEnvironment(query string) []string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		// ParseQuery keeps the parameters it could decode.
+		logRequest("", "Skipping undecodable query parameters: %s", err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var envs []string
+	seen := map[string]bool{}
+	for _, k := range keys {
+		v := values[k]
+		if len(k) == 0 || strings.ContainsAny(k, "=\x00") || strings.Contains(v[0], "\x00") {
+			continue
+		}
+
+		name := "Http_Param_" + strings.Replace(k, "-", "_", -1)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		envs = append(envs, fmt.Sprintf("%s=%s", name, v[0]))
+	}
+	return envs
+}
+
+// mergeEnvironment returns base with the variables of envs added, where a
+// name is set more than once the last value wins and is kept at the
+// position the name was first given.
+func mergeEnvironment(base []string, envs []string) []string {
+	merged := make([]string, 0, len(base)+len(envs))
+	index := map[string]int{}
+	for _, env := range append(append([]string{}, base...), envs...) {
+		name := env
+		if i := strings.Index(env, "="); i >= 0 {
+			name = env[:i]
+		}
+
+		if i, ok := index[name]; ok {
+			merged[i] = env
+			continue
+		}
+		index[name] = len(merged)
+		merged = append(merged, env)
+	}
+	return merged
+}
+
+// renameEnvironment returns a copy of envs with each variable named by a key
+// of rename also exposed under its value, replacing any variable already
+// called that. The originals are removed when dropOriginal is set, and
+// names which are not in envs are skipped.
+func renameEnvironment(envs []string, rename map[string]string, dropOriginal bool) []string {
+	if len(rename) == 0 {
+		return envs
+	}
+
+	values := map[string]string{}
+	for _, env := range envs {
+		if i := strings.Index(env, "="); i >= 0 {
+			if _, ok := rename[env[:i]]; ok {
+				values[env[:i]] = env[i+1:]
+			}
+		}
+	}
+
+	sources := make([]string, 0, len(values))
+	replaced := map[string]bool{}
+	for source := range values {
+		sources = append(sources, source)
+		replaced[rename[source]] = true
+		if dropOriginal {
+			replaced[source] = true
+		}
+	}
+	sort.Strings(sources)
+
+	renamed := make([]string, 0, len(envs)+len(sources))
+	for _, env := range envs {
+		name := env
+		if i := strings.Index(env, "="); i >= 0 {
+			name = env[:i]
+		}
+		if !replaced[name] {
+			renamed = append(renamed, env)
+		}
+	}
+	for _, source := range sources {
+		renamed = append(renamed, rename[source]+"="+values[source])
+	}
+	return renamed
+}
+
+// filterEnvironment returns a copy of envs with the variables whose names
+// match allow, when it is set, and do not match deny.
+func filterEnvironment(envs []string, allow []string, deny []string) []string {
+	filtered := make([]string, 0, len(envs))
+	for _, env := range envs {
+		name := env
+		if i := strings.Index(env, "="); i >= 0 {
+			name = env[:i]
+		}
+
+		if len(allow) > 0 && !hasAnyPrefix(name, allow) {
+			continue
+		}
+		if hasAnyPrefix(name, deny) {
+			continue
+		}
+		filtered = append(filtered, env)
+	}
+	return filtered
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutHeader returns a copy of headers with name removed.
+func withoutHeader(headers map[string][]string, name string) map[string][]string {
+	copied := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		if !strings.EqualFold(k, name) {
+			copied[k] = v
+		}
+	}
+	return copied
+}
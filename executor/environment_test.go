@@ -0,0 +1,219 @@
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_filterEnvironment(t *testing.T) {
+	envs := []string{"PATH=/bin", "HOME=/root", "AWS_SECRET=x", "AWS_REGION=eu", "fprocess=cat"}
+
+	cases := []struct {
+		name  string
+		allow []string
+		deny  []string
+		want  []string
+	}{
+		{name: "allow only", allow: []string{"PATH", "AWS_"}, want: []string{"PATH=/bin", "AWS_SECRET=x", "AWS_REGION=eu"}},
+		{name: "deny only", deny: []string{"AWS_"}, want: []string{"PATH=/bin", "HOME=/root", "fprocess=cat"}},
+		{name: "deny takes precedence", allow: []string{"AWS_"}, deny: []string{"AWS_SECRET"}, want: []string{"AWS_REGION=eu"}},
+		{name: "prefix matches the name only", allow: []string{"/bin"}, want: []string{}},
+	}
+
+	for _, c := range cases {
+		got := filterEnvironment(envs, c.allow, c.deny)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("(%s) want %q, got: %q", c.name, c.want, got)
+		}
+	}
+}
+
+func Test_renameEnvironment(t *testing.T) {
+	envs := []string{"FUNCTION_NAME=resize", "FN=stale", "HOME=/root", "EMPTY="}
+
+	cases := []struct {
+		name         string
+		rename       map[string]string
+		dropOriginal bool
+		want         []string
+	}{
+		{name: "keeps original", rename: map[string]string{"FUNCTION_NAME": "FN"}, want: []string{"FUNCTION_NAME=resize", "HOME=/root", "EMPTY=", "FN=resize"}},
+		{name: "drops original", rename: map[string]string{"FUNCTION_NAME": "FN"}, dropOriginal: true, want: []string{"HOME=/root", "EMPTY=", "FN=resize"}},
+		{name: "missing source", rename: map[string]string{"NOT_SET": "FN"}, dropOriginal: true, want: envs},
+		{name: "empty value", rename: map[string]string{"EMPTY": "BLANK"}, want: append(append([]string{}, envs...), "BLANK=")},
+	}
+
+	for _, c := range cases {
+		got := renameEnvironment(envs, c.rename, c.dropOriginal)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("(%s) want %q, got: %q", c.name, c.want, got)
+		}
+	}
+}
+
+func Test_mergeEnvironment(t *testing.T) {
+	cases := []struct {
+		name string
+		base []string
+		envs []string
+		want []string
+	}{
+		{name: "base only", base: []string{"A=1", "B=2"}, envs: []string{}, want: []string{"A=1", "B=2"}},
+		{name: "request only", envs: []string{"A=1", "B=2"}, want: []string{"A=1", "B=2"}},
+		{name: "request wins", base: []string{"A=base", "B=2"}, envs: []string{"C=3", "A=request"}, want: []string{"A=request", "B=2", "C=3"}},
+		{name: "last wins within one", base: []string{"A=1", "A=2"}, envs: []string{"B=1", "B=2"}, want: []string{"A=2", "B=2"}},
+		{name: "empty value overrides", base: []string{"A=1"}, envs: []string{"A="}, want: []string{"A="}},
+	}
+
+	for _, c := range cases {
+		got := mergeEnvironment(c.base, c.envs)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("(%s) want %q, got: %q", c.name, c.want, got)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_BaseEnvironment(t *testing.T) {
+	cases := []struct {
+		name string
+		base []string
+		envs []string
+		want []string
+	}{
+		{name: "base only", base: []string{"MODE=prod", "REGION=eu"}, envs: []string{}, want: []string{"MODE=prod", "REGION=eu"}},
+		{name: "request only", envs: []string{"MODE=dev"}, want: []string{"MODE=dev"}},
+		{name: "request overrides base", base: []string{"MODE=prod", "REGION=eu"}, envs: []string{"MODE=dev"}, want: []string{"MODE=dev", "REGION=eu"}},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{BaseEnvironment: c.base}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "env",
+			Environment:  c.envs,
+			OutputWriter: out,
+			RequestID:    "call-1",
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+
+		got := strings.Split(strings.TrimSpace(out.String()), "\n")
+		want := append(c.want, "X_Call_Id=call-1")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("(%s) want %q, got: %q", c.name, want, got)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_EnvRename(t *testing.T) {
+	f := ForkFunctionRunner{
+		EnvRename:             map[string]string{"FUNCTION_NAME": "FN", "NOT_SET": "MISSING"},
+		EnvRenameDropOriginal: true,
+	}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "env",
+		Environment:  []string{"FUNCTION_NAME=resize", "KEEP=1"},
+		OutputWriter: out,
+		RequestID:    "call-1",
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{"KEEP=1", "FN=resize", "X_Call_Id=call-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got: %q", want, got)
+	}
+}
+
+func TestForkFunctionRunner_Run_EnvironmentFilterSkipsRequestVariables(t *testing.T) {
+	f := ForkFunctionRunner{
+		EnvAllowPrefixes: []string{"KEEP_"},
+	}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "env",
+		Environment:  []string{"KEEP_ME=1", "DROP_ME=1"},
+		Method:       "POST",
+		OutputWriter: out,
+		RequestID:    "call-1",
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{"KEEP_ME=1", "Http_Method=POST", "X_Call_Id=call-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got: %q", want, got)
+	}
+}
+
+func Test_buildEnvironment_UnchangedWithoutFilters(t *testing.T) {
+	f := ForkFunctionRunner{}
+	req := FunctionRequest{
+		Environment: []string{"A=1", "B=2"},
+	}
+
+	got := f.buildEnvironment(req)
+	if !reflect.DeepEqual(got, req.Environment) {
+		t.Errorf("want environment passed unchanged, got: %q", got)
+	}
+}
+
+func TestForkFunctionRunner_Run_MaxEnvBytes(t *testing.T) {
+	// Over 2MB in total, which would otherwise fail in exec with
+	// "argument list too long".
+	var oversized []string
+	for i := 0; i < 64; i++ {
+		oversized = append(oversized, fmt.Sprintf("VAR_%d=%s", i, strings.Repeat("x", 32*1024)))
+	}
+
+	cases := []struct {
+		name        string
+		maxEnvBytes int
+		envs        []string
+		wantErr     error
+	}{
+		{name: "default limit", envs: append(oversized, "A=1"), wantErr: ErrEnvTooLarge},
+		{name: "custom limit", maxEnvBytes: 128, envs: []string{"A=" + strings.Repeat("x", 128)}, wantErr: ErrEnvTooLarge},
+		{name: "within custom limit", maxEnvBytes: 128, envs: []string{"A=1", "B=2"}},
+		{name: "disabled", maxEnvBytes: -1, envs: []string{"A=" + strings.Repeat("x", 32*1024)}},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{MaxEnvBytes: c.maxEnvBytes}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", "echo started"},
+			Environment:  c.envs,
+			OutputWriter: out,
+		}
+
+		_, err := f.Run(req)
+		if c.wantErr == nil {
+			if err != nil {
+				t.Errorf("(%s) want no error, got: %s", c.name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("(%s) want process not started, got output: %q", c.name, out.String())
+		}
+	}
+}
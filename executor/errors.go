@@ -0,0 +1,114 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrResponseTooLarge is returned when a function writes more than the configured maximum response size
+	ErrResponseTooLarge = errors.New("function response too large")
+
+	// ErrTooManyRequests is returned when MaxInflight invocations are already running
+	ErrTooManyRequests = errors.New("too many requests in flight")
+
+	// ErrReadTimeout is returned when reading the request body stalls for longer than the ReadTimeout
+	ErrReadTimeout = errors.New("timeout reading request body")
+
+	// ErrWriteTimeout is returned when writing the response stalls for longer than the WriteTimeout
+	ErrWriteTimeout = errors.New("timeout writing response")
+
+	// ErrNotFound is returned when a static file does not exist
+	ErrNotFound = errors.New("file not found")
+
+	// ErrPathTraversal is returned when a request path tries to escape the runner's root
+	ErrPathTraversal = errors.New("path traversal is not allowed")
+
+	// ErrRequestTooLarge is returned when a request body is larger than the configured maximum request size
+	ErrRequestTooLarge = errors.New("request body too large")
+
+	// ErrShuttingDown is returned by Run once Shutdown has been called
+	ErrShuttingDown = errors.New("runner is shutting down")
+
+	// ErrRunnerClosed is returned by Run once Close has been called
+	ErrRunnerClosed = errors.New("runner has been closed")
+
+	// ErrInvalidRequestEncoding is returned when a compressed request body cannot be decoded
+	ErrInvalidRequestEncoding = errors.New("request body could not be decoded")
+
+	// ErrRunAsNotPermitted is returned when the watchdog cannot switch to RunAsUID or RunAsGID
+	ErrRunAsNotPermitted = errors.New("not permitted to run the function as the requested user")
+
+	// ErrResourceLimit is returned when the process is stopped for exceeding MemoryLimitBytes or CPUTimeLimitSeconds
+	ErrResourceLimit = errors.New("function exceeded its resource limits")
+
+	// ErrBodyNotReplayable is returned when a request would be retried but its body was not buffered
+	ErrBodyNotReplayable = errors.New("request body cannot be replayed for a retry")
+
+	// ErrCircuitOpen is returned by Run while the circuit breaker is open after repeated failures
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrRateLimited is returned by Run when RateLimit has been reached and WaitForToken is not set
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrNiceNotPermitted is returned when the watchdog is not permitted to give the process the requested Nice value
+	ErrNiceNotPermitted = errors.New("not permitted to set the requested niceness")
+
+	// ErrMalformedResponse is returned when a framed function does not write a valid JSON response envelope
+	ErrMalformedResponse = errors.New("function response is not a valid envelope")
+
+	// ErrIdempotencyConflict is returned when a request shares its IdempotencyKey with an in-flight invocation but has a different body
+	ErrIdempotencyConflict = errors.New("request differs from the in-flight invocation with the same idempotency key")
+
+	// ErrShortBody is returned when the request body ends before its ContentLength
+	ErrShortBody = errors.New("request body is shorter than its Content-Length")
+
+	// ErrLongBody is returned when the request body continues past its ContentLength
+	ErrLongBody = errors.New("request body is longer than its Content-Length")
+
+	// ErrKilledBySignal is returned when the process is terminated by a signal it did not handle, see RunResult.Signal
+	ErrKilledBySignal = errors.New("function killed by a signal")
+
+	// ErrBatchRequestFailed is returned when the batch process reports that it failed to handle one request of the batch
+	ErrBatchRequestFailed = errors.New("function failed to handle the request")
+
+	// ErrQueueTimeout is returned when the request's context is done while it waits for a MaxInflight slot
+	ErrQueueTimeout = errors.New("timed out waiting for a free inflight slot")
+
+	// ErrStdinStalled is returned when the process does not read from stdin for longer than the StdinStallTimeout
+	ErrStdinStalled = errors.New("function stopped reading stdin")
+
+	// ErrEnvTooLarge is returned when the environment for the process is larger than the MaxEnvBytes
+	ErrEnvTooLarge = errors.New("environment too large")
+
+	// ErrRestartRequired is returned by Reconfigure for a setting which cannot be changed while the runner is running
+	ErrRestartRequired = errors.New("setting cannot be changed without a restart")
+
+	// ErrQueueFull is returned when MaxInflight invocations are running and MaxQueueLength more are already waiting
+	ErrQueueFull = errors.New("queue of requests waiting for an inflight slot is full")
+
+	// ErrLifetimeReached is returned by Run once the function has been run MaxLifetimeInvocations times
+	ErrLifetimeReached = errors.New("runner has served its maximum number of invocations")
+
+	// ErrPanic is returned by Run when the invocation panicked, the panic and its stack are logged
+	ErrPanic = errors.New("function runner panicked")
+)
+
+// ExecTimeoutError is returned when an invocation is killed as its exec
+// timeout, or the deadline of its context, expired. It wraps
+// context.DeadlineExceeded, and a caller may use BytesWritten to decide
+// whether to flush the partial output which was already written.
+type ExecTimeoutError struct {
+	Elapsed      time.Duration
+	BytesWritten int64
+}
+
+func (e *ExecTimeoutError) Error() string {
+	return fmt.Sprintf("function killed: %s after %s, %d bytes written", context.DeadlineExceeded, e.Elapsed.Round(time.Millisecond), e.BytesWritten)
+}
+
+func (e *ExecTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// signalReasons explains the signals a function is most often killed by.
+var signalReasons = map[syscall.Signal]string{
+	syscall.SIGSEGV: "SIGSEGV (segmentation fault)",
+	syscall.SIGBUS:  "SIGBUS (bus error)",
+	syscall.SIGABRT: "SIGABRT (aborted)",
+	syscall.SIGFPE:  "SIGFPE (floating point exception)",
+	syscall.SIGILL:  "SIGILL (illegal instruction)",
+	syscall.SIGKILL: "SIGKILL (killed, possibly by the out of memory killer)",
+	syscall.SIGTERM: "SIGTERM (terminated)",
+	syscall.SIGXCPU: "SIGXCPU (CPU time limit exceeded)",
+}
+
+// exitSignal returns the signal which terminated the process, or zero when
+// it exited normally.
+func exitSignal(state *os.ProcessState) syscall.Signal {
+	if state == nil {
+		return 0
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal()
+	}
+	return 0
+}
+
+// signalReason describes sig for an error message.
+func signalReason(sig syscall.Signal) string {
+	if reason, ok := signalReasons[sig]; ok {
+		return reason
+	}
+	return fmt.Sprintf("signal %d (%s)", int(sig), sig)
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_ReportsExitSignal(t *testing.T) {
+	cases := []struct {
+		name       string
+		script     string
+		wantSignal syscall.Signal
+		wantReason string
+	}{
+		{name: "segmentation fault", script: "kill -SEGV $$", wantSignal: syscall.SIGSEGV, wantReason: "SIGSEGV (segmentation fault)"},
+		{name: "killed", script: "kill -KILL $$", wantSignal: syscall.SIGKILL, wantReason: "out of memory"},
+		{name: "aborted", script: "kill -ABRT $$", wantSignal: syscall.SIGABRT, wantReason: "SIGABRT (aborted)"},
+		{name: "unlisted signal", script: "kill -USR2 $$", wantSignal: syscall.SIGUSR2, wantReason: "user defined signal 2"},
+		{name: "exit code", script: "exit 3"},
+	}
+
+	for _, c := range cases {
+		f := &ForkFunctionRunner{}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", c.script},
+			OutputWriter: ioutil.Discard,
+		}
+
+		result, err := f.Run(req)
+		if err == nil {
+			t.Fatalf("(%s) want an error, got none", c.name)
+		}
+		if result.Signal != c.wantSignal {
+			t.Errorf("(%s) want signal %d, got: %d", c.name, c.wantSignal, result.Signal)
+		}
+
+		if errors.Is(err, ErrKilledBySignal) != (c.wantSignal != 0) {
+			t.Errorf("(%s) want ErrKilledBySignal: %t, got: %v", c.name, c.wantSignal != 0, err)
+		}
+		if !strings.Contains(err.Error(), c.wantReason) {
+			t.Errorf("(%s) want reason %q in error, got: %s", c.name, c.wantReason, err)
+		}
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Errorf("(%s) want wrapped *exec.ExitError, got: %T", c.name, err)
+		}
+	}
+}
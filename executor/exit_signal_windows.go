@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// exitSignal is always zero on Windows, where processes are not terminated by signals.
+func exitSignal(state *os.ProcessState) syscall.Signal {
+	return 0
+}
+
+// signalReason describes sig for an error message.
+func signalReason(sig syscall.Signal) string {
+	return fmt.Sprintf("signal %d (%s)", int(sig), sig)
+}
@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// FramedForkFunctionRunner forks a process for each invocation and exchanges
+// a single JSON envelope with it. The process reads a framedRequest from stdin
+// and writes a framedResponse to stdout, whose status and headers are applied
+// to the response when req.OutputWriter is an http.ResponseWriter.
+type FramedForkFunctionRunner struct {
+	ExecTimeout time.Duration
+
+	// MaxResponseBytes aborts the invocation once the response envelope grows beyond it, zero means no limit.
+	MaxResponseBytes int64
+}
+
+// framedRequest is written to the process' stdin
+type framedRequest struct {
+	Headers    http.Header `json:"headers"`
+	BodyBase64 string      `json:"body_base64"`
+}
+
+// framedResponse is read from the process' stdout, Status defaults to 200
+// when it is left out
+type framedResponse struct {
+	Status     *int        `json:"status,omitempty"`
+	Headers    http.Header `json:"headers"`
+	BodyBase64 string      `json:"body_base64"`
+}
+
+// Run calls RunContext with req.Context, or context.Background() when it is not set
+func (f *FramedForkFunctionRunner) Run(req FunctionRequest) (RunResult, error) {
+	return f.RunContext(requestContext(req), req)
+}
+
+// RunContext wraps the request body in an envelope for the process and
+// writes the body of the envelope it returns to req.OutputWriter
+func (f *FramedForkFunctionRunner) RunContext(ctx context.Context, req FunctionRequest) (RunResult, error) {
+	req.Context = ctx
+
+	var body []byte
+	if req.InputReader != nil {
+		defer req.InputReader.Close()
+		var err error
+		if body, err = ioutil.ReadAll(req.InputReader); err != nil {
+			return RunResult{ExitCode: -1}, err
+		}
+	}
+
+	envelope, err := json.Marshal(framedRequest{
+		Headers:    req.Headers,
+		BodyBase64: base64.StdEncoding.EncodeToString(body),
+	})
+	if err != nil {
+		return RunResult{ExitCode: -1}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	buffer := &limitedBuffer{max: f.MaxResponseBytes, onOverflow: cancel}
+
+	forkReq := req
+	forkReq.Context = ctx
+	forkReq.InputReader = ioutil.NopCloser(bytes.NewReader(envelope))
+	forkReq.OutputWriter = buffer
+	forkReq.ContentLength = nil
+
+	fork := ForkFunctionRunner{ExecTimeout: f.ExecTimeout}
+	result, err := fork.Run(forkReq)
+	if buffer.overflowed {
+		return result, fmt.Errorf("%w: exceeded %d bytes", ErrResponseTooLarge, f.MaxResponseBytes)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	var response framedResponse
+	if err := json.Unmarshal(buffer.Bytes(), &response); err != nil {
+		return result, fmt.Errorf("%w: %s", ErrMalformedResponse, err)
+	}
+	status := http.StatusOK
+	if response.Status != nil {
+		status = *response.Status
+	}
+	if status < 100 || status > 599 {
+		return result, fmt.Errorf("%w: status %d is not between 100 and 599", ErrMalformedResponse, status)
+	}
+	responseBody, err := base64.StdEncoding.DecodeString(response.BodyBase64)
+	if err != nil {
+		return result, fmt.Errorf("%w: body_base64: %s", ErrMalformedResponse, err)
+	}
+
+	result.Headers = response.Headers
+	result.HTTPStatus = status
+	result.BytesWritten = 0
+
+	if w, ok := req.OutputWriter.(http.ResponseWriter); ok {
+		for k, v := range response.Headers {
+			w.Header()[http.CanonicalHeaderKey(k)] = v
+		}
+		w.WriteHeader(status)
+	}
+
+	if req.OutputWriter != nil {
+		n, writeErr := io.Copy(req.OutputWriter, bytes.NewReader(responseBody))
+		result.BytesWritten = n
+		err = writeErr
+	}
+
+	return result, err
+}
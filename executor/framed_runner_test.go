@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestFramedEchoProcess is not a real test, it is started by the tests below
+// as a function which echoes the request envelope with status 201 and an
+// added X-Echoed header.
+func TestFramedEchoProcess(t *testing.T) {
+	if os.Getenv("WANT_FRAMED_ECHO") != "1" {
+		return
+	}
+
+	var request framedRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&request); err != nil {
+		os.Exit(1)
+	}
+
+	headers := request.Headers
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set("X-Echoed", "true")
+
+	status := http.StatusCreated
+	json.NewEncoder(os.Stdout).Encode(framedResponse{
+		Status:     &status,
+		Headers:    headers,
+		BodyBase64: request.BodyBase64,
+	})
+	os.Exit(0)
+}
+
+func TestFramedForkFunctionRunner_Run_EchoesEnvelope(t *testing.T) {
+	f := FramedForkFunctionRunner{}
+
+	w := httptest.NewRecorder()
+	req := FunctionRequest{
+		Process:      os.Args[0],
+		ProcessArgs:  []string{"-test.run=^TestFramedEchoProcess$"},
+		Environment:  append(os.Environ(), "WANT_FRAMED_ECHO=1"),
+		Headers:      map[string][]string{"Content-Type": {"text/plain"}},
+		InputReader:  ioutil.NopCloser(strings.NewReader("hello\x00world")),
+		OutputWriter: w,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if result.HTTPStatus != http.StatusCreated || w.Code != http.StatusCreated {
+		t.Errorf("want status 201, got: %d and %d", result.HTTPStatus, w.Code)
+	}
+	if got := result.Headers.Get("X-Echoed"); got != "true" {
+		t.Errorf("want X-Echoed header in result, got: %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain" {
+		t.Errorf("want Content-Type echoed, got: %q", got)
+	}
+	if w.Body.String() != "hello\x00world" {
+		t.Errorf("want body decoded, got: %q", w.Body.String())
+	}
+	if result.BytesWritten != int64(len("hello\x00world")) {
+		t.Errorf("want BytesWritten of the decoded body, got: %d", result.BytesWritten)
+	}
+}
+
+func TestFramedForkFunctionRunner_Run_MalformedResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+	}{
+		{name: "not JSON", output: "hello"},
+		{name: "truncated JSON", output: `{"status": 200`},
+		{name: "invalid base64 body", output: `{"status": 200, "body_base64": "!!"}`},
+		{name: "zero status", output: `{"status": 0, "body_base64": ""}`},
+		{name: "status below 100", output: `{"status": 42, "body_base64": ""}`},
+		{name: "status above 599", output: `{"status": 1000, "body_base64": ""}`},
+	}
+
+	for _, c := range cases {
+		f := FramedForkFunctionRunner{}
+		w := httptest.NewRecorder()
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", "cat >/dev/null; printf '%s' '" + c.output + "'"},
+			OutputWriter: w,
+		}
+
+		_, err := f.Run(req)
+		if !errors.Is(err, ErrMalformedResponse) {
+			t.Errorf("(%s) want ErrMalformedResponse, got: %v", c.name, err)
+		}
+		if w.Body.Len() > 0 {
+			t.Errorf("(%s) want nothing written, got: %q", c.name, w.Body.String())
+		}
+	}
+}
+
+func TestFramedForkFunctionRunner_Run_RequestEnvelope(t *testing.T) {
+	f := FramedForkFunctionRunner{}
+	req := FunctionRequest{
+		Process: "sh",
+		// Wraps the request envelope as the body of the response.
+		ProcessArgs:  []string{"-c", `printf '{"body_base64": "%s"}' "$(base64 | tr -d '\n')"`},
+		Headers:      map[string][]string{"X-Test": {"1"}},
+		InputReader:  ioutil.NopCloser(strings.NewReader("body")),
+		OutputWriter: httptest.NewRecorder(),
+	}
+
+	w := req.OutputWriter.(*httptest.ResponseRecorder)
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	var got framedRequest
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("want JSON envelope on stdin, got: %q", w.Body.String())
+	}
+	if got.Headers.Get("X-Test") != "1" || got.BodyBase64 != base64.StdEncoding.EncodeToString([]byte("body")) {
+		t.Errorf("want headers and encoded body in envelope, got: %+v", got)
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("want default status without one in the envelope, got: %d", w.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultDeepCheckTimeout bounds a deep health check of the function
+const defaultDeepCheckTimeout = time.Second * 5
+
+// ReadinessReporter is implemented by runners which can tell whether they
+// are accepting invocations.
+type ReadinessReporter interface {
+	Ready() bool
+}
+
+// HealthChecker is implemented by runners with a long-lived process which
+// can be asked to check that it is healthy.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// HealthHandler answers HTTP probes with 200 while Runner is ready and the
+// watchdog is not draining, and 503 otherwise. A path ending in /deep, such
+// as /_/health/deep, also runs the Runner's CheckHealth when it has one.
+type HealthHandler struct {
+	// Runner is not ready when it implements ReadinessReporter and reports
+	// so, nil is always ready.
+	Runner FunctionRunner
+
+	// DeepCheckTimeout bounds CheckHealth, defaults to 5s.
+	DeepCheckTimeout time.Duration
+
+	draining int32
+}
+
+// Drain reports the watchdog as not ready from now on, so that it is taken
+// out of service before it shuts down.
+func (h *HealthHandler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Ready is false once draining or while the Runner is not ready.
+func (h *HealthHandler) Ready() bool {
+	if atomic.LoadInt32(&h.draining) == 1 {
+		return false
+	}
+	if reporter, ok := h.Runner.(ReadinessReporter); ok {
+		return reporter.Ready()
+	}
+	return true
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	if checker, ok := h.Runner.(HealthChecker); ok && strings.HasSuffix(r.URL.Path, "/deep") {
+		timeout := h.DeepCheckTimeout
+		if timeout <= 0 {
+			timeout = defaultDeepCheckTimeout
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		if err := checker.CheckHealth(ctx); err != nil {
+			http.Error(w, "unhealthy: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
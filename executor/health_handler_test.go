@@ -0,0 +1,96 @@
+package executor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func probeHealth(handler http.Handler, path string) int {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code
+}
+
+func TestHealthHandler_Readiness(t *testing.T) {
+	runner := &ForkFunctionRunner{}
+	health := &HealthHandler{Runner: runner}
+
+	if code := probeHealth(health, "/_/health"); code != http.StatusOK {
+		t.Errorf("want 200 while ready, got: %d", code)
+	}
+	if code := probeHealth(health, "/_/health/deep"); code != http.StatusOK {
+		t.Errorf("want 200 from a deep check of a runner without one, got: %d", code)
+	}
+
+	health.Drain()
+	if code := probeHealth(health, "/_/health"); code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 while draining, got: %d", code)
+	}
+
+	runner.Shutdown(context.Background())
+	if code := probeHealth(&HealthHandler{Runner: runner}, "/_/health"); code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 once the runner is shut down, got: %d", code)
+	}
+
+	if code := probeHealth(&HealthHandler{}, "/_/health"); code != http.StatusOK {
+		t.Errorf("want 200 without a runner, got: %d", code)
+	}
+}
+
+func TestHealthHandler_DeepCheck(t *testing.T) {
+	var healthy int32 = 1
+	var checks int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_/health" {
+			atomic.AddInt32(&checks, 1)
+			if atomic.LoadInt32(&healthy) == 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		echoHandler(w, r)
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	f := &HTTPFunctionRunner{
+		ExecTimeout:         time.Second * 5,
+		Process:             "cat",
+		UpstreamURL:         upstreamURL,
+		ReadinessInterval:   time.Millisecond * 10,
+		HealthCheckPath:     "/_/health",
+		HealthCheckInterval: time.Hour,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	if !eventually(f.Healthy) {
+		t.Fatalf("want runner to become healthy")
+	}
+	health := &HealthHandler{Runner: f}
+
+	if code := probeHealth(health, "/_/health/deep"); code != http.StatusOK {
+		t.Errorf("want 200 from a passing deep check, got: %d", code)
+	}
+	if atomic.LoadInt32(&checks) != 1 {
+		t.Errorf("want the deep check to ping the function once, got: %d", checks)
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	if code := probeHealth(health, "/_/health/deep"); code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 from a failing deep check, got: %d", code)
+	}
+	if code := probeHealth(health, "/_/health"); code != http.StatusOK {
+		t.Errorf("want 200 from the readiness check, which does not ping the function, got: %d", code)
+	}
+	if atomic.LoadInt32(&checks) != 2 {
+		t.Errorf("want only deep checks to ping the function, got: %d", checks)
+	}
+}
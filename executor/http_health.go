@@ -0,0 +1,198 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultHealthCheckInterval is the delay between health checks of the upstream
+const defaultHealthCheckInterval = time.Second * 10
+
+// defaultHealthCheckFailures is how many failed health checks in a row restart the process
+const defaultHealthCheckFailures = 3
+
+// Healthy is true once the upstream is ready and while it passes its health
+// checks, for reporting on the watchdog's own readiness probe.
+func (f *HTTPFunctionRunner) Healthy() bool {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	return f.healthy
+}
+
+// Ready is Healthy, or the process was stopped by IdleTimeout and will be
+// started by the next request, for the HealthHandler.
+func (f *HTTPFunctionRunner) Ready() bool {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	return f.healthy || f.idle
+}
+
+// CheckHealth requests HealthCheckPath from the upstream, failing unless it
+// answers with a 2xx status. Without a HealthCheckPath the upstream only
+// has to be Healthy.
+func (f *HTTPFunctionRunner) CheckHealth(ctx context.Context) error {
+	if !f.Healthy() {
+		if f.Ready() {
+			// Stopped while idle
+			return nil
+		}
+		return errors.New("upstream is not ready")
+	}
+	if len(f.HealthCheckPath) == 0 {
+		return nil
+	}
+
+	healthURL := f.requestURL()
+	healthURL.Path = f.HealthCheckPath
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := f.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("upstream health check returned %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Close stops health checks, signal forwarding and the process.
+func (f *HTTPFunctionRunner) Close() error {
+	f.stopOnce.Do(func() {
+		f.stateMutex.Lock()
+		cmd := f.Command
+		f.Command = nil
+		f.healthy = false
+		f.ready = nil
+		if f.stop != nil {
+			close(f.stop)
+		}
+		f.stateMutex.Unlock()
+
+		if cmd != nil && cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+		f.forwarder.stop()
+	})
+	return removeLockFile(f.LockFilePath)
+}
+
+// checkHealth requests HealthCheckPath until stop is closed, restarting the
+// process after too many failures.
+func (f *HTTPFunctionRunner) checkHealth(stop chan struct{}) {
+	interval := f.HealthCheckInterval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	maxFailures := f.HealthCheckFailures
+	if maxFailures <= 0 {
+		maxFailures = defaultHealthCheckFailures
+	}
+
+	client := &http.Client{
+		Transport: f.Client.Transport,
+		Timeout:   interval,
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	restartFailed := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if restartFailed {
+			restartFailed = f.restart() != nil
+			continue
+		}
+
+		if !f.Healthy() {
+			// Still starting after a restart
+			continue
+		}
+
+		if f.probe(client) {
+			failures = 0
+			continue
+		}
+
+		failures++
+		log.Printf("Upstream health check failed %d of %d times", failures, maxFailures)
+		if failures >= maxFailures {
+			failures = 0
+			restartFailed = f.restart() != nil
+		}
+	}
+}
+
+func (f *HTTPFunctionRunner) probe(client *http.Client) bool {
+	healthURL := f.requestURL()
+	healthURL.Path = f.HealthCheckPath
+
+	res, err := client.Get(healthURL.String())
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
+
+// restart replaces the process once the requests sent to it have completed,
+// the runner is not ready until the new one is. When the new process cannot
+// be started, requests waiting for it fail with the error rather than wait
+// and it is returned so that the restart is tried again.
+func (f *HTTPFunctionRunner) restart() error {
+	log.Printf("Restarting unhealthy function process")
+
+	ready := make(chan struct{})
+
+	f.stateMutex.Lock()
+	select {
+	case <-f.stop:
+		f.stateMutex.Unlock()
+		return nil
+	default:
+	}
+	old, requests := f.Command, f.requests
+	f.Command = nil
+	f.healthy = false
+	f.ready = ready
+	f.startErr = nil
+	f.stateMutex.Unlock()
+
+	if err := removeLockFile(f.LockFilePath); err != nil {
+		log.Printf("Unable to remove lock file: %s", err)
+	}
+
+	if requests != nil {
+		requests.Wait()
+	}
+
+	if old != nil && old.Process != nil {
+		old.Process.Kill()
+	}
+
+	if err := f.startProcess(ready); err != nil {
+		log.Printf("Unable to restart function process: %s", err)
+		f.stateMutex.Lock()
+		f.startErr = err
+		f.stateMutex.Unlock()
+		close(ready)
+		return err
+	}
+	return nil
+}
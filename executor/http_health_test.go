@@ -0,0 +1,394 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// eventually polls condition until it is true or the timeout passes.
+func eventually(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+func TestHTTPFunctionRunner_HealthCheckRestartsProcess(t *testing.T) {
+	var healthy int32 = 1
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_/health" && atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		echoHandler(w, r)
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	lockFile := filepath.Join(t.TempDir(), ".lock")
+
+	f := &HTTPFunctionRunner{
+		ExecTimeout:         time.Second * 5,
+		Process:             "cat",
+		UpstreamURL:         upstreamURL,
+		ReadinessInterval:   time.Millisecond * 10,
+		HealthCheckPath:     "/_/health",
+		HealthCheckInterval: time.Millisecond * 20,
+		HealthCheckFailures: 2,
+		LockFilePath:        lockFile,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	lockFileExists := func() bool {
+		_, err := os.Stat(lockFile)
+		return err == nil
+	}
+	pid := func() int {
+		if cmd := f.currentCommand(); cmd != nil && cmd.Process != nil {
+			return cmd.Process.Pid
+		}
+		return 0
+	}
+
+	if !eventually(func() bool { return f.Healthy() && lockFileExists() }) {
+		t.Fatalf("want runner to become healthy with a lock file")
+	}
+	firstPid := pid()
+
+	atomic.StoreInt32(&healthy, 0)
+
+	if !eventually(func() bool { return !f.Healthy() && !lockFileExists() }) {
+		t.Fatalf("want runner to become unhealthy and remove the lock file")
+	}
+	if !eventually(func() bool { return pid() != 0 && pid() != firstPid }) {
+		t.Errorf("want process %d to be restarted", firstPid)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+
+	if !eventually(func() bool { return f.Healthy() && lockFileExists() }) {
+		t.Errorf("want runner to become healthy again after the restart")
+	}
+}
+
+func TestHTTPFunctionRunner_HealthCheckRestartDrainsRequests(t *testing.T) {
+	var healthy int32 = 1
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_/health":
+			if atomic.LoadInt32(&healthy) == 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/slow":
+			<-unblock
+			w.Write([]byte("done"))
+		}
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	f := &HTTPFunctionRunner{
+		ExecTimeout:         time.Second * 5,
+		Process:             "cat",
+		UpstreamURL:         upstreamURL,
+		ReadinessInterval:   time.Millisecond * 10,
+		HealthCheckPath:     "/_/health",
+		HealthCheckInterval: time.Millisecond * 20,
+		HealthCheckFailures: 1,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	if !eventually(f.Healthy) {
+		t.Fatalf("want runner to become healthy")
+	}
+	first := f.currentCommand()
+
+	inflight := make(chan error, 1)
+	out := &bytes.Buffer{}
+	go func() {
+		_, err := f.Run(FunctionRequest{Method: http.MethodGet, Path: "/slow", OutputWriter: out})
+		inflight <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	atomic.StoreInt32(&healthy, 0)
+
+	if !eventually(func() bool { return f.currentCommand() == nil }) {
+		t.Fatalf("want process to be restarted after failing its health check")
+	}
+	time.Sleep(time.Millisecond * 50)
+	if first.ProcessState != nil || first.Process.Signal(syscall.Signal(0)) != nil {
+		t.Errorf("want process kept running while a request is in flight")
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	close(unblock)
+	if err := <-inflight; err != nil || out.String() != "done" {
+		t.Errorf("want in-flight request to complete, got: %q %v", out.String(), err)
+	}
+
+	if !eventually(func() bool {
+		cmd := f.currentCommand()
+		return cmd != nil && cmd.Process.Pid != first.Process.Pid && f.Healthy()
+	}) {
+		t.Errorf("want process %d replaced once drained", first.Process.Pid)
+	}
+}
+
+func TestHTTPFunctionRunner_HealthCheckRetriesFailedRestart(t *testing.T) {
+	var healthy int32 = 1
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_/health" && atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	process := filepath.Join(t.TempDir(), "function")
+	script := []byte("#!/bin/sh\nexec cat\n")
+	if err := os.WriteFile(process, script, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	f := &HTTPFunctionRunner{
+		ExecTimeout:         time.Second * 5,
+		Process:             process,
+		UpstreamURL:         upstreamURL,
+		ReadinessInterval:   time.Millisecond * 10,
+		HealthCheckPath:     "/_/health",
+		HealthCheckInterval: time.Millisecond * 20,
+		HealthCheckFailures: 1,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	if !eventually(f.Healthy) {
+		t.Fatalf("want runner to become healthy")
+	}
+
+	// The replacement cannot be started while the process is missing.
+	os.Remove(process)
+	atomic.StoreInt32(&healthy, 0)
+
+	if !eventually(func() bool { return !f.Healthy() && f.currentCommand() == nil }) {
+		t.Fatalf("want process to be stopped after failing its health check")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.RunContext(ctx, FunctionRequest{Method: http.MethodGet, Path: "/"})
+		done <- err
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Millisecond * 500):
+		t.Errorf("want request not to wait for a process which failed to start")
+	}
+	if f.Healthy() {
+		t.Errorf("want runner to stay unhealthy while the restart fails")
+	}
+
+	if err := os.WriteFile(process, script, 0755); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&healthy, 1)
+
+	if !eventually(func() bool { return f.currentCommand() != nil && f.Healthy() }) {
+		t.Errorf("want restart to be tried again and the runner to become healthy")
+	}
+}
+
+func TestHTTPFunctionRunner_MaxProcessLifetimeDrainsRequests(t *testing.T) {
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-unblock
+		}
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	f := &HTTPFunctionRunner{
+		ExecTimeout:        time.Second * 5,
+		Process:            "cat",
+		UpstreamURL:        upstreamURL,
+		ReadinessInterval:  time.Millisecond * 10,
+		MaxProcessLifetime: time.Millisecond * 100,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	if !eventually(f.Healthy) {
+		t.Fatalf("want runner to become healthy")
+	}
+	first := f.currentCommand()
+
+	inflight := make(chan error, 1)
+	out := &bytes.Buffer{}
+	go func() {
+		_, err := f.Run(FunctionRequest{Method: http.MethodGet, Path: "/slow", OutputWriter: out})
+		inflight <- err
+	}()
+
+	// Past its lifetime the process is kept running until the request completes.
+	if !eventually(func() bool { return f.currentCommand() == nil }) {
+		t.Fatalf("want process to be recycled after its lifetime")
+	}
+	time.Sleep(time.Millisecond * 50)
+	if first.ProcessState != nil || first.Process.Signal(syscall.Signal(0)) != nil {
+		t.Errorf("want process kept running while a request is in flight")
+	}
+
+	close(unblock)
+	if err := <-inflight; err != nil || out.String() != "done" {
+		t.Errorf("want in-flight request to complete, got: %q %v", out.String(), err)
+	}
+
+	if !eventually(func() bool {
+		cmd := f.currentCommand()
+		return cmd != nil && cmd.Process.Pid != first.Process.Pid && f.Healthy()
+	}) {
+		t.Errorf("want process %d replaced once drained", first.Process.Pid)
+	}
+}
+
+func TestHTTPFunctionRunner_IdleTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	f := &HTTPFunctionRunner{
+		ExecTimeout:       time.Second * 5,
+		Process:           "cat",
+		UpstreamURL:       upstreamURL,
+		ReadinessInterval: time.Millisecond * 10,
+		IdleTimeout:       time.Millisecond * 150,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	if !eventually(f.Healthy) {
+		t.Fatalf("want runner to become healthy")
+	}
+	first := f.currentCommand()
+
+	call := func() {
+		out := &bytes.Buffer{}
+		if _, err := f.Run(FunctionRequest{Method: http.MethodGet, Path: "/", OutputWriter: out}); err != nil || out.String() != "done" {
+			t.Fatalf("want request to complete, got: %q %v", out.String(), err)
+		}
+	}
+
+	// Each request resets the idle time, so the process is kept.
+	for i := 0; i < 4; i++ {
+		call()
+		time.Sleep(time.Millisecond * 60)
+	}
+	if f.currentCommand() != first {
+		t.Fatalf("want process kept while busy")
+	}
+
+	if !eventually(func() bool { return f.currentCommand() == nil }) {
+		t.Fatalf("want idle process to be stopped")
+	}
+	if !eventually(func() bool { return first.ProcessState != nil || first.Process.Signal(syscall.Signal(0)) != nil }) {
+		t.Errorf("want process %d to have exited", first.Process.Pid)
+	}
+	if !f.Ready() {
+		t.Errorf("want runner Ready while its process is stopped for being idle")
+	}
+
+	call()
+	if cmd := f.currentCommand(); cmd == nil || cmd.Process.Pid == first.Process.Pid {
+		t.Errorf("want a new process started by the next request")
+	}
+}
+
+func TestHTTPFunctionRunner_IdleTimeout_WakeFailsFast(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	process := filepath.Join(t.TempDir(), "function")
+	if err := ioutil.WriteFile(process, []byte("#!/bin/sh\nexec cat\n"), 0700); err != nil {
+		t.Fatalf("want function written, got: %s", err)
+	}
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	f := &HTTPFunctionRunner{
+		ExecTimeout:       time.Second * 5,
+		Process:           process,
+		UpstreamURL:       upstreamURL,
+		ReadinessInterval: time.Millisecond * 10,
+		IdleTimeout:       time.Millisecond * 100,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	if !eventually(f.Healthy) {
+		t.Fatalf("want runner to become healthy")
+	}
+	if !eventually(func() bool { return f.currentCommand() == nil }) {
+		t.Fatalf("want idle process to be stopped")
+	}
+	os.Remove(process)
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if _, err := f.Run(FunctionRequest{Method: http.MethodGet, Path: "/", OutputWriter: ioutil.Discard}); err == nil {
+			t.Fatalf("want an error when the idle process cannot be started")
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("want the start error, got: %s", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("want the request to fail fast, took: %s", elapsed)
+		}
+	}
+
+	if err := ioutil.WriteFile(process, []byte("#!/bin/sh\nexec cat\n"), 0700); err != nil {
+		t.Fatalf("want function written, got: %s", err)
+	}
+	out := &bytes.Buffer{}
+	if _, err := f.Run(FunctionRequest{Method: http.MethodGet, Path: "/", OutputWriter: out}); err != nil || out.String() != "done" {
+		t.Errorf("want the next request to start the process again, got: %q %v", out.String(), err)
+	}
+}
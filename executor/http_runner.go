@@ -1,7 +1,10 @@
 package executor
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -27,93 +30,506 @@ type HTTPFunctionRunner struct {
 	Stderr       io.Writer
 	Mutex        sync.Mutex
 	Client       *http.Client
-	UpstreamURL  *url.URL
+	UpstreamURL  *url.URL // UpstreamURL where requests are forwarded to i.e. http://127.0.0.1:8082 or unix:///tmp/function.sock
+
+	// ReadinessInterval is the delay between attempts to connect to the upstream while it starts, defaults to 100ms.
+	ReadinessInterval time.Duration
+
+	// MaxRetries is how many more times a request is sent when the upstream
+	// cannot be reached, waiting RetryBackoff between attempts, defaults to
+	// 100ms. A request with a body is only retried with BufferRequestBody.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// BufferRequestBody reads the request body into memory so that it can be
+	// sent again on a retry, bounded by MaxRequestBytes when set.
+	BufferRequestBody bool
+	MaxRequestBytes   int64
+
+	// HealthCheckPath is requested from the upstream every HealthCheckInterval,
+	// defaults to 10s. After HealthCheckFailures failed checks in a row,
+	// defaults to 3, the process is restarted and the runner is not Healthy
+	// until it is ready again.
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckFailures int
+
+	// LockFilePath is written once the upstream is ready and removed while the
+	// runner is not Healthy.
+	LockFilePath string
+
+	// UpstreamHTTP2 talks to the upstream using HTTP/2 without TLS (h2c), so
+	// that concurrent requests share one connection. The upstream must accept
+	// h2c with prior knowledge, only use it for http:// and unix:// upstreams.
+	UpstreamHTTP2 bool
+
+	// MaxProcessLifetime restarts the process once it has been running for
+	// this long, zero means never. Requests already sent to it are allowed
+	// to complete first, while new ones wait for the replacement to be ready.
+	MaxProcessLifetime time.Duration
+
+	// IdleTimeout stops the process once no request has been sent to it for
+	// this long, zero means never. The next request starts it again and
+	// waits for it to be ready, the runner stays Ready meanwhile.
+	IdleTimeout time.Duration
+
+	// ForwardSignals are relayed to the process when the watchdog receives
+	// them, except for SIGTERM and SIGINT.
+	ForwardSignals []os.Signal
+
+	forwarder  signalForwarder
+	stateMutex sync.Mutex
+	ready      chan struct{}
+	startErr   error
+	healthy    bool
+	startedAt  time.Time
+	requests   *sync.WaitGroup
+	active     int
+	lastUsed   time.Time
+	idle       bool
+	stop       chan struct{}
+	stopOnce   sync.Once
 }
 
+// defaultRetryBackoff is the delay between attempts to send a request to the upstream
+const defaultRetryBackoff = time.Millisecond * 100
+
+// defaultReadinessInterval is used between connection attempts when waiting for the upstream
+const defaultReadinessInterval = time.Millisecond * 100
+
 // Start forks the process used for processing incoming requests
 func (f *HTTPFunctionRunner) Start() error {
-	cmd := exec.Command(f.Process, f.ProcessArgs...)
+	if f.UpstreamURL == nil {
+		return fmt.Errorf("no UpstreamURL given for the HTTP runner")
+	}
 
-	var stdinErr error
-	var stdoutErr error
+	f.Client = makeProxyClient(f.ExecTimeout, f.UpstreamURL, f.UpstreamHTTP2)
 
-	f.Command = cmd
-	f.StdinPipe, stdinErr = cmd.StdinPipe()
+	if network, address := upstreamNetwork(f.UpstreamURL); network == "unix" {
+		removeStaleSocket(address)
+	}
+
+	ready := make(chan struct{})
+	f.stateMutex.Lock()
+	f.ready = ready
+	f.stop = make(chan struct{})
+	f.stateMutex.Unlock()
+
+	if len(f.ForwardSignals) > 0 {
+		f.forwarder.start(f.ForwardSignals)
+	}
+
+	if err := f.startProcess(ready); err != nil {
+		f.forwarder.stop()
+		return err
+	}
+
+	if len(f.HealthCheckPath) > 0 {
+		go f.checkHealth(f.stop)
+	}
+	if f.MaxProcessLifetime > 0 {
+		go f.recycleAfterLifetime(f.stop)
+	}
+	if f.IdleTimeout > 0 {
+		go f.stopWhenIdle(f.stop)
+	}
+	return nil
+}
+
+// startProcess forks the process and marks ready once it accepts connections.
+func (f *HTTPFunctionRunner) startProcess(ready chan struct{}) error {
+	cmd := exec.Command(f.Process, f.ProcessArgs...)
+
+	stdinPipe, stdinErr := cmd.StdinPipe()
 	if stdinErr != nil {
 		return stdinErr
 	}
 
-	f.StdoutPipe, stdoutErr = cmd.StdoutPipe()
+	stdoutPipe, stdoutErr := cmd.StdoutPipe()
 	if stdoutErr != nil {
 		return stdoutErr
 	}
 
 	errPipe, _ := cmd.StderrPipe()
 
+	var output sync.WaitGroup
+	output.Add(2)
+
 	// Prints stderr to console and is picked up by container logging driver.
 	go func() {
+		defer output.Done()
 		log.Println("Started logging stderr from function.")
+		errBuff := make([]byte, 256)
 		for {
-			errBuff := make([]byte, 256)
-
-			_, err := errPipe.Read(errBuff)
+			n, err := errPipe.Read(errBuff)
+			if n > 0 {
+				log.Printf("stderr: %s", errBuff[:n])
+			}
 			if err != nil {
-				log.Fatalf("Error reading stderr: %s", err)
-
-			} else {
-				log.Printf("stderr: %s", errBuff)
+				log.Printf("Error reading stderr: %s", err)
+				return
 			}
 		}
 	}()
 
 	go func() {
+		defer output.Done()
 		log.Println("Started logging stdout from function.")
+		errBuff := make([]byte, 256)
 		for {
-			errBuff := make([]byte, 256)
-
-			_, err := f.StdoutPipe.Read(errBuff)
+			n, err := stdoutPipe.Read(errBuff)
+			if n > 0 {
+				log.Printf("stdout: %s", errBuff[:n])
+			}
 			if err != nil {
-				log.Fatalf("Error reading stdout: %s", err)
-
-			} else {
-				log.Printf("stdout: %s", errBuff)
+				log.Printf("Error reading stdout: %s", err)
+				return
 			}
 		}
 	}()
 
-	f.Client = makeProxyClient(f.ExecTimeout)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	f.stateMutex.Lock()
+	select {
+	case <-f.stop:
+		// Closed while restarting
+		f.stateMutex.Unlock()
+		cmd.Process.Kill()
+		return nil
+	default:
+	}
+	f.Command = cmd
+	f.StdinPipe = stdinPipe
+	f.StdoutPipe = stdoutPipe
+	f.startedAt = time.Now()
+	f.lastUsed = f.startedAt
+	f.requests = &sync.WaitGroup{}
+	f.stateMutex.Unlock()
+
+	f.forwarder.add(cmd.Process)
+
+	// The watchdog cannot serve requests without the process, unless it was
+	// stopped by a restart or Close.
+	go func() {
+		output.Wait()
+		err := cmd.Wait()
+		f.forwarder.remove(cmd.Process)
+		if f.currentCommand() == cmd {
+			log.Fatalf("Function process exited: %v", err)
+		}
+	}()
+
+	go f.waitForUpstream(ready)
+
+	return nil
+}
+
+func (f *HTTPFunctionRunner) currentCommand() *exec.Cmd {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	return f.Command
+}
+
+// waitForUpstream blocks until the upstream accepts connections and then
+// marks the runner ready, giving up if the process has since been replaced.
+func (f *HTTPFunctionRunner) waitForUpstream(ready chan struct{}) {
+	interval := f.ReadinessInterval
+	if interval <= 0 {
+		interval = defaultReadinessInterval
+	}
+
+	network, address := upstreamNetwork(f.UpstreamURL)
+	for {
+		f.stateMutex.Lock()
+		current := f.ready == ready
+		f.stateMutex.Unlock()
+		if !current {
+			return
+		}
+
+		conn, err := net.DialTimeout(network, address, interval)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(interval)
+	}
+
+	log.Printf("Upstream ready at: %s", address)
+
+	f.stateMutex.Lock()
+	f.healthy = true
+	f.stateMutex.Unlock()
+
+	if err := createLockFile(f.LockFilePath); err != nil {
+		log.Printf("Unable to write lock file: %s", err)
+	}
+	close(ready)
+}
+
+// awaitReady blocks until the upstream is ready or ctx is done, returning
+// the error from starting the process when it could not be started.
+func (f *HTTPFunctionRunner) awaitReady(ctx context.Context) error {
+	f.stateMutex.Lock()
+	ready := f.ready
+	f.stateMutex.Unlock()
+
+	if ready == nil {
+		return nil
+	}
+
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// ready is also closed when the process could not be started
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	if f.ready == ready {
+		return f.startErr
+	}
+	return nil
+}
+
+// upstreamNetwork returns the network and address to dial for the upstream,
+// a unix:///path/to/socket URL is dialled as a Unix domain socket.
+func upstreamNetwork(upstreamURL *url.URL) (string, string) {
+	if upstreamURL.Scheme == "unix" {
+		if len(upstreamURL.Opaque) > 0 {
+			return "unix", upstreamURL.Opaque
+		}
+		return "unix", upstreamURL.Path
+	}
+	return "tcp", upstreamAddress(upstreamURL)
+}
+
+// requestURL is the URL requests are sent to, the host is ignored when the
+// upstream is a Unix domain socket.
+func (f *HTTPFunctionRunner) requestURL() url.URL {
+	if f.UpstreamURL.Scheme == "unix" {
+		return url.URL{Scheme: "http", Host: "unix", Path: "/"}
+	}
+	return *f.UpstreamURL
+}
+
+// removeStaleSocket removes a socket left behind by a process which crashed,
+// one which still accepts connections is left alone.
+func removeStaleSocket(path string) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		log.Printf("Upstream socket %s is already in use", path)
+		return
+	}
+
+	log.Printf("Removing stale upstream socket: %s", path)
+	if err := os.Remove(path); err != nil {
+		log.Printf("Unable to remove stale upstream socket: %s", err)
+	}
+}
+
+func upstreamAddress(upstreamURL *url.URL) string {
+	if port := upstreamURL.Port(); len(port) > 0 {
+		return upstreamURL.Host
+	}
+
+	if upstreamURL.Scheme == "https" {
+		return net.JoinHostPort(upstreamURL.Hostname(), "443")
+	}
+	return net.JoinHostPort(upstreamURL.Hostname(), "80")
+}
+
+// Run calls RunContext with req.Context, or context.Background() when it is not set
+func (f *HTTPFunctionRunner) Run(req FunctionRequest) (RunResult, error) {
+	return f.RunContext(requestContext(req), req)
+}
+
+// RunContext forwards a FunctionRequest to the upstream process and streams the response body to req.OutputWriter,
+// when it is a http.ResponseWriter the upstream status and headers are written first
+func (f *HTTPFunctionRunner) RunContext(ctx context.Context, req FunctionRequest) (RunResult, error) {
+	req.Context = ctx
+
+	start := time.Now()
+	result := RunResult{}
+
+	if f.ExecTimeout > time.Millisecond*0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.ExecTimeout)
+		defer cancel()
+	}
+
+	release, err := f.acquireUpstream(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
+	var body io.Reader
+	if req.InputReader != nil {
+		defer req.InputReader.Close()
+		body = req.InputReader
+	}
+
+	method := req.Method
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+
+	upstreamURL := f.requestURL()
+	if len(req.Path) > 0 {
+		upstreamURL.Path = req.Path
+	}
+
+	res, err := f.sendUpstream(ctx, body, func(body io.Reader) (*http.Request, error) {
+		request, err := http.NewRequest(method, upstreamURL.String(), body)
+		if err != nil {
+			return nil, err
+		}
+
+		for h, v := range req.Headers {
+			request.Header[h] = append([]string{}, v...)
+		}
+		if req.ContentLength != nil {
+			request.ContentLength = *req.ContentLength
+		}
+		return request, nil
+	})
+	if err != nil {
+		result.Duration = time.Since(start)
+		return result, err
+	}
+	defer res.Body.Close()
+
+	outputWriter := req.OutputWriter
+	if outputWriter == nil {
+		outputWriter = ioutil.Discard
+	}
+
+	result.HTTPStatus = res.StatusCode
+	result.Headers = res.Header
 
-	urlValue, upstreamURLErr := url.Parse(os.Getenv("upstream_url"))
-	if upstreamURLErr != nil {
-		log.Fatal(upstreamURLErr)
+	// The status and headers must be written before the first byte of the body.
+	if w, ok := outputWriter.(http.ResponseWriter); ok {
+		copyHeaders(w.Header(), &res.Header)
+		w.WriteHeader(res.StatusCode)
 	}
 
-	f.UpstreamURL = urlValue
+	written, err := io.Copy(outputWriter, res.Body)
+	result.BytesWritten = written
+	result.Duration = time.Since(start)
 
-	return cmd.Start()
+	log.Printf("%s %s - %s - ContentLength: %d", method, upstreamURL.Path, res.Status, written)
+
+	return result, err
 }
 
-// Run a function with a long-running process with a HTTP protocol for communication
-func (f *HTTPFunctionRunner) Run(req FunctionRequest, contentLength int64, r *http.Request, w http.ResponseWriter) error {
+// sendUpstream sends the request made by newRequest for body, making and
+// sending it again up to MaxRetries times while the upstream cannot be
+// reached. With BufferRequestBody the body is read first so that each
+// attempt can send it, otherwise a request with a body is not retried and
+// ErrBodyNotReplayable is returned.
+func (f *HTTPFunctionRunner) sendUpstream(ctx context.Context, body io.Reader, newRequest func(body io.Reader) (*http.Request, error)) (*http.Response, error) {
+	var buffered []byte
+	if body != nil && f.BufferRequestBody {
+		var err error
+		if buffered, err = readRequestBody(body, f.MaxRequestBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if buffered != nil {
+			body = bytes.NewReader(buffered)
+		}
+
+		request, err := newRequest(body)
+		if err != nil {
+			return nil, err
+		}
+		if buffered != nil {
+			request.ContentLength = int64(len(buffered))
+		}
 
-	upstreamURL := f.UpstreamURL.String()
+		res, err := f.Client.Do(request.WithContext(ctx))
+		if err == nil {
+			return res, nil
+		}
+
+		if ctx.Err() != nil || attempt >= f.MaxRetries {
+			return nil, err
+		}
+
+		// The body has been at least partly sent, so it cannot be sent again.
+		if body != nil && buffered == nil {
+			return nil, fmt.Errorf("%w: %s", ErrBodyNotReplayable, err)
+		}
+
+		backoff := f.RetryBackoff
+		if backoff <= 0 {
+			backoff = defaultRetryBackoff
+		}
+		log.Printf("Retrying upstream request in %s after: %s", backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// Proxy forwards an incoming HTTP request to the long-running process, including its method and headers,
+// it is retried as in RunContext and is cancelled along with r
+func (f *HTTPFunctionRunner) Proxy(r *http.Request, w http.ResponseWriter) error {
+
+	target := f.requestURL()
+	upstreamURL := target.String()
 
 	if len(r.URL.RawQuery) > 0 {
 		upstreamURL += "?" + r.URL.RawQuery
 	}
 
-	request, _ := http.NewRequest(r.Method, upstreamURL, r.Body)
-	for h := range r.Header {
-		request.Header.Set(h, r.Header.Get(h))
+	var body io.Reader
+	if r.Body != nil && r.Body != http.NoBody {
+		body = r.Body
 	}
 
-	copyHeaders(request.Header, &r.Header)
-
-	ctx, cancel := context.WithTimeout(context.Background(), f.ExecTimeout)
+	ctx, cancel := context.WithTimeout(r.Context(), f.ExecTimeout)
 
 	defer cancel()
 
-	res, err := f.Client.Do(request.WithContext(ctx))
+	release, err := f.acquireUpstream(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return err
+	}
+	defer release()
+
+	res, err := f.sendUpstream(ctx, body, func(body io.Reader) (*http.Request, error) {
+		request, err := http.NewRequest(r.Method, upstreamURL, body)
+		if err != nil {
+			return nil, err
+		}
+		for h := range r.Header {
+			request.Header.Set(h, r.Header.Get(h))
+		}
+
+		copyHeaders(request.Header, &r.Header)
+		return request, nil
+	})
+
+	if errors.Is(err, ErrRequestTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return nil
+	}
 
 	if err != nil {
 		log.Printf("Upstream HTTP request error: %s\n", err.Error())
@@ -161,6 +577,23 @@ func (f *HTTPFunctionRunner) Run(req FunctionRequest, contentLength int64, r *ht
 	return nil
 }
 
+// readRequestBody reads the whole body, returning ErrRequestTooLarge when it
+// is larger than max. Zero means no limit.
+func readRequestBody(body io.Reader, max int64) ([]byte, error) {
+	if max <= 0 {
+		return ioutil.ReadAll(body)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("%w: body exceeds %d bytes", ErrRequestTooLarge, max)
+	}
+	return data, nil
+}
+
 func copyHeaders(destination http.Header, source *http.Header) {
 	for k, v := range *source {
 		vClone := make([]string, len(v))
@@ -169,20 +602,39 @@ func copyHeaders(destination http.Header, source *http.Header) {
 	}
 }
 
-func makeProxyClient(dialTimeout time.Duration) *http.Client {
+func makeProxyClient(dialTimeout time.Duration, upstreamURL *url.URL, unencryptedHTTP2 bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 10 * time.Second,
+	}
+
+	proxy := http.ProxyFromEnvironment
+	dialContext := dialer.DialContext
+	if network, address := upstreamNetwork(upstreamURL); network == "unix" {
+		proxy = nil
+		dialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		DisableKeepAlives:     false,
+		IdleConnTimeout:       500 * time.Millisecond,
+		ExpectContinueTimeout: 1500 * time.Millisecond,
+	}
+
+	if unencryptedHTTP2 {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = protocols
+	}
+
 	proxyClient := http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   dialTimeout,
-				KeepAlive: 10 * time.Second,
-			}).DialContext,
-			MaxIdleConns:          100,
-			MaxIdleConnsPerHost:   100,
-			DisableKeepAlives:     false,
-			IdleConnTimeout:       500 * time.Millisecond,
-			ExpectContinueTimeout: 1500 * time.Millisecond,
-		},
+		Transport: transport,
 	}
 
 	return &proxyClient
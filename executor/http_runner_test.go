@@ -0,0 +1,480 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.Write(body)
+}
+
+func startHTTPRunner(t *testing.T, upstream string) *HTTPFunctionRunner {
+	upstreamURL, err := url.Parse(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &HTTPFunctionRunner{
+		ExecTimeout:       time.Second * 5,
+		Process:           "cat",
+		UpstreamURL:       upstreamURL,
+		ReadinessInterval: time.Millisecond * 10,
+	}
+
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	return f
+}
+
+// lockedBuffer is a bytes.Buffer which can be read while it is written to.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestHTTPFunctionRunner_LogsOnlyBytesRead(t *testing.T) {
+	logs := &lockedBuffer{}
+	log.SetOutput(logs)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	upstream := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	f := &HTTPFunctionRunner{
+		ExecTimeout:       time.Second * 5,
+		Process:           "sh",
+		ProcessArgs:       []string{"-c", "echo out; echo err >&2; exec cat"},
+		UpstreamURL:       upstreamURL,
+		ReadinessInterval: time.Millisecond * 10,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	for _, want := range []string{"stdout: out\n", "stderr: err\n"} {
+		if !eventually(func() bool { return strings.Contains(logs.String(), want) }) {
+			t.Errorf("want %q logged, got: %q", want, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "\x00") {
+		t.Errorf("want only the bytes read logged, got: %q", logs.String())
+	}
+}
+
+func TestHTTPFunctionRunner_Run_ProxiesBody(t *testing.T) {
+	var upstreamContentLength int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamContentLength = r.ContentLength
+		echoHandler(w, r)
+	}))
+	defer upstream.Close()
+
+	f := startHTTPRunner(t, upstream.URL)
+
+	body := "hello world"
+	contentLength := int64(len(body))
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		InputReader:   ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: &contentLength,
+		OutputWriter:  out,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if out.String() != body {
+		t.Errorf("want body: %q, got: %q", body, out.String())
+	}
+
+	if result.BytesWritten != contentLength {
+		t.Errorf("want BytesWritten: %d, got: %d", contentLength, result.BytesWritten)
+	}
+
+	if upstreamContentLength != contentLength {
+		t.Errorf("want upstream ContentLength: %d, got: %d", contentLength, upstreamContentLength)
+	}
+}
+
+func TestHTTPFunctionRunner_Run_WritesResponseHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("X-Upstream", "one")
+		w.Header().Add("X-Upstream", "two")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	f := startHTTPRunner(t, upstream.URL)
+
+	recorder := httptest.NewRecorder()
+	result, err := f.Run(FunctionRequest{OutputWriter: recorder})
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if recorder.Code != http.StatusCreated || result.HTTPStatus != http.StatusCreated {
+		t.Errorf("want status %d, got: %d and result %d", http.StatusCreated, recorder.Code, result.HTTPStatus)
+	}
+	if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("want Content-Type application/json, got: %q", got)
+	}
+	if got := recorder.Header()["X-Upstream"]; strings.Join(got, ",") != "one,two" {
+		t.Errorf("want every X-Upstream value, got: %q", got)
+	}
+	if recorder.Body.String() != `{"ok":true}` {
+		t.Errorf("want body after the headers, got: %q", recorder.Body.String())
+	}
+
+	out := &bytes.Buffer{}
+	result, err = f.Run(FunctionRequest{OutputWriter: out})
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if out.String() != `{"ok":true}` {
+		t.Errorf("want only the body written to a plain writer, got: %q", out.String())
+	}
+	if result.Headers.Get("X-Upstream") != "one" {
+		t.Errorf("want headers in the result, got: %v", result.Headers)
+	}
+}
+
+func TestHTTPFunctionRunner_Run_WaitsForUpstream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	f := startHTTPRunner(t, "http://"+address)
+
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+
+		upstreamListener, err := net.Listen("tcp", address)
+		if err != nil {
+			t.Errorf("unable to listen on %s: %s", address, err)
+			return
+		}
+		http.Serve(upstreamListener, http.HandlerFunc(echoHandler))
+	}()
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		InputReader:  ioutil.NopCloser(strings.NewReader("ping")),
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error once upstream is ready, got: %s", err)
+	}
+
+	if out.String() != "ping" {
+		t.Errorf("want body: %q, got: %q", "ping", out.String())
+	}
+}
+
+func TestHTTPFunctionRunner_Run_UnixSocketUpstream(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "function.sock")
+
+	// Left behind by a crashed process
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	f := startHTTPRunner(t, "unix://"+socket)
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("want stale socket to be removed by Start, got: %s", err)
+	}
+
+	var upstreamPath string
+	upstream := &httptest.Server{
+		Listener: listener,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamPath = r.URL.Path
+			echoHandler(w, r)
+		})},
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		InputReader:  ioutil.NopCloser(strings.NewReader("over a socket")),
+		OutputWriter: out,
+		Path:         "/function/echo",
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if out.String() != "over a socket" {
+		t.Errorf("want body: %q, got: %q", "over a socket", out.String())
+	}
+	if upstreamPath != "/function/echo" {
+		t.Errorf("want path: %q, got: %q", "/function/echo", upstreamPath)
+	}
+}
+
+func TestHTTPFunctionRunner_Run_RetriesWithBufferedBody(t *testing.T) {
+	cases := []struct {
+		name      string
+		buffer    bool
+		wantErr   error
+		wantCalls int32
+	}{
+		{name: "buffered", buffer: true, wantCalls: 2},
+		{name: "not buffered", buffer: false, wantErr: ErrBodyNotReplayable, wantCalls: 1},
+	}
+
+	for _, c := range cases {
+		var calls int32
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				// Drop the connection as a crashing upstream would.
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			echoHandler(w, r)
+		}))
+
+		f := startHTTPRunner(t, upstream.URL)
+		f.MaxRetries = 2
+		f.RetryBackoff = time.Millisecond
+		f.BufferRequestBody = c.buffer
+
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			InputReader:  ioutil.NopCloser(strings.NewReader("replay me")),
+			OutputWriter: out,
+		}
+
+		_, err := f.Run(req)
+		upstream.Close()
+
+		if c.wantErr == nil {
+			if err != nil {
+				t.Errorf("(%s) want no error, got: %s", c.name, err)
+			}
+			if out.String() != "replay me" {
+				t.Errorf("(%s) want body: %q, got: %q", c.name, "replay me", out.String())
+			}
+		} else if !errors.Is(err, c.wantErr) {
+			t.Errorf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+
+		if got := atomic.LoadInt32(&calls); got != c.wantCalls {
+			t.Errorf("(%s) want %d upstream calls, got: %d", c.name, c.wantCalls, got)
+		}
+	}
+}
+
+func TestHTTPFunctionRunner_Run_UpstreamHTTP2(t *testing.T) {
+	release := make(chan struct{})
+	var remoteAddrs sync.Map
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddrs.Store(r.RemoteAddr, true)
+		if r.ProtoMajor != 2 {
+			http.Error(w, "want HTTP/2, got: "+r.Proto, http.StatusHTTPVersionNotSupported)
+			return
+		}
+		if r.URL.Path == "/stream" {
+			w.Write([]byte("first "))
+			w.(http.Flusher).Flush()
+			<-release
+		}
+		echoHandler(w, r)
+	}))
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	upstream.Config.Protocols = protocols
+	upstream.Start()
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	f := &HTTPFunctionRunner{
+		ExecTimeout:       time.Second * 5,
+		Process:           "cat",
+		UpstreamURL:       upstreamURL,
+		ReadinessInterval: time.Millisecond * 10,
+		UpstreamHTTP2:     true,
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+
+	invoke := func(path string, body string, out io.Writer) error {
+		_, err := f.Run(FunctionRequest{
+			Path:         path,
+			InputReader:  ioutil.NopCloser(strings.NewReader(body)),
+			OutputWriter: out,
+		})
+		return err
+	}
+
+	out := &bytes.Buffer{}
+	if err := invoke("/", "warm", out); err != nil || out.String() != "warm" {
+		t.Fatalf("want body echoed over h2c, got: %q %v", out.String(), err)
+	}
+
+	// The response streams while another request shares the connection.
+	streamReader, streamWriter := io.Pipe()
+	streamDone := make(chan error, 1)
+	go func() {
+		err := invoke("/stream", "second", streamWriter)
+		streamWriter.Close()
+		streamDone <- err
+	}()
+
+	first := make([]byte, len("first "))
+	if _, err := io.ReadFull(streamReader, first); err != nil || string(first) != "first " {
+		t.Fatalf("want first chunk streamed before the response completes, got: %q %v", first, err)
+	}
+
+	concurrent := &bytes.Buffer{}
+	if err := invoke("/", "concurrent", concurrent); err != nil || concurrent.String() != "concurrent" {
+		t.Errorf("want concurrent request echoed, got: %q %v", concurrent.String(), err)
+	}
+
+	close(release)
+	rest, _ := ioutil.ReadAll(streamReader)
+	if err := <-streamDone; err != nil || string(rest) != "second" {
+		t.Errorf("want rest of streamed response, got: %q %v", rest, err)
+	}
+
+	connections := 0
+	remoteAddrs.Range(func(_, _ interface{}) bool {
+		connections++
+		return true
+	})
+	if connections != 1 {
+		t.Errorf("want requests multiplexed over 1 connection, got: %d", connections)
+	}
+}
+
+func TestHTTPFunctionRunner_Proxy_RetriesWithBufferedBody(t *testing.T) {
+	cases := []struct {
+		name       string
+		buffer     bool
+		wantStatus int
+		wantCalls  int32
+	}{
+		{name: "buffered", buffer: true, wantStatus: http.StatusOK, wantCalls: 2},
+		{name: "not buffered", buffer: false, wantStatus: http.StatusInternalServerError, wantCalls: 1},
+	}
+
+	for _, c := range cases {
+		var calls int32
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				// Drop the connection as a crashing upstream would.
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			echoHandler(w, r)
+		}))
+
+		f := startHTTPRunner(t, upstream.URL)
+		f.MaxRetries = 2
+		f.RetryBackoff = time.Millisecond
+		f.BufferRequestBody = c.buffer
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := f.Proxy(r, w); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		})
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("replay me")))
+		upstream.Close()
+
+		if rr.Code != c.wantStatus {
+			t.Errorf("(%s) want status %d, got: %d", c.name, c.wantStatus, rr.Code)
+		}
+		if c.buffer && rr.Body.String() != "replay me" {
+			t.Errorf("(%s) want body: %q, got: %q", c.name, "replay me", rr.Body.String())
+		}
+		if got := atomic.LoadInt32(&calls); got != c.wantCalls {
+			t.Errorf("(%s) want %d upstream calls, got: %d", c.name, c.wantCalls, got)
+		}
+	}
+}
+
+func TestHTTPFunctionRunner_Proxy_CancelledWithRequest(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+
+	f := startHTTPRunner(t, upstream.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+
+	done := make(chan struct{})
+	go func() {
+		f.Proxy(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx), httptest.NewRecorder())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Errorf("want Proxy to return once the incoming request is cancelled")
+	}
+}
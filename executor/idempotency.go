@@ -0,0 +1,209 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// idempotentCall is an in-flight invocation which later requests with the
+// same IdempotencyKey wait for.
+type idempotentCall struct {
+	bodyHash [sha256.Size]byte
+	done     chan struct{}
+	recorder *outputRecorder
+
+	// Set before done is closed.
+	headers http.Header
+	result  RunResult
+	err     error
+}
+
+// joinIdempotent buffers the request body, within ReadTimeout and
+// MaxRequestBytes, and either registers req as the invocation for its key,
+// replacing its OutputWriter so the output can be shared, or returns the call
+// already running for the key.
+func (f *ForkFunctionRunner) joinIdempotent(req *FunctionRequest) (*idempotentCall, bool, error) {
+	var body []byte
+	if req.InputReader != nil {
+		var err error
+		body, err = f.readIdempotentBody(req)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	hash := sha256.Sum256(body)
+
+	f.idempotentMutex.Lock()
+	defer f.idempotentMutex.Unlock()
+
+	if call, ok := f.idempotent[req.IdempotencyKey]; ok {
+		if call.bodyHash != hash {
+			return nil, false, ErrIdempotencyConflict
+		}
+		return call, false, nil
+	}
+
+	call := &idempotentCall{
+		bodyHash: hash,
+		done:     make(chan struct{}),
+		recorder: newOutputRecorder(req.OutputWriter),
+	}
+	if f.idempotent == nil {
+		f.idempotent = map[string]*idempotentCall{}
+	}
+	f.idempotent[req.IdempotencyKey] = call
+
+	contentLength := int64(len(body))
+	req.InputReader = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = &contentLength
+	req.OutputWriter = call.recorder.writer()
+
+	return call, true, nil
+}
+
+// readIdempotentBody reads the whole request body, giving up with
+// ErrReadTimeout once a read has blocked for ReadTimeout or when the
+// request's context is done. A read which has been given up on is left to
+// fail once the request is closed.
+func (f *ForkFunctionRunner) readIdempotentBody(req *FunctionRequest) ([]byte, error) {
+	type readResult struct {
+		body []byte
+		err  error
+	}
+
+	var input io.Reader = req.InputReader
+	var stalled <-chan struct{}
+	if f.ReadTimeout > 0 {
+		deadline := newDeadlineReader(input, f.ReadTimeout, func() {})
+		input, stalled = deadline, deadline.stalled
+	}
+
+	done := make(chan readResult, 1)
+	go func() {
+		body, err := readRequestBody(input, f.MaxRequestBytes)
+		req.InputReader.Close()
+		done <- readResult{body: body, err: err}
+	}()
+
+	select {
+	case read := <-done:
+		return read.body, read.err
+	case <-stalled:
+		return nil, ErrReadTimeout
+	case <-requestContext(*req).Done():
+		return nil, requestContext(*req).Err()
+	}
+}
+
+// finishIdempotent publishes the outcome of call and forgets its key, so a
+// later request with the same key runs the process again.
+func (f *ForkFunctionRunner) finishIdempotent(key string, call *idempotentCall, result RunResult, err error) {
+	call.headers = call.recorder.header()
+	call.result = result
+	call.err = err
+
+	f.idempotentMutex.Lock()
+	delete(f.idempotent, key)
+	f.idempotentMutex.Unlock()
+
+	close(call.done)
+}
+
+// replay waits for call and writes its output to req.OutputWriter.
+func (call *idempotentCall) replay(req FunctionRequest) (RunResult, error) {
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return RunResult{ExitCode: -1}, ctx.Err()
+	}
+
+	result := call.result
+	result.BytesWritten = 0
+	if req.OutputWriter == nil {
+		return result, call.err
+	}
+
+	if w, ok := req.OutputWriter.(http.ResponseWriter); ok {
+		for k, v := range call.headers {
+			w.Header()[k] = v
+		}
+		if call.recorder.status > 0 {
+			w.WriteHeader(call.recorder.status)
+		}
+	}
+
+	n, writeErr := req.OutputWriter.Write(call.recorder.Bytes())
+	result.BytesWritten = int64(n)
+	if call.err != nil {
+		return result, call.err
+	}
+	return result, writeErr
+}
+
+// outputRecorder keeps a copy of everything written to an output, and the
+// status written to it when it is an http.ResponseWriter.
+type outputRecorder struct {
+	bytes.Buffer
+	output io.Writer
+	status int
+}
+
+func newOutputRecorder(output io.Writer) *outputRecorder {
+	if output == nil {
+		output = ioutil.Discard
+	}
+	return &outputRecorder{output: output}
+}
+
+func (r *outputRecorder) Write(p []byte) (int, error) {
+	n, err := r.output.Write(p)
+	r.Buffer.Write(p[:n])
+	return n, err
+}
+
+// writer returns r as an http.ResponseWriter when its output is one.
+func (r *outputRecorder) writer() io.Writer {
+	if _, ok := r.output.(http.ResponseWriter); ok {
+		return &responseRecorder{r}
+	}
+	return r
+}
+
+// header returns a copy of the output's headers, if it has any.
+func (r *outputRecorder) header() http.Header {
+	if w, ok := r.output.(http.ResponseWriter); ok {
+		return w.Header().Clone()
+	}
+	return nil
+}
+
+// responseRecorder is an outputRecorder for an http.ResponseWriter
+type responseRecorder struct {
+	*outputRecorder
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.output.(http.ResponseWriter).Header()
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.output.(http.ResponseWriter).WriteHeader(status)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.output.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,210 @@
+package executor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// idempotentRequest counts each start of the process in countFile, then
+// waits for release to exist before echoing its body.
+func idempotentRequest(countFile string, release string, body string) FunctionRequest {
+	return FunctionRequest{
+		Process: "sh",
+		ProcessArgs: []string{"-c", "echo started >> " + countFile +
+			"; while [ ! -e " + release + " ]; do sleep 0.01; done; printf 'Status: 201\\n\\n'; cat"},
+		InputReader:    ioutil.NopCloser(strings.NewReader(body)),
+		IdempotencyKey: "order-1",
+	}
+}
+
+func waitForFile(t *testing.T, path string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %s to be created", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestForkFunctionRunner_Run_IdempotencyKeySharesResult(t *testing.T) {
+	dir := t.TempDir()
+	countFile, release := filepath.Join(dir, "count"), filepath.Join(dir, "release")
+
+	f := &ForkFunctionRunner{ParseResponseHeaders: true}
+
+	recorders := make([]*httptest.ResponseRecorder, 3)
+	errs := make([]error, 3)
+	var wg sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		req := idempotentRequest(countFile, release, "charge")
+		req.OutputWriter = recorders[i]
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = f.Run(req)
+		}(i)
+
+		if i == 0 {
+			waitForFile(t, countFile)
+		}
+	}
+
+	// Give the later requests time to join before the first completes.
+	time.Sleep(100 * time.Millisecond)
+	ioutil.WriteFile(release, nil, 0600)
+	wg.Wait()
+
+	for i, w := range recorders {
+		if errs[i] != nil {
+			t.Errorf("(%d) want no error, got: %s", i, errs[i])
+		}
+		if w.Code != 201 || w.Body.String() != "charge" {
+			t.Errorf("(%d) want shared status 201 and body, got: %d %q", i, w.Code, w.Body.String())
+		}
+	}
+
+	if count, _ := ioutil.ReadFile(countFile); strings.Count(string(count), "started") != 1 {
+		t.Errorf("want the process started once, got: %q", count)
+	}
+
+	// The result is not kept once the invocation has completed.
+	req := idempotentRequest(countFile, release, "charge")
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if count, _ := ioutil.ReadFile(countFile); strings.Count(string(count), "started") != 2 {
+		t.Errorf("want a later request with the key to start the process, got: %q", count)
+	}
+}
+
+func TestForkFunctionRunner_Run_IdempotencyKeyConflict(t *testing.T) {
+	dir := t.TempDir()
+	countFile, release := filepath.Join(dir, "count"), filepath.Join(dir, "release")
+
+	f := &ForkFunctionRunner{}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Run(idempotentRequest(countFile, release, "charge"))
+		done <- err
+	}()
+	waitForFile(t, countFile)
+
+	_, err := f.Run(idempotentRequest(countFile, release, "refund"))
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("want ErrIdempotencyConflict for a different body, got: %v", err)
+	}
+
+	ioutil.WriteFile(release, nil, 0600)
+	if err := <-done; err != nil {
+		t.Errorf("want first invocation unaffected, got: %s", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_IdempotentReplayNotCountedAgainstLifetime(t *testing.T) {
+	dir := t.TempDir()
+	countFile, release := filepath.Join(dir, "count"), filepath.Join(dir, "release")
+
+	f := &ForkFunctionRunner{MaxLifetimeInvocations: 2}
+
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range errs {
+		req := idempotentRequest(countFile, release, "charge")
+		req.OutputWriter = ioutil.Discard
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = f.Run(req)
+		}(i)
+
+		if i == 0 {
+			waitForFile(t, countFile)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	ioutil.WriteFile(release, nil, 0600)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("(%d) want no error, got: %s", i, err)
+		}
+	}
+	if !f.Ready() {
+		t.Errorf("want runner still ready, the replayed invocation does not count")
+	}
+
+	req := idempotentRequest(countFile, release, "charge")
+	if _, err := f.Run(req); err != nil {
+		t.Errorf("want the second run of the function accepted, got: %s", err)
+	}
+	if f.Ready() {
+		t.Errorf("want runner not ready once the function has run twice")
+	}
+}
+
+func TestForkFunctionRunner_Run_IdempotentBodyBoundedByReadTimeout(t *testing.T) {
+	f := &ForkFunctionRunner{
+		ReadTimeout: time.Millisecond * 100,
+	}
+
+	stall := make(chan struct{})
+	defer close(stall)
+	req := FunctionRequest{
+		Process:        "cat",
+		InputReader:    ioutil.NopCloser(&stallingReader{data: strings.NewReader("partial"), unblock: stall}),
+		OutputWriter:   ioutil.Discard,
+		IdempotencyKey: "order-1",
+	}
+
+	start := time.Now()
+	if _, err := f.Run(req); !errors.Is(err, ErrReadTimeout) {
+		t.Errorf("want ErrReadTimeout for a stalled idempotent body, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("want the idempotent body bounded by ReadTimeout, took: %s", elapsed)
+	}
+}
+
+func TestForkFunctionRunner_Run_IdempotentRequestRateLimitedBeforeBody(t *testing.T) {
+	dir := t.TempDir()
+	countFile, release := filepath.Join(dir, "count"), filepath.Join(dir, "release")
+	ioutil.WriteFile(release, nil, 0600)
+
+	f := &ForkFunctionRunner{
+		RateLimit: 1,
+	}
+	if _, err := f.Run(idempotentRequest(countFile, release, "charge")); err != nil {
+		t.Fatalf("want first invocation to run, got: %s", err)
+	}
+
+	stall := make(chan struct{})
+	defer close(stall)
+	req := idempotentRequest(countFile, release, "charge")
+	req.IdempotencyKey = "order-2"
+	req.InputReader = ioutil.NopCloser(&stallingReader{data: strings.NewReader("charge"), unblock: stall})
+
+	start := time.Now()
+	if _, err := f.Run(req); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("want ErrRateLimited before the body is read, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want the rate limit applied without reading the body, took: %s", elapsed)
+	}
+}
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"log"
+	"time"
+)
+
+// idleCheckInterval is how often idle processes are looked for, a fraction
+// of timeout so that one is stopped soon after it has been idle for that long.
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	if interval := timeout / 4; interval > time.Millisecond*10 {
+		return interval
+	}
+	return time.Millisecond * 10
+}
+
+// stopWhenIdle stops the process once no request has been sent to it for
+// IdleTimeout, until stop is closed. The next request starts it again.
+func (f *HTTPFunctionRunner) stopWhenIdle(stop chan struct{}) {
+	ticker := time.NewTicker(idleCheckInterval(f.IdleTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		f.stateMutex.Lock()
+		if f.Command == nil || !f.healthy || f.active > 0 || time.Since(f.lastUsed) < f.IdleTimeout {
+			f.stateMutex.Unlock()
+			continue
+		}
+		cmd := f.Command
+		f.Command = nil
+		f.healthy = false
+		f.idle = true
+		f.ready = make(chan struct{})
+		// Keeps MaxProcessLifetime from recycling the stopped process.
+		f.startedAt = time.Now()
+		f.stateMutex.Unlock()
+
+		log.Printf("Stopping function process after being idle for %s", f.IdleTimeout)
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+	}
+}
+
+// wake starts the process again if it was stopped by IdleTimeout, the
+// request then waits for it to be ready. When it cannot be started, the
+// requests waiting for it fail with the error and the next one tries again.
+func (f *HTTPFunctionRunner) wake() {
+	f.stateMutex.Lock()
+	if !f.idle {
+		f.stateMutex.Unlock()
+		return
+	}
+	f.idle = false
+	if f.startErr != nil {
+		f.ready = make(chan struct{})
+		f.startErr = nil
+	}
+	ready := f.ready
+	f.stateMutex.Unlock()
+
+	log.Printf("Starting idle function process")
+	if err := f.startProcess(ready); err != nil {
+		log.Printf("Unable to start idle function process: %s", err)
+		f.stateMutex.Lock()
+		f.idle = true
+		f.startErr = err
+		f.stateMutex.Unlock()
+		close(ready)
+	}
+}
+
+// stopIdleWorkers stops each worker which has not served an invocation for
+// IdleTimeout, leaving a nil slot so that the next invocation starts a new
+// one, until stop is closed.
+func (f *PooledForkFunctionRunner) stopIdleWorkers(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(idleCheckInterval(f.IdleTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// Only the workers waiting in the pool right now are looked at, each
+		// is put back before the next is taken.
+		for i := len(f.workers); i > 0; i-- {
+			select {
+			case worker := <-f.workers:
+				if worker != nil && time.Since(worker.lastUsed) >= f.IdleTimeout {
+					log.Printf("Stopping pooled worker after being idle for %s", f.IdleTimeout)
+					worker.stop()
+					worker = nil
+				}
+				f.workers <- worker
+			default:
+				i = 0
+			}
+		}
+	}
+}
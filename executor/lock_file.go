@@ -0,0 +1,105 @@
+package executor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// healthStatus is written to the lock file when WriteHealthStatus is set
+type healthStatus struct {
+	LastExitCode   int    `json:"last_exit_code"`
+	LastDurationMs int64  `json:"last_duration_ms"`
+	LastError      string `json:"last_error"`
+}
+
+// CreateLockFile writes LockFilePath to signal that the runner is ready,
+// overwriting any file left behind by a previous instance.
+func (f *ForkFunctionRunner) CreateLockFile() error {
+	return createLockFile(f.LockFilePath)
+}
+
+// RemoveLockFile removes LockFilePath, it is not an error if it does not exist.
+func (f *ForkFunctionRunner) RemoveLockFile() error {
+	return removeLockFile(f.LockFilePath)
+}
+
+// writeHealthStatus records the outcome of an invocation in LockFilePath,
+// unless the runner is shutting down and the file has been removed.
+func (f *ForkFunctionRunner) writeHealthStatus(result RunResult, err error) error {
+	if len(f.LockFilePath) == 0 {
+		return nil
+	}
+
+	status := healthStatus{
+		LastExitCode:   result.ExitCode,
+		LastDurationMs: result.Duration.Milliseconds(),
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+
+	data, marshalErr := json.Marshal(status)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	f.shutdownMutex.Lock()
+	defer f.shutdownMutex.Unlock()
+
+	if f.shuttingDown {
+		return nil
+	}
+	return writeFileAtomic(f.LockFilePath, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path, so readers never see a partial write.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func createLockFile(path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+
+	log.Printf("Writing lock file at: %s", path)
+	return ioutil.WriteFile(path, nil, 0600)
+}
+
+func removeLockFile(path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_LockFile(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), ".lock")
+
+	// Left behind by a crashed instance
+	if err := ioutil.WriteFile(lockFile, []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &ForkFunctionRunner{LockFilePath: lockFile}
+
+	if err := f.CreateLockFile(); err != nil {
+		t.Fatalf("want lock file to be created, got: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(lockFile)
+	if err != nil {
+		t.Fatalf("want lock file to exist after CreateLockFile, got: %s", err)
+	}
+	if len(data) != 0 {
+		t.Errorf("want stale lock file to be overwritten, got: %q", data)
+	}
+
+	if err := f.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("want lock file to be removed by Shutdown, got: %v", err)
+	}
+
+	if err := f.RemoveLockFile(); err != nil {
+		t.Errorf("want removing a missing lock file to succeed, got: %s", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_WriteHealthStatus(t *testing.T) {
+	dir := t.TempDir()
+	lockFile := filepath.Join(dir, ".lock")
+
+	f := &ForkFunctionRunner{LockFilePath: lockFile, WriteHealthStatus: true}
+	if err := f.CreateLockFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name         string
+		script       string
+		wantExitCode int
+		wantError    string
+	}{
+		{name: "successful invocation", script: "sleep 0.05", wantExitCode: 0},
+		{name: "failed invocation", script: "echo broken >&2; exit 3", wantExitCode: 3, wantError: "broken"},
+		{name: "recovered invocation", script: "exit 0", wantExitCode: 0},
+	}
+
+	for _, c := range cases {
+		result, _ := f.Run(FunctionRequest{
+			Process:     "sh",
+			ProcessArgs: []string{"-c", c.script},
+		})
+
+		data, err := ioutil.ReadFile(lockFile)
+		if err != nil {
+			t.Fatalf("(%s) want status file, got: %s", c.name, err)
+		}
+
+		var status healthStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Fatalf("(%s) want JSON status, got: %q", c.name, data)
+		}
+
+		if status.LastExitCode != c.wantExitCode {
+			t.Errorf("(%s) want last_exit_code %d, got: %d", c.name, c.wantExitCode, status.LastExitCode)
+		}
+		if status.LastDurationMs != result.Duration.Milliseconds() {
+			t.Errorf("(%s) want last_duration_ms %d, got: %d", c.name, result.Duration.Milliseconds(), status.LastDurationMs)
+		}
+		if len(c.wantError) == 0 && len(status.LastError) > 0 || !strings.Contains(status.LastError, c.wantError) {
+			t.Errorf("(%s) want last_error %q, got: %q", c.name, c.wantError, status.LastError)
+		}
+	}
+
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("want temporary files renamed over the lock file, got %d files", len(entries))
+	}
+
+	if err := f.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	f.writeHealthStatus(RunResult{}, nil)
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("want status not written once shutting down, got: %v", err)
+	}
+}
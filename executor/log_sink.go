@@ -0,0 +1,109 @@
+package executor
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSinkDialTimeout  = time.Second
+	defaultSinkWriteTimeout = time.Second
+	defaultSinkMinBackoff   = time.Millisecond * 100
+	defaultSinkMaxBackoff   = time.Second * 30
+)
+
+// ReconnectingWriter writes to a log collector over the network, such as a
+// syslog server, for use as a LogSink. The connection is dialled on the
+// first Write and again after a failure, backing off exponentially between
+// attempts. Writes fail straight away while it is backing off.
+type ReconnectingWriter struct {
+	Network string
+	Address string
+
+	// DialTimeout and WriteTimeout both default to 1s.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MinBackoff is the first delay before redialling, defaults to 100ms,
+	// and doubles up to MaxBackoff which defaults to 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mutex   sync.Mutex
+	conn    net.Conn
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// Write sends p over the connection, dialling it first when needed.
+func (w *ReconnectingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn == nil {
+		if wait := time.Until(w.retryAt); wait > 0 {
+			return 0, fmt.Errorf("log sink %s unavailable, retrying in %s", w.Address, wait.Round(time.Millisecond))
+		}
+
+		dialTimeout := w.DialTimeout
+		if dialTimeout <= 0 {
+			dialTimeout = defaultSinkDialTimeout
+		}
+		conn, err := net.DialTimeout(w.Network, w.Address, dialTimeout)
+		if err != nil {
+			w.failed()
+			return 0, err
+		}
+		w.conn = conn
+	}
+
+	writeTimeout := w.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = defaultSinkWriteTimeout
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	n, err := w.conn.Write(p)
+	if err != nil {
+		w.conn.Close()
+		w.conn = nil
+		w.failed()
+		return n, err
+	}
+
+	w.backoff = 0
+	return n, nil
+}
+
+// failed schedules the next dial after a doubled backoff.
+func (w *ReconnectingWriter) failed() {
+	minBackoff, maxBackoff := w.MinBackoff, w.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultSinkMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultSinkMaxBackoff
+	}
+
+	if w.backoff == 0 {
+		w.backoff = minBackoff
+	} else if w.backoff *= 2; w.backoff > maxBackoff {
+		w.backoff = maxBackoff
+	}
+	w.retryAt = time.Now().Add(w.backoff)
+}
+
+// Close closes the connection, a later Write dials it again.
+func (w *ReconnectingWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptLines reads the first count lines sent on the next connection to listener.
+func acceptLines(t *testing.T, listener net.Listener, count int) []string {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Errorf("want a connection to the sink, got: %s", err)
+		return nil
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for len(lines) < count && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestForkFunctionRunner_Run_LogSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	sink := &ReconnectingWriter{Network: "tcp", Address: listener.Addr().String()}
+	defer sink.Close()
+
+	f := ForkFunctionRunner{LogSink: sink}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "echo first >&2; echo body; echo second >&2"},
+		OutputWriter: out,
+	}
+
+	lines := make(chan []string, 1)
+	go func() {
+		lines <- acceptLines(t, listener, 2)
+	}()
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if out.String() != "body\n" {
+		t.Errorf("want body %q, got: %q", "body\n", out.String())
+	}
+
+	got := <-lines
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("want stderr lines at the sink, got: %q", got)
+	}
+}
+
+func TestForkFunctionRunner_Run_LogSinkFailure(t *testing.T) {
+	f := ForkFunctionRunner{LogSink: failingWriter{err: errors.New("collector down")}}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "echo first >&2; echo body; echo second >&2"},
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want a failing sink not to fail the invocation, got: %s", err)
+	}
+	if out.String() != "body\n" {
+		t.Errorf("want body %q, got: %q", "body\n", out.String())
+	}
+}
+
+func TestReconnectingWriter_Reconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	w := &ReconnectingWriter{
+		Network:    "tcp",
+		Address:    listener.Addr().String(),
+		MinBackoff: time.Millisecond * 10,
+	}
+	defer w.Close()
+
+	lines := make(chan []string, 1)
+	go func() {
+		lines <- acceptLines(t, listener, 1)
+	}()
+	if _, err := w.Write([]byte("before\n")); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if got := <-lines; len(got) != 1 || got[0] != "before" {
+		t.Errorf("want %q, got: %q", "before", got)
+	}
+
+	// The collector has closed the connection, writes fail until one
+	// after the backoff dials it again.
+	go func() {
+		lines <- acceptLines(t, listener, 1)
+	}()
+	failed := false
+	delivered := eventually(func() bool {
+		_, err := w.Write([]byte("after\n"))
+		if err != nil {
+			failed = true
+		}
+		return err == nil && failed
+	})
+	if !delivered {
+		t.Fatalf("want a write to fail and a later one to reconnect")
+	}
+	if got := <-lines; len(got) != 1 || got[0] != "after" {
+		t.Errorf("want %q after reconnecting, got: %q", "after", got)
+	}
+}
@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+)
+
+// Logger records the start and completion of each invocation
+type Logger interface {
+	Started(req FunctionRequest)
+	Completed(req FunctionRequest, result RunResult, err error)
+}
+
+// TextLogger is the default Logger, it writes plain text lines through the log package
+type TextLogger struct{}
+
+// Started logs the process being run
+func (TextLogger) Started(req FunctionRequest) {
+	logRequest(req.RequestID, "Running %s", req.Process)
+}
+
+// Completed logs how long the invocation took, and of that how long the
+// process took to start and then ran for
+func (TextLogger) Completed(req FunctionRequest, result RunResult, err error) {
+	logRequest(req.RequestID, "Took %f secs, start: %f secs, exec: %f secs",
+		result.Duration.Seconds(), result.StartLatency.Seconds(), result.ExecDuration.Seconds())
+}
+
+// JSONLogger writes one JSON object per completed invocation to Writer, or to
+// the log package's output when Writer is nil
+type JSONLogger struct {
+	Writer io.Writer
+
+	mutex sync.Mutex
+}
+
+type invocationLogEntry struct {
+	RequestID       string  `json:"request_id,omitempty"`
+	Process         string  `json:"process"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	StartSeconds    float64 `json:"start_latency_seconds"`
+	ExecSeconds     float64 `json:"exec_duration_seconds"`
+	ExitCode        int     `json:"exit_code"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// Started is a no-op, the entry is written on completion
+func (l *JSONLogger) Started(req FunctionRequest) {
+}
+
+// Completed writes the invocation's entry
+func (l *JSONLogger) Completed(req FunctionRequest, result RunResult, err error) {
+	entry := invocationLogEntry{
+		RequestID:       req.RequestID,
+		Process:         req.Process,
+		DurationSeconds: result.Duration.Seconds(),
+		StartSeconds:    result.StartLatency.Seconds(),
+		ExecSeconds:     result.ExecDuration.Seconds(),
+		ExitCode:        result.ExitCode,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	line, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		log.Printf("Unable to marshal log entry: %s", marshalErr)
+		return
+	}
+
+	writer := l.Writer
+	if writer == nil {
+		writer = log.Writer()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	writer.Write(append(line, '\n'))
+}
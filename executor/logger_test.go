@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONLogger_WritesInvocationEntry(t *testing.T) {
+	out := &bytes.Buffer{}
+	f := ForkFunctionRunner{Logger: &JSONLogger{Writer: out}}
+
+	f.Run(FunctionRequest{Process: "sh", ProcessArgs: []string{"-c", "exit 2"}})
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("want valid JSON, got: %q (%s)", out.String(), err)
+	}
+
+	for _, key := range []string{"process", "duration_seconds", "exit_code", "error"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("want key %q in entry: %v", key, entry)
+		}
+	}
+
+	if entry["process"] != "sh" {
+		t.Errorf("want process: sh, got: %v", entry["process"])
+	}
+
+	if entry["exit_code"] != float64(2) {
+		t.Errorf("want exit_code: 2, got: %v", entry["exit_code"])
+	}
+}
+
+func TestJSONLogger_OmitsErrorOnSuccess(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := &JSONLogger{Writer: out}
+
+	l.Completed(FunctionRequest{Process: "true"}, RunResult{}, nil)
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("want valid JSON, got: %q (%s)", out.String(), err)
+	}
+
+	if _, ok := entry["error"]; ok {
+		t.Errorf("want no error key on success, got: %v", entry)
+	}
+}
@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"context"
+	"sync"
+)
+
+// MockFunctionRunner is a FunctionRunner for testing code which uses one
+// without starting processes. It records every request and returns Result
+// and Err, writing Output to req.OutputWriter first when it is set.
+type MockFunctionRunner struct {
+	Result RunResult
+	Err    error
+	Output []byte
+
+	mutex    sync.Mutex
+	requests []FunctionRequest
+}
+
+// Run calls RunContext with req.Context, or context.Background() when it is not set
+func (m *MockFunctionRunner) Run(req FunctionRequest) (RunResult, error) {
+	return m.RunContext(requestContext(req), req)
+}
+
+// RunContext records req and returns the canned result
+func (m *MockFunctionRunner) RunContext(ctx context.Context, req FunctionRequest) (RunResult, error) {
+	req.Context = ctx
+
+	m.mutex.Lock()
+	m.requests = append(m.requests, req)
+	m.mutex.Unlock()
+
+	result := m.Result
+	if len(m.Output) > 0 && req.OutputWriter != nil {
+		n, err := req.OutputWriter.Write(m.Output)
+		result.BytesWritten = int64(n)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, m.Err
+}
+
+// CallCount returns how many times Run has been called.
+func (m *MockFunctionRunner) CallCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.requests)
+}
+
+// Requests returns every request passed to Run, oldest first.
+func (m *MockFunctionRunner) Requests() []FunctionRequest {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]FunctionRequest{}, m.requests...)
+}
+
+// LastRequest returns the most recent request passed to Run, ok is false
+// when Run has not been called.
+func (m *MockFunctionRunner) LastRequest() (req FunctionRequest, ok bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.requests) == 0 {
+		return FunctionRequest{}, false
+	}
+	return m.requests[len(m.requests)-1], true
+}
+
+// Reset forgets the recorded requests.
+func (m *MockFunctionRunner) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests = nil
+}
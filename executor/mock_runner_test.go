@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// handlerUsing is the kind of HTTP handler MockFunctionRunner is meant to test.
+func handlerUsing(runner FunctionRunner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := runner.Run(FunctionRequest{
+			Process:      "handler",
+			Method:       r.Method,
+			Path:         r.URL.Path,
+			OutputWriter: w,
+		})
+		if err != nil {
+			w.Write([]byte(" failed: " + err.Error()))
+		}
+	}
+}
+
+func TestMockFunctionRunner_WritesCannedOutput(t *testing.T) {
+	mock := &MockFunctionRunner{
+		Output: []byte("canned"),
+		Result: RunResult{ExitCode: 0, HTTPStatus: 201},
+	}
+
+	w := httptest.NewRecorder()
+	handlerUsing(mock).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	if w.Body.String() != "canned" {
+		t.Errorf("want canned output written, got: %q", w.Body.String())
+	}
+
+	result, err := mock.Run(FunctionRequest{})
+	if err != nil || result.HTTPStatus != 201 {
+		t.Errorf("want canned result without an OutputWriter, got: %+v %v", result, err)
+	}
+}
+
+func TestMockFunctionRunner_RecordsRequests(t *testing.T) {
+	mock := &MockFunctionRunner{Err: errors.New("boom")}
+
+	if _, ok := mock.LastRequest(); ok || mock.CallCount() != 0 {
+		t.Errorf("want no requests recorded before Run")
+	}
+
+	handler := handlerUsing(mock)
+	for _, path := range []string{"/first", "/second"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if w.Body.String() != " failed: boom" {
+			t.Errorf("(%s) want canned error, got: %q", path, w.Body.String())
+		}
+	}
+
+	if got := mock.CallCount(); got != 2 {
+		t.Errorf("want CallCount 2, got: %d", got)
+	}
+
+	last, ok := mock.LastRequest()
+	if !ok || last.Path != "/second" || last.Method != http.MethodGet {
+		t.Errorf("want last request for /second, got: %+v", last)
+	}
+
+	if requests := mock.Requests(); len(requests) != 2 || requests[0].Path != "/first" {
+		t.Errorf("want requests in order, got: %+v", requests)
+	}
+
+	mock.Reset()
+	if mock.CallCount() != 0 {
+		t.Errorf("want no requests after Reset, got: %d", mock.CallCount())
+	}
+}
@@ -0,0 +1,13 @@
+package executor
+
+import "syscall"
+
+// currentNice returns the watchdog's niceness, the raw getpriority system
+// call on Linux returns it as 20 - nice.
+func currentNice() (int, error) {
+	priority, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return 0, err
+	}
+	return 20 - priority, nil
+}
@@ -0,0 +1,72 @@
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_Nice(t *testing.T) {
+	cases := []struct {
+		name       string
+		nice       int
+		privileged bool
+	}{
+		{name: "raise niceness", nice: 10},
+		{name: "lower niceness", nice: -5, privileged: true},
+	}
+
+	for _, c := range cases {
+		if c.privileged && os.Geteuid() != 0 {
+			t.Logf("(%s) skipped, requires root", c.name)
+			continue
+		}
+
+		f := ForkFunctionRunner{Nice: c.nice}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "awk",
+			ProcessArgs:  []string{"{print $19}", "/proc/self/stat"},
+			OutputWriter: out,
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+			continue
+		}
+
+		if got := strings.TrimSpace(out.String()); got != strconv.Itoa(c.nice) {
+			t.Errorf("(%s) want niceness %d, got: %q", c.name, c.nice, got)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_NiceNotPermitted(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root is always permitted to lower niceness")
+	}
+
+	f := ForkFunctionRunner{Nice: -20}
+	req := FunctionRequest{
+		Process:     "sh",
+		ProcessArgs: []string{"-c", "exit 0"},
+	}
+
+	if _, err := f.Run(req); !errors.Is(err, ErrNiceNotPermitted) {
+		t.Errorf("want ErrNiceNotPermitted, got: %v", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_NiceOutOfRange(t *testing.T) {
+	for _, nice := range []int{-21, 20} {
+		f := ForkFunctionRunner{Nice: nice}
+		req := FunctionRequest{Process: "true"}
+
+		if _, err := f.Run(req); err == nil || !strings.Contains(err.Error(), "invalid Nice") {
+			t.Errorf("(%d) want range error, got: %v", nice, err)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package executor
+
+import "syscall"
+
+// currentNice returns the watchdog's niceness.
+func currentNice() (int, error) {
+	return syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// niceCommand wraps process in nice(1) so that it runs with the niceness
+// nice from the start. nice(1) only warns when it is not permitted to lower
+// the niceness and runs the process anyway, so that is checked here first.
+func niceCommand(process string, args []string, nice int) (string, []string, error) {
+	current, err := currentNice()
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read niceness: %w", err)
+	}
+	if nice < current && os.Geteuid() != 0 {
+		return "", nil, fmt.Errorf("%w: lowering it from %d to %d requires root", ErrNiceNotPermitted, current, nice)
+	}
+
+	return "nice", append([]string{"-n", strconv.Itoa(nice - current), process}, args...), nil
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package executor
+
+import "errors"
+
+// niceCommand is not supported on Windows.
+func niceCommand(process string, args []string, nice int) (string, []string, error) {
+	return "", nil, errors.New("niceness is not supported on Windows")
+}
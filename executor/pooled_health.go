@@ -0,0 +1,100 @@
+package executor
+
+import (
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// Healthy is true once the workers have started and while they pass their
+// health checks.
+func (f *PooledForkFunctionRunner) Healthy() bool {
+	f.healthMutex.Lock()
+	defer f.healthMutex.Unlock()
+	return f.healthy
+}
+
+// Ready is Healthy, for the HealthHandler.
+func (f *PooledForkFunctionRunner) Ready() bool {
+	return f.Healthy()
+}
+
+// checkHealth sends HealthCheckRequest to the idle workers until stop is
+// closed, replacing each one which fails.
+func (f *PooledForkFunctionRunner) checkHealth(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	interval := f.HealthCheckInterval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// As with IdleTimeout, only the workers waiting in the pool right now
+		// are checked, a nil slot is started by the next invocation.
+		passed := true
+		for i := len(f.workers); i > 0; i-- {
+			select {
+			case worker := <-f.workers:
+				if worker != nil && !worker.probe(f.HealthCheckRequest, interval) {
+					passed = false
+					f.setHealthy(false)
+					log.Printf("Replacing pooled worker which failed its health check")
+					f.recycle(worker)
+					continue
+				}
+				f.workers <- worker
+			default:
+				i = 0
+			}
+		}
+		f.setHealthy(passed)
+	}
+}
+
+// setHealthy records the outcome of the health checks, writing or removing
+// LockFilePath when it changes.
+func (f *PooledForkFunctionRunner) setHealthy(healthy bool) {
+	f.healthMutex.Lock()
+	changed := f.healthy != healthy
+	f.healthy = healthy
+	f.healthMutex.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		if err := createLockFile(f.LockFilePath); err != nil {
+			log.Printf("Unable to write lock file: %s", err)
+		}
+	} else if err := removeLockFile(f.LockFilePath); err != nil {
+		log.Printf("Unable to remove lock file: %s", err)
+	}
+}
+
+// probe sends request to the worker and is true when a response arrives
+// within timeout, the worker is killed when it does not.
+func (w *pooledWorker) probe(request []byte, timeout time.Duration) bool {
+	done := make(chan error, 1)
+	go func() {
+		done <- w.invoke(request, ioutil.Discard)
+	}()
+
+	select {
+	case err := <-done:
+		return err == nil
+	case <-time.After(timeout):
+		w.kill()
+		<-done
+		return false
+	}
+}
@@ -0,0 +1,392 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// PooledForkFunctionRunner keeps PoolSize processes running and hands each
+// invocation to an idle one. Requests and responses are framed on the
+// worker's stdin and stdout as a 4-byte big-endian length followed by the body.
+type PooledForkFunctionRunner struct {
+	Process     string
+	ProcessArgs []string
+	Environment []string
+
+	// PoolSize is the number of workers kept running, defaults to 1.
+	PoolSize int
+
+	// MaxRequestsPerWorker recycles a worker after it has served this many
+	// invocations, zero means never.
+	MaxRequestsPerWorker int
+
+	// MaxProcessLifetime recycles a worker once it has been running for this
+	// long, zero means never. A worker is only recycled between invocations,
+	// so one past its lifetime finishes the invocation it is serving first.
+	MaxProcessLifetime time.Duration
+
+	// IdleTimeout stops a worker once it has not served an invocation for
+	// this long, zero means never. A new worker is started for the next
+	// invocation which would have been given it.
+	IdleTimeout time.Duration
+
+	// ExecTimeout bounds each invocation, the worker is replaced if it expires.
+	ExecTimeout time.Duration
+
+	// ForwardSignals are relayed to every worker when the watchdog receives
+	// them, except for SIGTERM and SIGINT.
+	ForwardSignals []os.Signal
+
+	// HealthCheckRequest is sent to each idle worker every
+	// HealthCheckInterval, defaults to 10s, and any response received within
+	// the interval passes. A worker which fails is replaced straight away, as
+	// one which has not answered cannot be sent anything else, and the runner
+	// is not Healthy until every worker passes again.
+	HealthCheckRequest  []byte
+	HealthCheckInterval time.Duration
+
+	// LockFilePath is written once the workers have started and removed
+	// while the runner is not Healthy.
+	LockFilePath string
+
+	configMutex sync.RWMutex
+	workers     chan *pooledWorker
+	closed      chan struct{}
+	forwarder   signalForwarder
+	idleStop    chan struct{}
+	idleDone    chan struct{}
+	healthMutex sync.Mutex
+	healthy     bool
+	healthStop  chan struct{}
+	healthDone  chan struct{}
+}
+
+// pooledWorker is a running process waiting for framed requests
+type pooledWorker struct {
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	stdout     *bufio.Reader
+	stdoutFile *os.File
+	exited     chan struct{}
+	requests   int
+	startedAt  time.Time
+	lastUsed   time.Time
+}
+
+// Start forks the workers used for processing incoming requests
+func (f *PooledForkFunctionRunner) Start() error {
+	size := f.PoolSize
+	if size <= 0 {
+		size = 1
+	}
+
+	if len(f.ForwardSignals) > 0 {
+		f.forwarder.start(f.ForwardSignals)
+	}
+
+	f.workers = make(chan *pooledWorker, size)
+	f.closed = make(chan struct{})
+	for i := 0; i < size; i++ {
+		worker, err := f.startWorker()
+		if err != nil {
+			for len(f.workers) > 0 {
+				(<-f.workers).stop()
+			}
+			f.workers = nil
+			return err
+		}
+		f.workers <- worker
+	}
+
+	if f.IdleTimeout > 0 {
+		f.idleStop, f.idleDone = make(chan struct{}), make(chan struct{})
+		go f.stopIdleWorkers(f.idleStop, f.idleDone)
+	}
+
+	f.setHealthy(true)
+	if f.HealthCheckRequest != nil {
+		f.healthStop, f.healthDone = make(chan struct{}), make(chan struct{})
+		go f.checkHealth(f.healthStop, f.healthDone)
+	}
+	return nil
+}
+
+// Warmup fills the pool before traffic arrives, it does nothing once started.
+func (f *PooledForkFunctionRunner) Warmup() error {
+	if f.workers != nil {
+		return nil
+	}
+	return f.Start()
+}
+
+// Close stops every worker once it has finished its current invocation,
+// invocations still waiting for a worker fail with ErrRunnerClosed.
+func (f *PooledForkFunctionRunner) Close() error {
+	if f.closed != nil {
+		select {
+		case <-f.closed:
+			return nil
+		default:
+			close(f.closed)
+		}
+	}
+
+	if f.idleStop != nil {
+		close(f.idleStop)
+		<-f.idleDone
+		f.idleStop = nil
+	}
+	if f.healthStop != nil {
+		close(f.healthStop)
+		<-f.healthDone
+		f.healthStop = nil
+	}
+
+	for i := 0; i < cap(f.workers); i++ {
+		if worker := <-f.workers; worker != nil {
+			worker.stop()
+		}
+	}
+	f.forwarder.stop()
+
+	f.healthMutex.Lock()
+	f.healthy = false
+	f.healthMutex.Unlock()
+	return removeLockFile(f.LockFilePath)
+}
+
+// Run calls RunContext with req.Context, or context.Background() when it is not set
+func (f *PooledForkFunctionRunner) Run(req FunctionRequest) (RunResult, error) {
+	return f.RunContext(requestContext(req), req)
+}
+
+// RunContext sends the request body to an idle worker and copies its
+// response to req.OutputWriter.
+func (f *PooledForkFunctionRunner) RunContext(ctx context.Context, req FunctionRequest) (RunResult, error) {
+	req.Context = ctx
+
+	result := RunResult{ExitCode: -1}
+	if f.workers == nil {
+		return result, errors.New("pool has not been started")
+	}
+
+	if execTimeout := f.execTimeout(); execTimeout > time.Millisecond*0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, execTimeout)
+		defer cancel()
+	}
+
+	var worker *pooledWorker
+	select {
+	case worker = <-f.workers:
+	case <-f.closed:
+		return result, ErrRunnerClosed
+	case <-ctx.Done():
+		return result, ctx.Err()
+	}
+
+	// A worker freed while Close is waiting for it is left for Close.
+	select {
+	case <-f.closed:
+		f.workers <- worker
+		return result, ErrRunnerClosed
+	default:
+	}
+
+	if worker != nil && worker.expired(f.MaxProcessLifetime) {
+		logRequest(req.RequestID, "Recycling pooled worker after %s", time.Since(worker.startedAt).Round(time.Millisecond))
+		worker.stop()
+	}
+
+	// A nil slot is left behind when a replacement worker failed to start,
+	// or a worker was stopped after IdleTimeout.
+	if worker == nil || worker.hasExited() {
+		logRequest(req.RequestID, "Replacing pooled worker which is not running")
+		if worker != nil {
+			worker.stop()
+		}
+		var err error
+		if worker, err = f.startWorker(); err != nil {
+			f.workers <- nil
+			return result, err
+		}
+	}
+
+	start := time.Now()
+
+	var body []byte
+	if req.InputReader != nil {
+		var err error
+		defer req.InputReader.Close()
+		if body, err = ioutil.ReadAll(req.InputReader); err != nil {
+			f.workers <- worker
+			return result, err
+		}
+	}
+
+	output := req.OutputWriter
+	if output == nil {
+		output = ioutil.Discard
+	}
+	counter := &countingWriter{writer: output}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.invoke(body, counter)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		worker.kill()
+		<-done
+		err = fmt.Errorf("function killed: %w", ctx.Err())
+	}
+
+	result.Duration = time.Since(start)
+	result.BytesWritten = counter.Count()
+
+	worker.requests++
+	worker.lastUsed = time.Now()
+	if err != nil || (f.MaxRequestsPerWorker > 0 && worker.requests >= f.MaxRequestsPerWorker) ||
+		worker.expired(f.MaxProcessLifetime) {
+		f.recycle(worker)
+	} else {
+		f.workers <- worker
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	result.ExitCode = 0
+	return result, nil
+}
+
+// recycle stops worker and returns a fresh one to the pool.
+func (f *PooledForkFunctionRunner) recycle(worker *pooledWorker) {
+	worker.stop()
+
+	replacement, err := f.startWorker()
+	if err != nil {
+		logRequest("", "Unable to replace pooled worker: %s", err)
+		f.workers <- nil
+		return
+	}
+	f.workers <- replacement
+}
+
+func (f *PooledForkFunctionRunner) startWorker() (*pooledWorker, error) {
+	cmd := exec.Command(f.Process, f.ProcessArgs...)
+	cmd.Env = f.Environment
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	// The watchdog owns the read ends of stdout and stderr, rather than
+	// using cmd.StdoutPipe, as Wait runs alongside reading them and would
+	// close them before the last response or stderr lines had been read.
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutWriter.Close()
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = stdoutWriter, stderrWriter
+
+	startErr := cmd.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if startErr != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, startErr
+	}
+
+	go func() {
+		defer stderr.Close()
+		logStderr(stderr, ioutil.Discard, nil, nil, "")
+	}()
+
+	worker := &pooledWorker{
+		cmd:        cmd,
+		stdin:      stdin,
+		stdout:     bufio.NewReader(stdout),
+		stdoutFile: stdout,
+		exited:     make(chan struct{}),
+		startedAt:  time.Now(),
+	}
+	worker.lastUsed = worker.startedAt
+
+	f.forwarder.add(cmd.Process)
+	go func() {
+		cmd.Wait()
+		f.forwarder.remove(cmd.Process)
+		close(worker.exited)
+	}()
+
+	return worker, nil
+}
+
+// invoke writes one request frame and copies the response frame to w.
+func (w *pooledWorker) invoke(body []byte, output io.Writer) error {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(body)))
+
+	if _, err := w.stdin.Write(append(header, body...)); err != nil {
+		return fmt.Errorf("unable to write request to worker: %w", err)
+	}
+
+	if _, err := io.ReadFull(w.stdout, header); err != nil {
+		return fmt.Errorf("unable to read response from worker: %w", err)
+	}
+
+	size := int64(binary.BigEndian.Uint32(header))
+	if _, err := io.CopyN(output, w.stdout, size); err != nil {
+		return fmt.Errorf("unable to read response from worker: %w", err)
+	}
+	return nil
+}
+
+func (w *pooledWorker) hasExited() bool {
+	select {
+	case <-w.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop closes stdin so the worker can exit and kills it if it does not,
+// then closes stdout as nothing more is read from it.
+func (w *pooledWorker) stop() {
+	w.stdin.Close()
+
+	select {
+	case <-w.exited:
+	case <-time.After(time.Second):
+		w.kill()
+	}
+	w.stdoutFile.Close()
+}
+
+func (w *pooledWorker) kill() {
+	w.cmd.Process.Kill()
+	<-w.exited
+}
@@ -0,0 +1,309 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPooledWorkerProcess is not a real test, it is started by the tests below
+// as a worker which replies to each framed request with its pid and the body.
+// A body of "sleep <duration>" is replied to after that long, the worker
+// exits straight after replying to "last" and stops answering after "hang".
+func TestPooledWorkerProcess(t *testing.T) {
+	if os.Getenv("WANT_POOLED_WORKER") != "1" {
+		return
+	}
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(os.Stdin, header); err != nil {
+			os.Exit(0)
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(os.Stdin, body); err != nil {
+			os.Exit(1)
+		}
+		if string(body) == "exit" {
+			os.Exit(1)
+		}
+		if strings.HasPrefix(string(body), "sleep ") {
+			delay, _ := time.ParseDuration(strings.TrimPrefix(string(body), "sleep "))
+			time.Sleep(delay)
+		}
+
+		response := []byte(fmt.Sprintf("%d %s", os.Getpid(), body))
+		binary.BigEndian.PutUint32(header, uint32(len(response)))
+		os.Stdout.Write(append(header, response...))
+		if string(body) == "last" {
+			os.Exit(0)
+		}
+		if string(body) == "hang" {
+			time.Sleep(time.Hour)
+		}
+	}
+}
+
+func newPooledTestRunner(t *testing.T, f *PooledForkFunctionRunner) *PooledForkFunctionRunner {
+	f.Process = os.Args[0]
+	f.ProcessArgs = []string{"-test.run=^TestPooledWorkerProcess$"}
+	f.Environment = append(os.Environ(), "WANT_POOLED_WORKER=1")
+
+	if err := f.Start(); err != nil {
+		t.Fatalf("want pool to start, got: %s", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// invokePooled runs body through f and returns the worker's pid and reply.
+func invokePooled(t *testing.T, f *PooledForkFunctionRunner, body string) (string, string) {
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		InputReader:  ioutil.NopCloser(strings.NewReader(body)),
+		OutputWriter: out,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if result.BytesWritten != int64(out.Len()) {
+		t.Errorf("want BytesWritten %d, got: %d", out.Len(), result.BytesWritten)
+	}
+
+	parts := strings.SplitN(out.String(), " ", 2)
+	if len(parts) != 2 {
+		t.Fatalf("want pid and body, got: %q", out.String())
+	}
+	return parts[0], parts[1]
+}
+
+func TestPooledForkFunctionRunner_ReusesWorkers(t *testing.T) {
+	f := newPooledTestRunner(t, &PooledForkFunctionRunner{})
+
+	pid, reply := invokePooled(t, f, "first")
+	if reply != "first" {
+		t.Errorf("want reply %q, got: %q", "first", reply)
+	}
+
+	for i := 0; i < 3; i++ {
+		next, _ := invokePooled(t, f, "again")
+		if next != pid {
+			t.Errorf("want worker %s to be reused, got: %s", pid, next)
+		}
+	}
+}
+
+func TestPooledForkFunctionRunner_RecyclesAfterMaxRequests(t *testing.T) {
+	f := newPooledTestRunner(t, &PooledForkFunctionRunner{MaxRequestsPerWorker: 2})
+
+	first, _ := invokePooled(t, f, "1")
+	second, _ := invokePooled(t, f, "2")
+	third, _ := invokePooled(t, f, "3")
+
+	if first != second {
+		t.Errorf("want worker %s to serve 2 invocations, got: %s", first, second)
+	}
+	if third == first {
+		t.Errorf("want worker %s to be recycled after 2 invocations", first)
+	}
+}
+
+func TestPooledForkFunctionRunner_RecyclesAfterMaxProcessLifetime(t *testing.T) {
+	f := newPooledTestRunner(t, &PooledForkFunctionRunner{MaxProcessLifetime: time.Millisecond * 100})
+
+	first, _ := invokePooled(t, f, "1")
+	again, _ := invokePooled(t, f, "2")
+	if again != first {
+		t.Errorf("want worker %s reused within its lifetime, got: %s", first, again)
+	}
+
+	// The invocation outlives the worker's lifetime, but still completes.
+	busy, reply := invokePooled(t, f, "sleep 150ms")
+	if busy != first || reply != "sleep 150ms" {
+		t.Errorf("want in-flight invocation completed by worker %s, got: %s %q", first, busy, reply)
+	}
+
+	next, _ := invokePooled(t, f, "3")
+	if next == first {
+		t.Errorf("want worker %s recycled after its lifetime", first)
+	}
+
+	time.Sleep(time.Millisecond * 150)
+	idle, _ := invokePooled(t, f, "4")
+	if idle == next {
+		t.Errorf("want idle worker %s recycled after its lifetime", next)
+	}
+}
+
+func TestPooledForkFunctionRunner_ReplacesDeadWorker(t *testing.T) {
+	f := newPooledTestRunner(t, &PooledForkFunctionRunner{})
+
+	pid, _ := invokePooled(t, f, "before")
+
+	req := FunctionRequest{
+		InputReader: ioutil.NopCloser(strings.NewReader("exit")),
+	}
+	if _, err := f.Run(req); err == nil {
+		t.Errorf("want error when the worker dies mid-invocation")
+	}
+
+	next, reply := invokePooled(t, f, "after")
+	if next == pid {
+		t.Errorf("want dead worker %s to be replaced", pid)
+	}
+	if reply != "after" {
+		t.Errorf("want reply %q, got: %q", "after", reply)
+	}
+}
+
+func TestPooledForkFunctionRunner_ReadsReplyOfExitingWorker(t *testing.T) {
+	f := newPooledTestRunner(t, &PooledForkFunctionRunner{MaxRequestsPerWorker: 1})
+
+	// Wait for the worker's exit must not close stdout before its reply is read.
+	for i := 0; i < 5; i++ {
+		if _, reply := invokePooled(t, f, "last"); reply != "last" {
+			t.Fatalf("want reply %q, got: %q", "last", reply)
+		}
+	}
+}
+
+func TestPooledForkFunctionRunner_Warmup(t *testing.T) {
+	f := &PooledForkFunctionRunner{
+		Process:     os.Args[0],
+		ProcessArgs: []string{"-test.run=^TestPooledWorkerProcess$"},
+		Environment: append(os.Environ(), "WANT_POOLED_WORKER=1"),
+		PoolSize:    2,
+	}
+
+	if err := f.Warmup(); err != nil {
+		t.Fatalf("want pool to be filled, got: %s", err)
+	}
+	defer f.Close()
+
+	if len(f.workers) != 2 {
+		t.Errorf("want 2 idle workers after Warmup, got: %d", len(f.workers))
+	}
+
+	invokePooled(t, f, "warm")
+
+	if err := (&PooledForkFunctionRunner{Process: "/does/not/exist"}).Warmup(); err == nil {
+		t.Errorf("want error when a worker cannot be started")
+	}
+}
+
+func TestPooledForkFunctionRunner_IdleTimeout(t *testing.T) {
+	f := newPooledTestRunner(t, &PooledForkFunctionRunner{IdleTimeout: time.Millisecond * 150})
+
+	// Each invocation resets the idle time, so the worker is kept.
+	first, _ := invokePooled(t, f, "1")
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Millisecond * 60)
+		if next, _ := invokePooled(t, f, "busy"); next != first {
+			t.Fatalf("want worker %s kept while busy, got: %s", first, next)
+		}
+	}
+
+	stopped := eventually(func() bool {
+		worker := <-f.workers
+		f.workers <- worker
+		return worker == nil
+	})
+	if !stopped {
+		t.Fatalf("want idle worker %s to be stopped", first)
+	}
+
+	next, reply := invokePooled(t, f, "after")
+	if next == first || reply != "after" {
+		t.Errorf("want a new worker started after %s was stopped, got: %s %q", first, next, reply)
+	}
+}
+
+func TestPooledForkFunctionRunner_HealthCheckReplacesWedgedWorker(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), ".lock")
+	f := newPooledTestRunner(t, &PooledForkFunctionRunner{
+		HealthCheckRequest:  []byte("ping"),
+		HealthCheckInterval: time.Millisecond * 100,
+		LockFilePath:        lockFile,
+	})
+
+	lockFileExists := func() bool {
+		_, err := os.Stat(lockFile)
+		return err == nil
+	}
+
+	if !f.Healthy() || !lockFileExists() {
+		t.Fatalf("want runner to be healthy with a lock file once started")
+	}
+
+	pid, _ := invokePooled(t, f, "hang")
+
+	if !eventually(func() bool { return !f.Healthy() && !lockFileExists() }) {
+		t.Fatalf("want runner to become unhealthy and remove the lock file")
+	}
+	if !eventually(func() bool { return f.Healthy() && lockFileExists() }) {
+		t.Fatalf("want runner to become healthy again once the worker is replaced")
+	}
+
+	if replacement, body := invokePooled(t, f, "hello"); replacement == pid || body != "hello" {
+		t.Errorf("want wedged worker %s replaced, got: %s %q", pid, replacement, body)
+	}
+}
+
+func TestPooledForkFunctionRunner_RunAfterClose(t *testing.T) {
+	f := newPooledTestRunner(t, &PooledForkFunctionRunner{})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Run(FunctionRequest{
+			InputReader:  ioutil.NopCloser(strings.NewReader("sleep 300ms")),
+			OutputWriter: ioutil.Discard,
+		})
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	// Waits for the worker, which Close takes once the first invocation is done.
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := f.Run(FunctionRequest{
+			InputReader:  ioutil.NopCloser(strings.NewReader("second")),
+			OutputWriter: ioutil.Discard,
+		})
+		waiting <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("want no error from Close, got: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("want the running invocation to complete, got: %s", err)
+	}
+
+	select {
+	case err := <-waiting:
+		if !errors.Is(err, ErrRunnerClosed) {
+			t.Errorf("want ErrRunnerClosed for a waiting invocation, got: %v", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("want a waiting invocation to fail once closed")
+	}
+
+	start := time.Now()
+	if _, err := f.Run(FunctionRequest{OutputWriter: ioutil.Discard}); !errors.Is(err, ErrRunnerClosed) {
+		t.Errorf("want ErrRunnerClosed after Close, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want Run to fail at once after Close, took: %s", elapsed)
+	}
+}
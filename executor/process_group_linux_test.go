@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// processAlive reports whether pid is running and has not become a zombie.
+func processAlive(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestForkFunctionRunner_Run_KillProcessGroupReapsChildren(t *testing.T) {
+	f := ForkFunctionRunner{
+		ExecTimeout:      time.Millisecond * 200,
+		KillProcessGroup: true,
+	}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "sleep 30 & echo $!; wait"},
+		OutputWriter: out,
+	}
+
+	start := time.Now()
+	if _, err := f.Run(req); err == nil {
+		t.Fatalf("want error from ExecTimeout")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Errorf("want Run to return after ExecTimeout, took: %s", elapsed)
+	}
+
+	childPid, err := strconv.Atoi(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatalf("want child pid in output, got: %q", out.String())
+	}
+
+	deadline := time.Now().Add(time.Second * 2)
+	for processAlive(childPid) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	if processAlive(childPid) {
+		t.Errorf("want child process %d terminated with its group", childPid)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is not supported on Windows.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// signalProcessGroup falls back to signalling the process alone on Windows.
+func signalProcessGroup(process *os.Process, sig syscall.Signal) error {
+	return process.Signal(sig)
+}
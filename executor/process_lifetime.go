@@ -0,0 +1,110 @@
+package executor
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// acquireUpstream waits until the upstream is ready and counts the request
+// against the current process so that a recycle waits for it, the returned
+// func must be called once the response has been read.
+func (f *HTTPFunctionRunner) acquireUpstream(ctx context.Context) (func(), error) {
+	for {
+		f.wake()
+		if err := f.awaitReady(ctx); err != nil {
+			return nil, err
+		}
+
+		f.stateMutex.Lock()
+		ready, requests := f.ready, f.requests
+		if ready == nil || requests == nil {
+			f.stateMutex.Unlock()
+			return func() {}, nil
+		}
+
+		select {
+		case <-ready:
+			requests.Add(1)
+			f.active++
+			f.lastUsed = time.Now()
+			f.stateMutex.Unlock()
+
+			return func() {
+				requests.Done()
+
+				f.stateMutex.Lock()
+				f.active--
+				f.lastUsed = time.Now()
+				f.stateMutex.Unlock()
+			}, nil
+		default:
+			// Started recycling after awaitReady returned
+			f.stateMutex.Unlock()
+		}
+	}
+}
+
+// recycleAfterLifetime recycles the process each time it has been running
+// for MaxProcessLifetime until stop is closed.
+func (f *HTTPFunctionRunner) recycleAfterLifetime(stop chan struct{}) {
+	for {
+		f.stateMutex.Lock()
+		startedAt := f.startedAt
+		f.stateMutex.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(startedAt.Add(f.MaxProcessLifetime))):
+		}
+
+		f.recycle(startedAt)
+	}
+}
+
+// recycle replaces the process started at startedAt once the requests sent
+// to it have completed, new requests wait for its replacement to be ready.
+// It does nothing if that process has already been replaced.
+func (f *HTTPFunctionRunner) recycle(startedAt time.Time) {
+	ready := make(chan struct{})
+
+	f.stateMutex.Lock()
+	select {
+	case <-f.stop:
+		f.stateMutex.Unlock()
+		return
+	default:
+	}
+	if f.Command == nil || !f.startedAt.Equal(startedAt) {
+		f.stateMutex.Unlock()
+		return
+	}
+	old, requests := f.Command, f.requests
+	f.Command = nil
+	f.healthy = false
+	f.ready = ready
+	f.stateMutex.Unlock()
+
+	log.Printf("Recycling function process after %s", time.Since(startedAt).Round(time.Millisecond))
+
+	if err := removeLockFile(f.LockFilePath); err != nil {
+		log.Printf("Unable to remove lock file: %s", err)
+	}
+
+	requests.Wait()
+
+	if old.Process != nil {
+		old.Process.Kill()
+	}
+
+	if err := f.startProcess(ready); err != nil {
+		log.Printf("Unable to restart function process: %s", err)
+	}
+}
+
+// expired is true once the worker has been running for longer than lifetime,
+// zero means never.
+func (w *pooledWorker) expired(lifetime time.Duration) bool {
+	return lifetime > 0 && time.Since(w.startedAt) >= lifetime
+}
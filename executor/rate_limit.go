@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// waitForToken takes a token when RateLimit is set, waiting for one if
+// WaitForToken is set.
+func (f *ForkFunctionRunner) waitForToken(ctx context.Context) error {
+	if f.RateLimit <= 0 {
+		return nil
+	}
+
+	burst := f.RateBurst
+	if burst <= 0 {
+		burst = 1
+	}
+
+	for {
+		wait := f.limiter.take(f.RateLimit, burst)
+		if wait == 0 {
+			return nil
+		}
+		if !f.WaitForToken {
+			return ErrRateLimited
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// tokenBucket is refilled at a fixed rate, its zero value starts full.
+type tokenBucket struct {
+	mutex   sync.Mutex
+	tokens  float64
+	updated time.Time
+}
+
+// take removes a token and returns zero, or returns how long until one is
+// available without removing it.
+func (b *tokenBucket) take(rate float64, burst int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if b.updated.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.updated).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
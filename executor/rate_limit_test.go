@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestForkFunctionRunner_Run_RateLimitRejectsExcess(t *testing.T) {
+	f := &ForkFunctionRunner{
+		RateLimit: 1,
+		RateBurst: 3,
+	}
+
+	var rejected int
+	for i := 0; i < 5; i++ {
+		_, err := f.Run(FunctionRequest{Process: "true"})
+		if errors.Is(err, ErrRateLimited) {
+			rejected++
+		} else if err != nil {
+			t.Fatalf("want no error, got: %s", err)
+		}
+	}
+
+	if rejected != 2 {
+		t.Errorf("want 2 of 5 invocations rejected over a burst of 3, got: %d", rejected)
+	}
+}
+
+func TestForkFunctionRunner_Run_WaitForToken(t *testing.T) {
+	f := &ForkFunctionRunner{
+		RateLimit:    20,
+		RateBurst:    1,
+		WaitForToken: true,
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := f.Run(FunctionRequest{Process: "true"}); err != nil {
+			t.Fatalf("want no error, got: %s", err)
+		}
+	}
+
+	// The first token is free, the other two are 50ms apart.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("want invocations delayed by the limit, took: %s", elapsed)
+	}
+}
+
+func TestForkFunctionRunner_Run_WaitForTokenCancelled(t *testing.T) {
+	f := &ForkFunctionRunner{
+		RateLimit:    0.1,
+		WaitForToken: true,
+	}
+	if _, err := f.Run(FunctionRequest{Process: "true"}); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := f.Run(FunctionRequest{Process: "true", Context: ctx})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context error while waiting, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want prompt return once cancelled, took: %s", elapsed)
+	}
+}
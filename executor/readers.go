@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"io"
+	"time"
+)
+
+// deadlineReader fails the invocation when a single Read blocks for longer than timeout.
+type deadlineReader struct {
+	*stallTimer
+	reader io.Reader
+}
+
+func newDeadlineReader(reader io.Reader, timeout time.Duration, onTimeout func()) *deadlineReader {
+	return &deadlineReader{
+		stallTimer: newStallTimer(timeout, onTimeout),
+		reader:     reader,
+	}
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	d.begin()
+	n, err := d.reader.Read(p)
+	if d.end() {
+		return n, ErrReadTimeout
+	}
+	return n, err
+}
+
+// limitReader fails once more than max bytes have been read, calling onExceed first.
+type limitReader struct {
+	reader   io.Reader
+	max      int64
+	onExceed func()
+
+	read int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.read += int64(n)
+
+	if l.read > l.max {
+		l.onExceed()
+		return 0, ErrRequestTooLarge
+	}
+	return n, err
+}
+
+// lengthReader fails when the body does not end after exactly expected
+// bytes, calling onMismatch with ErrShortBody or ErrLongBody first.
+type lengthReader struct {
+	reader     io.Reader
+	expected   int64
+	onMismatch func(error)
+
+	read int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.read += int64(n)
+
+	if l.read > l.expected {
+		l.onMismatch(ErrLongBody)
+		return 0, ErrLongBody
+	}
+	if err == io.EOF && l.read < l.expected {
+		l.onMismatch(ErrShortBody)
+		return n, ErrShortBody
+	}
+	return n, err
+}
+
+// decodeReader calls onError when the wrapped decoder fails mid-stream.
+type decodeReader struct {
+	reader  io.Reader
+	onError func(error)
+}
+
+func (d *decodeReader) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	if err != nil && err != io.EOF {
+		d.onError(err)
+	}
+	return n, err
+}
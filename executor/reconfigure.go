@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+)
+
+// RunnerConfig holds the settings which Reconfigure can change while the
+// runner is serving requests, and those NewRunner needs to create one.
+type RunnerConfig struct {
+	ExecTimeout time.Duration
+	MaxInflight int
+	Logger      Logger
+
+	// PoolSize is only accepted by the PooledForkFunctionRunner, and only
+	// when it is zero or the current size, as resizing needs a restart.
+	PoolSize int
+
+	// Process, ProcessArgs and UpstreamURL are used by NewRunner for the
+	// "http" mode, RootPath for the "static" mode, which rejects a field its
+	// mode does not use. Reconfigure ignores them.
+	Process     string
+	ProcessArgs []string
+	UpstreamURL string
+	RootPath    string
+}
+
+// Reconfigure swaps ExecTimeout, MaxInflight and Logger for subsequent
+// invocations, those already running keep the settings they started with.
+// Invocations holding a slot when MaxInflight changes do not count against
+// the new limit. ErrRestartRequired is returned, and nothing is changed,
+// for a PoolSize as every invocation forks its own process.
+func (f *ForkFunctionRunner) Reconfigure(cfg RunnerConfig) error {
+	if cfg.PoolSize != 0 {
+		return fmt.Errorf("%w: PoolSize is not supported by the ForkFunctionRunner", ErrRestartRequired)
+	}
+	if cfg.ExecTimeout < 0 || cfg.MaxInflight < 0 {
+		return fmt.Errorf("invalid config: ExecTimeout and MaxInflight must not be negative")
+	}
+
+	f.configMutex.Lock()
+	defer f.configMutex.Unlock()
+
+	f.ExecTimeout = cfg.ExecTimeout
+	f.MaxInflight = cfg.MaxInflight
+	f.Logger = cfg.Logger
+	return nil
+}
+
+// currentConfig returns the settings Reconfigure can change, for an
+// invocation to keep using until it completes.
+func (f *ForkFunctionRunner) currentConfig() RunnerConfig {
+	f.configMutex.RLock()
+	defer f.configMutex.RUnlock()
+
+	return RunnerConfig{
+		ExecTimeout: f.ExecTimeout,
+		MaxInflight: f.MaxInflight,
+		Logger:      f.Logger,
+	}
+}
+
+// Reconfigure swaps the ExecTimeout for subsequent invocations. The pool
+// cannot be resized without a restart, so ErrRestartRequired is returned
+// for a different PoolSize, and for MaxInflight or a Logger which the pool
+// does not support.
+func (f *PooledForkFunctionRunner) Reconfigure(cfg RunnerConfig) error {
+	size := f.PoolSize
+	if f.workers != nil {
+		size = cap(f.workers)
+	}
+	if cfg.PoolSize != 0 && cfg.PoolSize != size {
+		return fmt.Errorf("%w: PoolSize cannot change from %d to %d", ErrRestartRequired, size, cfg.PoolSize)
+	}
+	if cfg.MaxInflight != 0 || cfg.Logger != nil {
+		return fmt.Errorf("%w: MaxInflight and Logger are not supported by the PooledForkFunctionRunner", ErrRestartRequired)
+	}
+	if cfg.ExecTimeout < 0 {
+		return fmt.Errorf("invalid config: ExecTimeout must not be negative")
+	}
+
+	f.configMutex.Lock()
+	defer f.configMutex.Unlock()
+
+	f.ExecTimeout = cfg.ExecTimeout
+	return nil
+}
+
+// execTimeout returns the ExecTimeout for a new invocation.
+func (f *PooledForkFunctionRunner) execTimeout() time.Duration {
+	f.configMutex.RLock()
+	defer f.configMutex.RUnlock()
+
+	return f.ExecTimeout
+}
@@ -0,0 +1,113 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestForkFunctionRunner_Reconfigure_AppliesToNewInvocations(t *testing.T) {
+	f := &ForkFunctionRunner{ExecTimeout: time.Second * 5}
+	sleep := FunctionRequest{
+		Process:      "sleep",
+		ProcessArgs:  []string{"0.5"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	inflight := make(chan error, 1)
+	go func() {
+		_, err := f.Run(sleep)
+		inflight <- err
+	}()
+	if !eventually(func() bool { return f.Stats().ActiveInvocations == 1 }) {
+		t.Fatalf("want invocation to start")
+	}
+
+	if err := f.Reconfigure(RunnerConfig{ExecTimeout: time.Millisecond * 100}); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if err := <-inflight; err != nil {
+		t.Errorf("want in-flight invocation to keep its ExecTimeout, got: %s", err)
+	}
+
+	if _, err := f.Run(sleep); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want new invocation killed by the new ExecTimeout, got: %v", err)
+	}
+}
+
+func TestForkFunctionRunner_Reconfigure_QueuedKeepsConfig(t *testing.T) {
+	f := &ForkFunctionRunner{ExecTimeout: time.Second * 5, MaxInflight: 1, BlockWhenFull: true}
+	sleep := FunctionRequest{
+		Process:      "sleep",
+		ProcessArgs:  []string{"0.3"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	go f.Run(sleep)
+	if !eventually(func() bool { return f.Stats().ActiveInvocations == 1 }) {
+		t.Fatalf("want invocation to start")
+	}
+
+	queued := make(chan error, 1)
+	go func() {
+		_, err := f.Run(sleep)
+		queued <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	if err := f.Reconfigure(RunnerConfig{ExecTimeout: time.Millisecond * 100, MaxInflight: 1}); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if err := <-queued; err != nil {
+		t.Errorf("want queued invocation to keep the ExecTimeout it was accepted with, got: %s", err)
+	}
+}
+
+func TestForkFunctionRunner_Reconfigure_MaxInflight(t *testing.T) {
+	f := &ForkFunctionRunner{MaxInflight: 1}
+	sleep := FunctionRequest{
+		Process:      "sleep",
+		ProcessArgs:  []string{"0.3"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	go f.Run(sleep)
+	if !eventually(func() bool { return f.Stats().ActiveInvocations == 1 }) {
+		t.Fatalf("want invocation to start")
+	}
+	if _, err := f.Run(sleep); !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("want ErrTooManyRequests at MaxInflight 1, got: %v", err)
+	}
+
+	if err := f.Reconfigure(RunnerConfig{MaxInflight: 2}); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if _, err := f.Run(sleep); err != nil {
+		t.Errorf("want invocation admitted after raising MaxInflight, got: %s", err)
+	}
+}
+
+func TestReconfigure_RejectsImmutableSettings(t *testing.T) {
+	fork := &ForkFunctionRunner{ExecTimeout: time.Second}
+	if err := fork.Reconfigure(RunnerConfig{ExecTimeout: time.Minute, PoolSize: 2}); !errors.Is(err, ErrRestartRequired) {
+		t.Errorf("want ErrRestartRequired for a PoolSize, got: %v", err)
+	}
+	if fork.ExecTimeout != time.Second {
+		t.Errorf("want ExecTimeout unchanged after a rejected Reconfigure, got: %s", fork.ExecTimeout)
+	}
+
+	pool := newPooledTestRunner(t, &PooledForkFunctionRunner{PoolSize: 2})
+	if err := pool.Reconfigure(RunnerConfig{PoolSize: 3}); !errors.Is(err, ErrRestartRequired) {
+		t.Errorf("want ErrRestartRequired when resizing the pool, got: %v", err)
+	}
+	if err := pool.Reconfigure(RunnerConfig{PoolSize: 2, ExecTimeout: time.Second}); err != nil {
+		t.Errorf("want the current PoolSize accepted, got: %s", err)
+	}
+	if pool.ExecTimeout != time.Second {
+		t.Errorf("want ExecTimeout %s, got: %s", time.Second, pool.ExecTimeout)
+	}
+}
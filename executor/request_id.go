@@ -0,0 +1,31 @@
+package executor
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+)
+
+// newRequestID generates a random, UUID-like identifier for an invocation.
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("Unable to generate request ID: %s", err)
+		return ""
+	}
+
+	// Mark as a version 4, variant 1 UUID.
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// logRequest logs a line prefixed with the request ID when one is set.
+func logRequest(requestID string, format string, v ...interface{}) {
+	if len(requestID) == 0 {
+		log.Printf(format, v...)
+		return
+	}
+	log.Printf("[%s] "+format, append([]interface{}{requestID}, v...)...)
+}
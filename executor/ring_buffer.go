@@ -0,0 +1,44 @@
+package executor
+
+import "sync"
+
+// ringBuffer keeps the most recent bytes written to it, up to a fixed size.
+type ringBuffer struct {
+	mutex sync.Mutex
+	data  []byte
+	size  int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{
+		data: make([]byte, 0, size),
+		size: size,
+	}
+}
+
+// Write appends p, discarding the oldest bytes once the buffer is full.
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	n := len(p)
+	if n >= r.size {
+		r.data = append(r.data[:0], p[n-r.size:]...)
+		return n, nil
+	}
+
+	if overflow := len(r.data) + n - r.size; overflow > 0 {
+		r.data = append(r.data[:0], r.data[overflow:]...)
+	}
+
+	r.data = append(r.data, p...)
+	return n, nil
+}
+
+// String returns the retained tail of what was written.
+func (r *ringBuffer) String() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return string(r.data)
+}
@@ -0,0 +1,29 @@
+package executor
+
+import "testing"
+
+func Test_ringBuffer_KeepsTail(t *testing.T) {
+	cases := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{name: "Under size", size: 8, writes: []string{"abc"}, want: "abc"},
+		{name: "Exactly size", size: 3, writes: []string{"abc"}, want: "abc"},
+		{name: "Single write over size", size: 3, writes: []string{"abcdef"}, want: "def"},
+		{name: "Many writes over size", size: 4, writes: []string{"ab", "cd", "ef"}, want: "cdef"},
+		{name: "Boundary plus one", size: 4, writes: []string{"abcd", "e"}, want: "bcde"},
+	}
+
+	for _, testCase := range cases {
+		r := newRingBuffer(testCase.size)
+		for _, w := range testCase.writes {
+			r.Write([]byte(w))
+		}
+
+		if got := r.String(); got != testCase.want {
+			t.Errorf("(%s) want: %q, got: %q", testCase.name, testCase.want, got)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// resourceLimitCommand wraps process in a shell which applies the memory
+// and CPU limits before replacing itself with the process, so the limits are
+// in place before the function runs. Zero means no limit.
+func resourceLimitCommand(process string, args []string, memoryBytes, cpuSeconds uint64) (string, []string, error) {
+	var script []string
+	if memoryBytes > 0 {
+		kilobytes := memoryBytes / 1024
+		if kilobytes == 0 {
+			kilobytes = 1
+		}
+		script = append(script, fmt.Sprintf("ulimit -v %d", kilobytes))
+	}
+	if cpuSeconds > 0 {
+		// The hard limit is a second later, so that the process is sent
+		// SIGXCPU rather than being killed outright.
+		script = append(script, fmt.Sprintf("ulimit -S -t %d", cpuSeconds), fmt.Sprintf("ulimit -H -t %d", cpuSeconds+1))
+	}
+	script = append(script, `exec "$0" "$@"`)
+
+	return "/bin/sh", append([]string{"-c", strings.Join(script, " && "), process}, args...), nil
+}
+
+// resourceLimitExceeded reports whether the process was stopped by its
+// limits. The kernel sends it SIGXCPU once out of CPU time and SIGKILL if it
+// carries on to the hard limit, running out of memory leaves an error on
+// stderr. Other crashes are not put down to the limits.
+func resourceLimitExceeded(state *os.ProcessState, stderr string, cpuSeconds uint64) bool {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		switch status.Signal() {
+		case syscall.SIGXCPU:
+			return true
+		case syscall.SIGKILL:
+			if cpuSeconds > 0 && state.UserTime()+state.SystemTime() >= time.Duration(cpuSeconds)*time.Second {
+				return true
+			}
+		}
+	}
+
+	stderr = strings.ToLower(stderr)
+	for _, message := range []string{"out of memory", "cannot allocate memory", "memory exhausted"} {
+		if strings.Contains(stderr, message) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_ResourceLimits(t *testing.T) {
+	cases := []struct {
+		name    string
+		runner  *ForkFunctionRunner
+		script  string
+		wantErr error
+	}{
+		{
+			name:    "memory",
+			runner:  &ForkFunctionRunner{MemoryLimitBytes: 50 * 1024 * 1024},
+			script:  `awk 'BEGIN { s = "x"; while (1) s = s s }'`,
+			wantErr: ErrResourceLimit,
+		},
+		{
+			name:    "cpu",
+			runner:  &ForkFunctionRunner{CPUTimeLimitSeconds: 1},
+			script:  "while :; do :; done",
+			wantErr: ErrResourceLimit,
+		},
+		{
+			name:    "crash within limits",
+			runner:  &ForkFunctionRunner{MemoryLimitBytes: 50 * 1024 * 1024, CPUTimeLimitSeconds: 1},
+			script:  "kill -SEGV $$",
+			wantErr: ErrKilledBySignal,
+		},
+		{
+			name:    "killed within limits",
+			runner:  &ForkFunctionRunner{MemoryLimitBytes: 50 * 1024 * 1024, CPUTimeLimitSeconds: 1},
+			script:  "kill -KILL $$",
+			wantErr: ErrKilledBySignal,
+		},
+		{
+			name:   "within limits",
+			runner: &ForkFunctionRunner{MemoryLimitBytes: 50 * 1024 * 1024, CPUTimeLimitSeconds: 1},
+			script: "echo ok",
+		},
+	}
+
+	for _, c := range cases {
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", c.script},
+			OutputWriter: out,
+		}
+
+		_, err := c.runner.Run(req)
+		if c.wantErr == nil {
+			if err != nil {
+				t.Errorf("(%s) want no error, got: %s", c.name, err)
+			}
+			if out.String() != "ok\n" {
+				t.Errorf("(%s) want output %q, got: %q", c.name, "ok\n", out.String())
+			}
+			continue
+		}
+
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package executor
+
+import (
+	"errors"
+	"os"
+)
+
+// resourceLimitCommand is not supported on Windows.
+func resourceLimitCommand(process string, args []string, memoryBytes, cpuSeconds uint64) (string, []string, error) {
+	return "", nil, errors.New("resource limits are not supported on Windows")
+}
+
+// resourceLimitExceeded is always false on Windows.
+func resourceLimitExceeded(state *os.ProcessState, stderr string, cpuSeconds uint64) bool {
+	return false
+}
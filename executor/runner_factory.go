@@ -0,0 +1,108 @@
+package executor
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// NewRunner returns the FunctionRunner for mode, one of "streaming",
+// "serializing", "http" or "static", set up from the fields of cfg. Setting
+// a field which the mode does not use is an error:
+//
+//	streaming:   ExecTimeout, MaxInflight and Logger
+//	serializing: ExecTimeout
+//	http:        ExecTimeout, Process, ProcessArgs and UpstreamURL
+//	static:      RootPath
+//
+// The "http" mode needs a Process and an UpstreamURL and is returned without
+// being started, the "static" mode needs a RootPath.
+func NewRunner(mode string, cfg RunnerConfig) (FunctionRunner, error) {
+	if cfg.ExecTimeout < 0 || cfg.MaxInflight < 0 {
+		return nil, fmt.Errorf("invalid config: ExecTimeout and MaxInflight must not be negative")
+	}
+
+	switch mode {
+	case "streaming":
+		if err := checkRunnerFields(mode, cfg, "ExecTimeout", "MaxInflight", "Logger"); err != nil {
+			return nil, err
+		}
+		return &ForkFunctionRunner{
+			ExecTimeout: cfg.ExecTimeout,
+			MaxInflight: cfg.MaxInflight,
+			Logger:      cfg.Logger,
+		}, nil
+
+	case "serializing":
+		if err := checkRunnerFields(mode, cfg, "ExecTimeout"); err != nil {
+			return nil, err
+		}
+		return &SerializingForkFunctionRunner{
+			ExecTimeout: cfg.ExecTimeout,
+		}, nil
+
+	case "http":
+		if err := checkRunnerFields(mode, cfg, "ExecTimeout", "Process", "ProcessArgs", "UpstreamURL"); err != nil {
+			return nil, err
+		}
+		if len(cfg.Process) == 0 {
+			return nil, fmt.Errorf("invalid config: the http mode needs a Process")
+		}
+		if len(cfg.UpstreamURL) == 0 {
+			return nil, fmt.Errorf("invalid config: the http mode needs an UpstreamURL")
+		}
+		upstreamURL, err := url.Parse(cfg.UpstreamURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UpstreamURL: %w", err)
+		}
+		if len(upstreamURL.Scheme) == 0 {
+			return nil, fmt.Errorf("invalid UpstreamURL: %q has no scheme i.e. http://127.0.0.1:8082", cfg.UpstreamURL)
+		}
+		return &HTTPFunctionRunner{
+			ExecTimeout: cfg.ExecTimeout,
+			Process:     cfg.Process,
+			ProcessArgs: cfg.ProcessArgs,
+			UpstreamURL: upstreamURL,
+		}, nil
+
+	case "static":
+		if err := checkRunnerFields(mode, cfg, "RootPath"); err != nil {
+			return nil, err
+		}
+		if len(cfg.RootPath) == 0 {
+			return nil, fmt.Errorf("invalid config: the static mode needs a RootPath")
+		}
+		return &StaticFileRunner{RootPath: cfg.RootPath}, nil
+	}
+
+	return nil, fmt.Errorf("unknown mode %q, want streaming, serializing, http or static", mode)
+}
+
+// checkRunnerFields returns an error naming the fields set in cfg which are
+// not among those used by mode, rather than letting them be ignored.
+func checkRunnerFields(mode string, cfg RunnerConfig, used ...string) error {
+	set := map[string]bool{
+		"ExecTimeout": cfg.ExecTimeout != 0,
+		"MaxInflight": cfg.MaxInflight != 0,
+		"Logger":      cfg.Logger != nil,
+		"PoolSize":    cfg.PoolSize != 0,
+		"Process":     len(cfg.Process) > 0,
+		"ProcessArgs": len(cfg.ProcessArgs) > 0,
+		"UpstreamURL": len(cfg.UpstreamURL) > 0,
+		"RootPath":    len(cfg.RootPath) > 0,
+	}
+	for _, field := range used {
+		delete(set, field)
+	}
+
+	var unused []string
+	for _, field := range []string{"ExecTimeout", "MaxInflight", "Logger", "PoolSize", "Process", "ProcessArgs", "UpstreamURL", "RootPath"} {
+		if set[field] {
+			unused = append(unused, field)
+		}
+	}
+	if len(unused) > 0 {
+		return fmt.Errorf("invalid config: the %s mode does not use %s", mode, strings.Join(unused, ", "))
+	}
+	return nil
+}
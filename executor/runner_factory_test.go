@@ -0,0 +1,61 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRunner(t *testing.T) {
+	runner, err := NewRunner("streaming", RunnerConfig{ExecTimeout: time.Second, MaxInflight: 2})
+	if fork, ok := runner.(*ForkFunctionRunner); err != nil || !ok || fork.ExecTimeout != time.Second || fork.MaxInflight != 2 {
+		t.Errorf("(streaming) want a configured *ForkFunctionRunner, got: %#v, %v", runner, err)
+	}
+
+	runner, err = NewRunner("serializing", RunnerConfig{ExecTimeout: time.Second})
+	if serializing, ok := runner.(*SerializingForkFunctionRunner); err != nil || !ok || serializing.ExecTimeout != time.Second {
+		t.Errorf("(serializing) want a configured *SerializingForkFunctionRunner, got: %#v, %v", runner, err)
+	}
+
+	runner, err = NewRunner("http", RunnerConfig{Process: "node", ProcessArgs: []string{"index.js"}, UpstreamURL: "http://127.0.0.1:8082"})
+	if upstream, ok := runner.(*HTTPFunctionRunner); err != nil || !ok || upstream.Process != "node" || upstream.UpstreamURL.Host != "127.0.0.1:8082" {
+		t.Errorf("(http) want a configured *HTTPFunctionRunner, got: %#v, %v", runner, err)
+	}
+
+	runner, err = NewRunner("static", RunnerConfig{RootPath: "/home/app/public"})
+	if static, ok := runner.(*StaticFileRunner); err != nil || !ok || static.RootPath != "/home/app/public" {
+		t.Errorf("(static) want a configured *StaticFileRunner, got: %#v, %v", runner, err)
+	}
+}
+
+func TestNewRunner_Invalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		mode    string
+		cfg     RunnerConfig
+		wantErr string
+	}{
+		{name: "unknown mode", mode: "afterburn", wantErr: `unknown mode "afterburn"`},
+		{name: "empty mode", mode: "", wantErr: "unknown mode"},
+		{name: "negative ExecTimeout", mode: "streaming", cfg: RunnerConfig{ExecTimeout: -time.Second}, wantErr: "must not be negative"},
+		{name: "http without Process", mode: "http", cfg: RunnerConfig{UpstreamURL: "http://127.0.0.1:8082"}, wantErr: "needs a Process"},
+		{name: "http without UpstreamURL", mode: "http", cfg: RunnerConfig{Process: "node"}, wantErr: "needs an UpstreamURL"},
+		{name: "http with invalid UpstreamURL", mode: "http", cfg: RunnerConfig{Process: "node", UpstreamURL: "127.0.0.1:8082"}, wantErr: "invalid UpstreamURL"},
+		{name: "static without RootPath", mode: "static", wantErr: "needs a RootPath"},
+		{name: "streaming with RootPath", mode: "streaming", cfg: RunnerConfig{RootPath: "/home/app/public"}, wantErr: "the streaming mode does not use RootPath"},
+		{name: "serializing with MaxInflight and Logger", mode: "serializing", cfg: RunnerConfig{MaxInflight: 2, Logger: TextLogger{}}, wantErr: "the serializing mode does not use MaxInflight, Logger"},
+		{name: "http with MaxInflight", mode: "http", cfg: RunnerConfig{Process: "node", UpstreamURL: "http://127.0.0.1:8082", MaxInflight: 2}, wantErr: "the http mode does not use MaxInflight"},
+		{name: "static with ExecTimeout", mode: "static", cfg: RunnerConfig{RootPath: "/home/app/public", ExecTimeout: time.Second}, wantErr: "the static mode does not use ExecTimeout"},
+		{name: "PoolSize", mode: "streaming", cfg: RunnerConfig{PoolSize: 2}, wantErr: "the streaming mode does not use PoolSize"},
+	}
+
+	for _, c := range cases {
+		runner, err := NewRunner(c.mode, c.cfg)
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("(%s) want error containing %q, got: %v", c.name, c.wantErr, err)
+		}
+		if runner != nil {
+			t.Errorf("(%s) want no runner, got: %#v", c.name, runner)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// resourceUsage returns the CPU time and peak resident set size of the
+// exited process, all zero when the platform does not report them.
+func resourceUsage(state *os.ProcessState) (userCPU, systemCPU time.Duration, maxRSSKB int64) {
+	if state == nil {
+		return 0, 0, 0
+	}
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || usage == nil {
+		return 0, 0, 0
+	}
+
+	maxRSSKB = int64(usage.Maxrss)
+	// Darwin reports ru_maxrss in bytes rather than kilobytes.
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		maxRSSKB /= 1024
+	}
+	return time.Duration(usage.Utime.Nano()), time.Duration(usage.Stime.Nano()), maxRSSKB
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_ReportsResourceUsage(t *testing.T) {
+	f := &ForkFunctionRunner{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "i=0; while [ $i -lt 300000 ]; do i=$((i+1)); done"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if result.UserCPU <= 0 {
+		t.Errorf("want user CPU time reported, got: %s", result.UserCPU)
+	}
+	if result.MaxRSSKB <= 0 {
+		t.Errorf("want max RSS reported, got: %d", result.MaxRSSKB)
+	}
+	if total := result.UserCPU + result.SystemCPU; total > result.Duration*2 {
+		t.Errorf("want CPU time %s within the invocation's %s", total, result.Duration)
+	}
+}
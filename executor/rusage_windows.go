@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package executor
+
+import (
+	"os"
+	"time"
+)
+
+// resourceUsage returns the CPU time of the exited process, the peak
+// resident set size is not reported on Windows.
+func resourceUsage(state *os.ProcessState) (userCPU, systemCPU time.Duration, maxRSSKB int64) {
+	if state == nil {
+		return 0, 0, 0
+	}
+	return state.UserTime(), state.SystemTime(), 0
+}
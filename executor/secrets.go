@@ -0,0 +1,48 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// secretEnvironment returns a secret_<name>_path variable for each mount, with
+// "-" in names replaced by "_", sorted by name.
+func secretEnvironment(mounts map[string]string) []string {
+	names := make([]string, 0, len(mounts))
+	for name := range mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	envs := make([]string, 0, len(names))
+	for _, name := range names {
+		envs = append(envs, fmt.Sprintf("secret_%s_path=%s", strings.Replace(name, "-", "_", -1), mounts[name]))
+	}
+	return envs
+}
+
+// checkSecretMounts returns an error unless every mount has a usable name and
+// a file which can be opened for reading.
+func checkSecretMounts(mounts map[string]string) error {
+	for name, path := range mounts {
+		if len(name) == 0 || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("invalid secret name: %q", name)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("secret %s is not readable: %w", name, err)
+		}
+		info, err := file.Stat()
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("secret %s is not readable: %w", name, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("secret %s is not readable: %s is a directory", name, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_SecretMounts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api-key")
+	if err := ioutil.WriteFile(path, []byte("s3cr3t-value"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := ForkFunctionRunner{
+		SecretMounts: map[string]string{"api-key": path},
+	}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "env",
+		OutputWriter: out,
+		Environment:  []string{},
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "secret_api_key_path="+path+"\n") {
+		t.Errorf("want secret path in environment, got: %q", out.String())
+	}
+	if strings.Contains(out.String(), "s3cr3t-value") {
+		t.Errorf("want secret value kept out of the environment, got: %q", out.String())
+	}
+}
+
+func Test_checkSecretMounts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte("value"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name        string
+		mounts      map[string]string
+		wantErr     bool
+		wantMissing bool
+	}{
+		{name: "no mounts", mounts: nil},
+		{name: "readable file", mounts: map[string]string{"token": path}},
+		{name: "missing file", mounts: map[string]string{"token": filepath.Join(dir, "missing")}, wantErr: true, wantMissing: true},
+		{name: "directory", mounts: map[string]string{"token": dir}, wantErr: true},
+		{name: "invalid name", mounts: map[string]string{"a=b": path}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		err := checkSecretMounts(c.mounts)
+		if (err != nil) != c.wantErr {
+			t.Errorf("(%s) want error: %t, got: %v", c.name, c.wantErr, err)
+		}
+		if c.wantMissing && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("(%s) want wrapped not-exist error, got: %v", c.name, err)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_MissingSecretFailsBeforeStart(t *testing.T) {
+	f := ForkFunctionRunner{
+		SecretMounts: map[string]string{"token": filepath.Join(t.TempDir(), "missing")},
+	}
+	req := FunctionRequest{
+		Process:     "sh",
+		ProcessArgs: []string{"-c", "exit 0"},
+	}
+
+	result, err := f.Run(req)
+	if err == nil || !strings.Contains(err.Error(), "secret token is not readable") {
+		t.Errorf("want unreadable secret error, got: %v", err)
+	}
+	if result.ExitCode != -1 {
+		t.Errorf("want process not started, got exit code: %d", result.ExitCode)
+	}
+}
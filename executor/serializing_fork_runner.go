@@ -1,145 +1,104 @@
 package executor
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os/exec"
-	"sync"
+	"strconv"
 	"time"
 )
 
 // SerializingForkFunctionRunner forks a process for each invocation
+// and buffers the whole response in memory before returning it
 type SerializingForkFunctionRunner struct {
 	ExecTimeout time.Duration
-}
-
-// Run run a fork for each invocation
-func (f *SerializingForkFunctionRunner) Run(req FunctionRequest, w http.ResponseWriter) error {
-	functionBytes, err := serializeFunction(req, f)
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(err.Error()))
-		return err
-	}
-
-	w.WriteHeader(200)
 
-	if functionBytes != nil {
-		_, err = w.Write(*functionBytes)
-	} else {
-		log.Println("Empty function response.")
-	}
-
-	return err
+	// MaxResponseBytes aborts the invocation once the buffered response grows beyond it, zero means no limit.
+	MaxResponseBytes int64
 }
 
-func serializeFunction(req FunctionRequest, f *SerializingForkFunctionRunner) (*[]byte, error) {
-	log.Printf("Running %s", req.Process)
-
-	start := time.Now()
-	cmd := exec.Command(req.Process, req.ProcessArgs...)
-	cmd.Env = req.Environment
-
-	var timer *time.Timer
-	if f.ExecTimeout > time.Millisecond*0 {
-
-		timer = time.NewTimer(f.ExecTimeout)
-		go func() {
-			<-timer.C
-
-			log.Printf("Function was killed by ExecTimeout: %s\n", f.ExecTimeout.String())
-			killErr := cmd.Process.Kill()
-			if killErr != nil {
-				log.Println("Error killing function due to ExecTimeout", killErr)
-			}
-		}()
-	}
+// Run calls RunContext with req.Context, or context.Background() when it is not set
+func (f *SerializingForkFunctionRunner) Run(req FunctionRequest) (RunResult, error) {
+	return f.RunContext(requestContext(req), req)
+}
 
-	if timer != nil {
-		defer timer.Stop()
-	}
+// RunContext run a fork for each invocation, the response is only written to
+// req.OutputWriter once the process has exited successfully
+func (f *SerializingForkFunctionRunner) RunContext(ctx context.Context, req FunctionRequest) (RunResult, error) {
+	req.Context = ctx
 
 	var data []byte
 
 	// Read request if present.
-	if req.ContentLength != nil {
+	if req.ContentLength != nil && req.InputReader != nil {
 		defer req.InputReader.Close()
 		limitReader := io.LimitReader(req.InputReader, *req.ContentLength)
 		var err error
 		data, err = ioutil.ReadAll(limitReader)
 
 		if err != nil {
-			return nil, err
+			return RunResult{ExitCode: -1}, err
 		}
-
 	}
 
-	stdout, _ := cmd.StdoutPipe()
-	stdin, _ := cmd.StdinPipe()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	err := cmd.Start()
-	if err != nil {
-		return nil, err
-	}
+	buffer := &limitedBuffer{max: f.MaxResponseBytes, onOverflow: cancel}
 
-	functionRes, errors := pipeToProcess(stdin, stdout, &data)
+	forkReq := req
+	forkReq.Context = ctx
+	forkReq.InputReader = ioutil.NopCloser(bytes.NewReader(data))
+	forkReq.OutputWriter = buffer
 
-	if len(errors) > 0 {
-		return nil, errors[0]
+	fork := ForkFunctionRunner{ExecTimeout: f.ExecTimeout}
+	result, err := fork.Run(forkReq)
+	if buffer.overflowed {
+		return result, fmt.Errorf("%w: exceeded %d bytes", ErrResponseTooLarge, f.MaxResponseBytes)
 	}
 
-	waitErr := cmd.Wait()
-	if waitErr != nil {
-		return nil, err
+	if err != nil {
+		return result, err
 	}
 
-	done := time.Since(start)
-	log.Printf("Took %f secs", done.Seconds())
-
-	return functionRes, nil
-}
-
-func pipeToProcess(stdin io.WriteCloser, stdout io.Reader, data *[]byte) (*[]byte, []error) {
-	var functionResult *[]byte
-	var errors []error
-
-	errChannel := make(chan error)
-
-	go func() {
-		for goErr := range errChannel {
-			errors = append(errors, goErr)
-		}
-		close(errChannel)
-	}()
+	if buffer.Len() == 0 {
+		log.Println("Empty function response.")
+	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(2)
+	if w, ok := req.OutputWriter.(http.ResponseWriter); ok {
+		w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	}
 
-	go func(c chan error) {
-		_, err := stdin.Write(*data)
-		stdin.Close()
+	result.BytesWritten = 0
+	if req.OutputWriter != nil {
+		n, writeErr := req.OutputWriter.Write(buffer.Bytes())
+		result.BytesWritten = int64(n)
+		err = writeErr
+	}
 
-		if err != nil {
-			c <- err
-		}
+	return result, err
+}
 
-		wg.Done()
-	}(errChannel)
+// limitedBuffer is a bytes.Buffer which refuses writes beyond max bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	max        int64
+	overflowed bool
+	onOverflow func()
+}
 
-	go func(c chan error) {
-		var err error
-		result, err := ioutil.ReadAll(stdout)
-		functionResult = &result
-		if err != nil {
-			c <- err
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && int64(b.Len()+len(p)) > b.max {
+		b.overflowed = true
+		if b.onOverflow != nil {
+			b.onOverflow()
 		}
+		return 0, ErrResponseTooLarge
+	}
 
-		wg.Done()
-	}(errChannel)
-
-	wg.Wait()
-
-	return functionResult, errors
+	return b.Buffer.Write(p)
 }
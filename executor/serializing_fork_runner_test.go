@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSerializingForkFunctionRunner_Run_BuffersResponse(t *testing.T) {
+	f := SerializingForkFunctionRunner{}
+
+	body := "hello world"
+	contentLength := int64(len(body))
+	w := httptest.NewRecorder()
+	req := FunctionRequest{
+		Process:       "cat",
+		InputReader:   ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: &contentLength,
+		OutputWriter:  w,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if w.Body.String() != body {
+		t.Errorf("want body: %q, got: %q", body, w.Body.String())
+	}
+
+	if result.BytesWritten != contentLength {
+		t.Errorf("want BytesWritten: %d, got: %d", contentLength, result.BytesWritten)
+	}
+
+	if got := w.Header().Get("Content-Length"); got != "11" {
+		t.Errorf("want Content-Length: 11, got: %q", got)
+	}
+}
+
+func TestSerializingForkFunctionRunner_Run_MaxResponseBytes(t *testing.T) {
+	f := SerializingForkFunctionRunner{MaxResponseBytes: 16}
+
+	w := httptest.NewRecorder()
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "head -c 1048576 /dev/zero"},
+		OutputWriter: w,
+	}
+
+	_, err := f.Run(req)
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("want ErrResponseTooLarge, got: %v", err)
+	}
+
+	if w.Body.Len() != 0 {
+		t.Errorf("want nothing written on overflow, got %d bytes", w.Body.Len())
+	}
+}
@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// defaultMaxShadowBodyBytes bounds the request body buffered for
+// ShadowProcess when MaxRequestBytes is not set.
+const defaultMaxShadowBodyBytes = 4 * 1024 * 1024
+
+// shadowStderrBytes is how much of a failed shadow's stderr is logged.
+const shadowStderrBytes = 1024
+
+// shadowSampled reports whether this invocation is mirrored to ShadowProcess.
+func (f *ForkFunctionRunner) shadowSampled() bool {
+	return len(f.ShadowProcess) > 0 && f.ShadowSampleRate > 0 && rand.Float64() < f.ShadowSampleRate
+}
+
+// shadowReader returns input, keeping a copy of what is read to start the
+// shadow for req with once it ends. A body larger than the copy can hold,
+// or one which is not read to its end, is not mirrored.
+func (f *ForkFunctionRunner) shadowReader(input io.Reader, req FunctionRequest, cfg RunnerConfig) io.Reader {
+	max := f.MaxRequestBytes
+	if max <= 0 {
+		max = defaultMaxShadowBodyBytes
+	}
+
+	return &teeBodyReader{
+		reader: input,
+		max:    max,
+		onEOF: func(body []byte) {
+			f.startShadow(req, body, cfg)
+		},
+		onOverflow: func() {
+			logRequest(req.RequestID, "Not mirroring to shadow process, the request body is over %d bytes", max)
+		},
+	}
+}
+
+// startShadow runs req in the background with body as its stdin and its
+// output discarded, holding a MaxInflight slot and counting as active until
+// it exits.
+func (f *ForkFunctionRunner) startShadow(req FunctionRequest, body []byte, cfg RunnerConfig) {
+	f.shutdownMutex.Lock()
+	if f.shuttingDown {
+		f.shutdownMutex.Unlock()
+		return
+	}
+	if f.shadowContext == nil {
+		f.shadowContext, f.shadowCancel = context.WithCancel(context.Background())
+	}
+	parent := f.shadowContext
+	f.active.Add(1)
+	f.shutdownMutex.Unlock()
+
+	release, ok := f.tryAcquire(cfg.MaxInflight)
+	if !ok {
+		f.active.Done()
+		logRequest(req.RequestID, "Not mirroring to shadow process, MaxInflight invocations are running")
+		return
+	}
+
+	go func() {
+		defer f.active.Done()
+		defer release()
+
+		ctx := parent
+		if timeout := f.execTimeout(req, cfg.ExecTimeout); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		cmd, err := f.newCommand(ctx, req)
+		if err != nil {
+			logRequest(req.RequestID, "Unable to start shadow process: %s", err)
+			return
+		}
+		stderr := newRingBuffer(shadowStderrBytes)
+		cmd.Stdin = bytes.NewReader(body)
+		cmd.Stderr = stderr
+
+		if err := cmd.Run(); err != nil {
+			logRequest(req.RequestID, "Shadow process failed: %s, stderr: %s", err, stderr.String())
+		}
+	}()
+}
+
+// teeBodyReader keeps a copy of up to max bytes read from reader, calling
+// onEOF with it once reader ends, or onOverflow once when there is more.
+type teeBodyReader struct {
+	reader     io.Reader
+	max        int64
+	onEOF      func([]byte)
+	onOverflow func()
+
+	buffer   bytes.Buffer
+	overflow bool
+	once     sync.Once
+}
+
+func (t *teeBodyReader) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+
+	if !t.overflow && n > 0 {
+		if int64(t.buffer.Len()+n) > t.max {
+			t.overflow = true
+			t.buffer = bytes.Buffer{}
+			t.onOverflow()
+		} else {
+			t.buffer.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !t.overflow {
+		t.once.Do(func() {
+			t.onEOF(t.buffer.Bytes())
+		})
+	}
+	return n, err
+}
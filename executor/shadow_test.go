@@ -0,0 +1,197 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runShadowed invokes f n times with cat as the function, checking each
+// response is unaffected, and returns how many times the shadow ran once
+// they have settled.
+func runShadowed(t *testing.T, f *ForkFunctionRunner, dir string, n int) int {
+	for i := 0; i < n; i++ {
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "cat",
+			Environment:  []string{"SHADOW_DIR=" + dir},
+			InputReader:  ioutil.NopCloser(strings.NewReader("body")),
+			OutputWriter: out,
+		}
+
+		result, err := f.Run(req)
+		if err != nil || result.ExitCode != 0 || out.String() != "body" {
+			t.Fatalf("want primary result unaffected by the shadow, got: %d, %q, %v", result.ExitCode, out.String(), err)
+		}
+	}
+
+	count := func() int {
+		matches, _ := filepath.Glob(filepath.Join(dir, "shadow-*"))
+		return len(matches)
+	}
+	last := -1
+	for last != count() {
+		last = count()
+		time.Sleep(time.Millisecond * 200)
+	}
+	return last
+}
+
+func TestForkFunctionRunner_Run_ShadowSampleRate(t *testing.T) {
+	// Each run of the shadow leaves a file holding the body it was given.
+	shadowArgs := []string{"-c", `cat > "$SHADOW_DIR/shadow-$X_Call_Id"`}
+
+	cases := []struct {
+		name     string
+		rate     float64
+		n        int
+		min, max int
+	}{
+		{name: "disabled", rate: 0, n: 20, min: 0, max: 0},
+		{name: "every invocation", rate: 1, n: 20, min: 20, max: 20},
+		{name: "half", rate: 0.5, n: 100, min: 25, max: 75},
+	}
+
+	for _, c := range cases {
+		dir := t.TempDir()
+		f := &ForkFunctionRunner{ShadowProcess: "sh", ShadowArgs: shadowArgs, ShadowSampleRate: c.rate}
+
+		got := runShadowed(t, f, dir, c.n)
+		if got < c.min || got > c.max {
+			t.Errorf("(%s) want the shadow run %d to %d times, got: %d", c.name, c.min, c.max, got)
+		}
+
+		matches, _ := filepath.Glob(filepath.Join(dir, "shadow-*"))
+		for _, match := range matches {
+			if body, _ := ioutil.ReadFile(match); string(body) != "body" {
+				t.Errorf("(%s) want the shadow given a copy of the body, got: %q", c.name, body)
+			}
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_ShadowFailureIgnored(t *testing.T) {
+	cases := []struct {
+		name string
+		f    *ForkFunctionRunner
+	}{
+		{name: "not found", f: &ForkFunctionRunner{ShadowProcess: "/does/not/exist", ShadowSampleRate: 1}},
+		{name: "exits non-zero", f: &ForkFunctionRunner{ShadowProcess: "sh", ShadowArgs: []string{"-c", "echo failed >&2; exit 1"}, ShadowSampleRate: 1}},
+	}
+
+	for _, c := range cases {
+		runShadowed(t, c.f, t.TempDir(), 3)
+	}
+}
+
+func TestForkFunctionRunner_Run_ShadowDoesNotDelayPrimary(t *testing.T) {
+	f := &ForkFunctionRunner{ShadowProcess: "sh", ShadowArgs: []string{"-c", "exec sleep 2"}, ShadowSampleRate: 1}
+
+	start := time.Now()
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "cat",
+		InputReader:  ioutil.NopCloser(strings.NewReader("body")),
+		OutputWriter: out,
+	}
+	if _, err := f.Run(req); err != nil || out.String() != "body" {
+		t.Fatalf("want primary result, got: %q, %v", out.String(), err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want the primary to return before the shadow exits, took: %s", elapsed)
+	}
+}
+
+func TestForkFunctionRunner_Run_ShadowUsesRunnerSettings(t *testing.T) {
+	dir := t.TempDir()
+	f := &ForkFunctionRunner{
+		CommandPrefix:    []string{"env", "FROM_PREFIX=yes"},
+		ShadowProcess:    "sh",
+		ShadowArgs:       []string{"-c", `printf "$FROM_PREFIX" > "$SHADOW_DIR/shadow-$X_Call_Id"`},
+		ShadowSampleRate: 1,
+	}
+
+	if got := runShadowed(t, f, dir, 1); got != 1 {
+		t.Fatalf("want the shadow run once, got: %d", got)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "shadow-*"))
+	if body, _ := ioutil.ReadFile(matches[0]); string(body) != "yes" {
+		t.Errorf("want the shadow started with the CommandPrefix, got: %q", body)
+	}
+}
+
+func TestForkFunctionRunner_Run_ShadowNotGivenUnreadBody(t *testing.T) {
+	dir := t.TempDir()
+	f := &ForkFunctionRunner{
+		ReadTimeout:      time.Millisecond * 100,
+		ShadowProcess:    "sh",
+		ShadowArgs:       []string{"-c", `cat > "$SHADOW_DIR/shadow-$X_Call_Id"`},
+		ShadowSampleRate: 1,
+	}
+
+	stall := make(chan struct{})
+	defer close(stall)
+	req := FunctionRequest{
+		Process:      "cat",
+		Environment:  []string{"SHADOW_DIR=" + dir},
+		InputReader:  ioutil.NopCloser(&stallingReader{data: strings.NewReader("partial"), unblock: stall}),
+		OutputWriter: ioutil.Discard,
+	}
+
+	start := time.Now()
+	if _, err := f.Run(req); !errors.Is(err, ErrReadTimeout) {
+		t.Errorf("want ErrReadTimeout for a stalled body, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("want the invocation bounded by ReadTimeout, took: %s", elapsed)
+	}
+
+	time.Sleep(time.Millisecond * 200)
+	if matches, _ := filepath.Glob(filepath.Join(dir, "shadow-*")); len(matches) != 0 {
+		t.Errorf("want no shadow for a body which was not read to its end, got: %v", matches)
+	}
+}
+
+func TestForkFunctionRunner_Shutdown_StopsShadow(t *testing.T) {
+	f := &ForkFunctionRunner{
+		ShadowProcess:    "sh",
+		ShadowArgs:       []string{"-c", "exec sleep 5"},
+		ShadowSampleRate: 1,
+	}
+
+	if _, err := f.Run(FunctionRequest{Process: "true"}); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	start := time.Now()
+	if err := f.Shutdown(ctx); err != nil {
+		t.Fatalf("want Shutdown to stop the shadow and drain, got: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("want the shadow killed on Shutdown, took: %s", elapsed)
+	}
+}
+
+func TestForkFunctionRunner_Run_ShadowNeedsInflightSlot(t *testing.T) {
+	dir := t.TempDir()
+	f := &ForkFunctionRunner{
+		MaxInflight:      1,
+		ShadowProcess:    "sh",
+		ShadowArgs:       []string{"-c", `cat > "$SHADOW_DIR/shadow-$X_Call_Id"`},
+		ShadowSampleRate: 1,
+	}
+
+	// The function holds the only slot while it reads its body.
+	if got := runShadowed(t, f, dir, 2); got != 0 {
+		t.Errorf("want no shadow without a free MaxInflight slot, got: %d", got)
+	}
+}
@@ -0,0 +1,91 @@
+package executor
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// signalForwarder relays signals received by the watchdog to the processes
+// added to it, its zero value forwards nothing until start is called.
+type signalForwarder struct {
+	once      sync.Once
+	mutex     sync.Mutex
+	processes map[*os.Process]struct{}
+	signals   chan os.Signal
+	stopped   chan struct{}
+}
+
+// start begins forwarding signals, it does nothing after the first call.
+// SIGTERM and SIGINT are never forwarded as they shut the watchdog down,
+// which then stops its processes itself.
+func (s *signalForwarder) start(signals []os.Signal) {
+	s.once.Do(func() {
+		var forwarded []os.Signal
+		for _, sig := range signals {
+			if sig == syscall.SIGTERM || sig == os.Interrupt {
+				logRequest("", "Not forwarding %s, it is used for graceful shutdown", sig)
+				continue
+			}
+			forwarded = append(forwarded, sig)
+		}
+		if len(forwarded) == 0 {
+			return
+		}
+
+		s.mutex.Lock()
+		s.signals = make(chan os.Signal, 1)
+		s.stopped = make(chan struct{})
+		signal.Notify(s.signals, forwarded...)
+		go s.forward(s.signals, s.stopped)
+		s.mutex.Unlock()
+	})
+}
+
+func (s *signalForwarder) forward(signals <-chan os.Signal, stopped <-chan struct{}) {
+	for {
+		select {
+		case sig := <-signals:
+			s.mutex.Lock()
+			for process := range s.processes {
+				if err := process.Signal(sig); err != nil && err != os.ErrProcessDone {
+					logRequest("", "Unable to forward %s to process %d: %s", sig, process.Pid, err)
+				}
+			}
+			s.mutex.Unlock()
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// stop restores the default handling of the forwarded signals.
+func (s *signalForwarder) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.signals == nil {
+		return
+	}
+	signal.Stop(s.signals)
+	close(s.stopped)
+	s.signals = nil
+}
+
+func (s *signalForwarder) add(process *os.Process) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.processes == nil {
+		s.processes = map[*os.Process]struct{}{}
+	}
+	s.processes[process] = struct{}{}
+}
+
+func (s *signalForwarder) remove(process *os.Process) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.processes, process)
+}
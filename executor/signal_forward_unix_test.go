@@ -0,0 +1,137 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// trapScript reports when it is ready and exits once it receives SIGUSR1.
+const trapScript = `trap 'echo received USR1; exit 0' USR1; echo ready; while :; do sleep 0.01; done`
+
+func TestForkFunctionRunner_Run_ForwardSignals(t *testing.T) {
+	f := &ForkFunctionRunner{
+		ExecTimeout:    time.Second * 5,
+		ForwardSignals: []os.Signal{syscall.SIGUSR1, syscall.SIGTERM},
+	}
+	defer f.forwarder.stop()
+
+	outputReader, outputWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Run(FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", trapScript},
+			OutputWriter: outputWriter,
+		})
+		outputWriter.Close()
+		done <- err
+	}()
+
+	lines := bufio.NewScanner(outputReader)
+	if !lines.Scan() || lines.Text() != "ready" {
+		t.Fatalf("want process to report it is ready, got: %q", lines.Text())
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	if !lines.Scan() || lines.Text() != "received USR1" {
+		t.Errorf("want SIGUSR1 forwarded to the process, got: %q", lines.Text())
+	}
+	if err := <-done; err != nil {
+		t.Errorf("want process to exit cleanly from its trap, got: %s", err)
+	}
+}
+
+func TestSignalForwarder_SkipsShutdownSignals(t *testing.T) {
+	f := &signalForwarder{}
+	f.start([]os.Signal{syscall.SIGTERM, os.Interrupt})
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.signals != nil {
+		t.Errorf("want no signal handler installed for SIGTERM or SIGINT alone")
+	}
+}
+
+func TestPooledForkFunctionRunner_ForwardSignals(t *testing.T) {
+	f := &PooledForkFunctionRunner{
+		Process:        "sh",
+		ProcessArgs:    []string{"-c", "trap 'echo received >&2; exit 0' USR1; while :; do sleep 0.01; done"},
+		ForwardSignals: []os.Signal{syscall.SIGUSR1},
+	}
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	worker := <-f.workers
+	f.workers <- worker
+
+	// Give the shell time to install its trap.
+	time.Sleep(100 * time.Millisecond)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-worker.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want SIGUSR1 forwarded to the worker")
+	}
+	if code := worker.cmd.ProcessState.ExitCode(); code != 0 {
+		t.Errorf("want worker to exit from its trap, got exit code: %d", code)
+	}
+}
+
+func TestHTTPFunctionRunner_ForwardSignals(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	marker := filepath.Join(t.TempDir(), "received")
+	f := &HTTPFunctionRunner{
+		ExecTimeout:       time.Second * 5,
+		Process:           "sh",
+		ProcessArgs:       []string{"-c", "trap 'touch " + marker + "' USR1; while :; do sleep 0.01; done"},
+		UpstreamURL:       upstreamURL,
+		ReadinessInterval: time.Millisecond * 10,
+		ForwardSignals:    []os.Signal{syscall.SIGUSR1},
+	}
+	if err := f.Start(); err != nil {
+		t.Fatalf("want no error from Start, got: %s", err)
+	}
+	defer f.Close()
+
+	// Give the shell time to install its trap.
+	time.Sleep(100 * time.Millisecond)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	if !eventually(func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	}) {
+		t.Errorf("want SIGUSR1 forwarded to the process")
+	}
+
+	f.Close()
+	f.forwarder.mutex.Lock()
+	defer f.forwarder.mutex.Unlock()
+	if f.forwarder.signals != nil {
+		t.Errorf("want signal forwarding stopped by Close")
+	}
+}
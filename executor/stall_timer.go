@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"sync"
+	"time"
+)
+
+// stallTimer fires when a single blocking call takes longer than timeout.
+type stallTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	stalled chan struct{}
+	once    sync.Once
+}
+
+func newStallTimer(timeout time.Duration, onStall func()) *stallTimer {
+	s := &stallTimer{
+		timeout: timeout,
+		stalled: make(chan struct{}),
+	}
+
+	s.timer = time.AfterFunc(timeout, func() {
+		s.once.Do(func() {
+			close(s.stalled)
+		})
+		onStall()
+	})
+	s.timer.Stop()
+
+	return s
+}
+
+// begin starts timing a blocking call.
+func (s *stallTimer) begin() {
+	s.timer.Reset(s.timeout)
+}
+
+// end stops timing and reports whether the call stalled.
+func (s *stallTimer) end() bool {
+	s.timer.Stop()
+	return s.Stalled()
+}
+
+// Stalled reports whether any call exceeded the timeout.
+func (s *stallTimer) Stalled() bool {
+	select {
+	case <-s.stalled:
+		return true
+	default:
+		return false
+	}
+}
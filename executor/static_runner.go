@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StaticFileRunner serves files from RootPath instead of forking a process
+type StaticFileRunner struct {
+	RootPath string
+}
+
+// Run calls RunContext with req.Context, or context.Background() when it is not set
+func (f *StaticFileRunner) Run(req FunctionRequest) (RunResult, error) {
+	return f.RunContext(requestContext(req), req)
+}
+
+// RunContext copies the file at req.Path, relative to RootPath, to req.OutputWriter
+func (f *StaticFileRunner) RunContext(ctx context.Context, req FunctionRequest) (RunResult, error) {
+	req.Context = ctx
+
+	start := time.Now()
+	result := RunResult{}
+
+	for _, segment := range strings.FieldsFunc(req.Path, isPathSeparator) {
+		if segment == ".." {
+			return result, fmt.Errorf("%w: %s", ErrPathTraversal, req.Path)
+		}
+	}
+
+	filePath := filepath.Join(f.RootPath, filepath.FromSlash(filepath.Clean("/"+req.Path)))
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, fmt.Errorf("%w: %s", ErrNotFound, req.Path)
+		}
+		return result, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return result, err
+	}
+
+	if info.IsDir() {
+		return result, fmt.Errorf("%w: %s", ErrNotFound, req.Path)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(filePath))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	result.Headers = http.Header{"Content-Type": []string{contentType}}
+
+	if w, ok := req.OutputWriter.(http.ResponseWriter); ok {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	outputWriter := req.OutputWriter
+	if outputWriter == nil {
+		outputWriter = ioutil.Discard
+	}
+
+	result.BytesWritten, err = io.Copy(outputWriter, file)
+	result.Duration = time.Since(start)
+
+	log.Printf("Served %s - %d bytes", req.Path, result.BytesWritten)
+
+	return result, err
+}
+
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeStaticRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "static-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "index.html"), []byte("<h1>hello</h1>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestStaticFileRunner_Run_ServesFile(t *testing.T) {
+	root := makeStaticRoot(t)
+	defer os.RemoveAll(root)
+
+	f := StaticFileRunner{RootPath: root}
+	out := &bytes.Buffer{}
+
+	result, err := f.Run(FunctionRequest{Path: "/index.html", OutputWriter: out})
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if out.String() != "<h1>hello</h1>" {
+		t.Errorf("want file contents, got: %q", out.String())
+	}
+
+	if result.BytesWritten != int64(out.Len()) {
+		t.Errorf("want BytesWritten: %d, got: %d", out.Len(), result.BytesWritten)
+	}
+
+	if got := result.Headers.Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("want Content-Type: text/html; charset=utf-8, got: %q", got)
+	}
+}
+
+func TestStaticFileRunner_Run_RejectsTraversal(t *testing.T) {
+	root := makeStaticRoot(t)
+	defer os.RemoveAll(root)
+
+	f := StaticFileRunner{RootPath: filepath.Join(root, "public")}
+	out := &bytes.Buffer{}
+
+	for _, path := range []string{"/../index.html", "../index.html", "/a/../../index.html", "\\..\\index.html"} {
+		_, err := f.Run(FunctionRequest{Path: path, OutputWriter: out})
+		if !errors.Is(err, ErrPathTraversal) {
+			t.Errorf("(%s) want ErrPathTraversal, got: %v", path, err)
+		}
+	}
+
+	if out.Len() > 0 {
+		t.Errorf("want nothing written, got: %q", out.String())
+	}
+}
+
+func TestStaticFileRunner_Run_MissingFile(t *testing.T) {
+	root := makeStaticRoot(t)
+	defer os.RemoveAll(root)
+
+	f := StaticFileRunner{RootPath: root}
+
+	_, err := f.Run(FunctionRequest{Path: "/missing.html", OutputWriter: &bytes.Buffer{}})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("want ErrNotFound, got: %v", err)
+	}
+}
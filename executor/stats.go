@@ -0,0 +1,59 @@
+package executor
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RunnerStats is a snapshot of the invocations a runner has executed. Calls
+// rejected before the process would start, i.e. by MaxInflight or the
+// circuit breaker, are not counted.
+type RunnerStats struct {
+	ActiveInvocations  int
+	TotalInvocations   uint64
+	TotalFailures      uint64
+	AvgDurationSeconds float64
+}
+
+// invocationStats is updated with atomics so that it costs a few
+// uncontended adds per invocation.
+type invocationStats struct {
+	active        int64
+	total         uint64
+	failures      uint64
+	totalDuration int64
+}
+
+func (s *invocationStats) started() {
+	atomic.AddInt64(&s.active, 1)
+}
+
+func (s *invocationStats) completed(success bool, duration time.Duration) {
+	atomic.AddInt64(&s.totalDuration, int64(duration))
+	if !success {
+		atomic.AddUint64(&s.failures, 1)
+	}
+	// total is incremented last so that a snapshot never averages over
+	// an invocation whose duration has not been added yet.
+	atomic.AddUint64(&s.total, 1)
+	atomic.AddInt64(&s.active, -1)
+}
+
+func (s *invocationStats) snapshot() RunnerStats {
+	stats := RunnerStats{
+		ActiveInvocations: int(atomic.LoadInt64(&s.active)),
+		TotalInvocations:  atomic.LoadUint64(&s.total),
+		TotalFailures:     atomic.LoadUint64(&s.failures),
+	}
+	if stats.TotalInvocations > 0 {
+		total := time.Duration(atomic.LoadInt64(&s.totalDuration))
+		stats.AvgDurationSeconds = total.Seconds() / float64(stats.TotalInvocations)
+	}
+	return stats
+}
+
+// Stats returns the number of invocations running now and the totals since
+// the runner was created.
+func (f *ForkFunctionRunner) Stats() RunnerStats {
+	return f.stats.snapshot()
+}
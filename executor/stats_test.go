@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForkFunctionRunner_Stats(t *testing.T) {
+	f := &ForkFunctionRunner{}
+
+	if stats := f.Stats(); stats != (RunnerStats{}) {
+		t.Errorf("want empty stats before any invocation, got: %+v", stats)
+	}
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		process := "true"
+		if i%3 == 0 {
+			process = "false"
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-release
+			f.Run(FunctionRequest{Process: "sh", ProcessArgs: []string{"-c", "sleep 0.2; " + process}})
+		}()
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Stats().ActiveInvocations != 6 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if active := f.Stats().ActiveInvocations; active != 6 {
+		t.Errorf("want 6 active invocations, got: %d", active)
+	}
+
+	wg.Wait()
+
+	stats := f.Stats()
+	if stats.ActiveInvocations != 0 {
+		t.Errorf("want no active invocations, got: %d", stats.ActiveInvocations)
+	}
+	if stats.TotalInvocations != 6 {
+		t.Errorf("want 6 invocations, got: %d", stats.TotalInvocations)
+	}
+	if stats.TotalFailures != 2 {
+		t.Errorf("want 2 failures, got: %d", stats.TotalFailures)
+	}
+	if stats.AvgDurationSeconds < 0.2 || stats.AvgDurationSeconds > 5 {
+		t.Errorf("want average duration of at least 0.2s, got: %f", stats.AvgDurationSeconds)
+	}
+}
+
+func TestForkFunctionRunner_Stats_SkipsRejectedCalls(t *testing.T) {
+	f := &ForkFunctionRunner{}
+	f.Shutdown(context.Background())
+
+	f.Run(FunctionRequest{Process: "true"})
+
+	if stats := f.Stats(); stats.TotalInvocations != 0 {
+		t.Errorf("want rejected call not counted, got: %+v", stats)
+	}
+}
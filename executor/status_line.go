@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxStatusLineBytes is how much of stdout is held back looking for the end
+// of a status line, any longer first line is body.
+const maxStatusLineBytes = 64
+
+// statusLineWriter strips an "HTTP <code>" line from the start of the
+// output, writing the rest as the body.
+type statusLineWriter struct {
+	body io.Writer
+
+	// writeHeader sends the status, nil when the output is not a
+	// http.ResponseWriter.
+	writeHeader func(int)
+
+	buffer bytes.Buffer
+	done   bool
+	status int
+}
+
+func newStatusLineWriter(body io.Writer, destination io.Writer) *statusLineWriter {
+	s := &statusLineWriter{body: body}
+	if responseWriter, ok := destination.(http.ResponseWriter); ok {
+		s.writeHeader = responseWriter.WriteHeader
+	}
+	return s
+}
+
+func (s *statusLineWriter) Write(p []byte) (int, error) {
+	if s.done {
+		return s.body.Write(p)
+	}
+
+	s.buffer.Write(p)
+
+	end := bytes.IndexByte(s.buffer.Bytes(), '\n')
+	if end < 0 && s.buffer.Len() <= maxStatusLineBytes {
+		return len(p), nil
+	}
+
+	if end >= 0 && end <= maxStatusLineBytes {
+		if status, ok := parseStatusLine(s.buffer.Bytes()[:end]); ok {
+			s.status = status
+			s.buffer.Next(end + 1)
+			if s.writeHeader != nil {
+				s.writeHeader(status)
+			}
+		}
+	}
+	return len(p), s.flush()
+}
+
+// Close writes out anything still buffered once the output has ended, a
+// status line must end with a newline.
+func (s *statusLineWriter) Close() error {
+	if s.done {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *statusLineWriter) flush() error {
+	s.done = true
+	if s.buffer.Len() == 0 {
+		return nil
+	}
+
+	_, err := s.body.Write(s.buffer.Bytes())
+	s.buffer.Reset()
+	return err
+}
+
+// parseStatusLine reads "HTTP <code>", optionally followed by a reason
+// phrase, ok is false for any other line.
+func parseStatusLine(line []byte) (int, bool) {
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r"))
+	if len(fields) < 2 || fields[0] != "HTTP" || len(fields[1]) != 3 {
+		return 0, false
+	}
+
+	code, err := strconv.Atoi(fields[1])
+	if err != nil || code < 100 || code > 599 {
+		return 0, false
+	}
+	return code, true
+}
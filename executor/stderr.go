@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+const (
+	// stderrReadBufferSize is the initial size of each pooled stderr buffer.
+	stderrReadBufferSize = 4096
+
+	// maxStderrLineSize bounds how far the stderr buffer grows for a single
+	// line, a longer line is logged in pieces of this size.
+	maxStderrLineSize = 1024 * 1024
+)
+
+// stderrBuffers holds scanner buffers for logStderr so that each invocation
+// does not allocate a fresh one.
+var stderrBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, stderrReadBufferSize)
+		return &buf
+	},
+}
+
+// openStderr returns a pipe for the process' stderr, or nil if one cannot be opened.
+func openStderr(cmd *exec.Cmd, requestID string) io.Reader {
+	errPipe, err := cmd.StderrPipe()
+	if err != nil {
+		logRequest(requestID, "Unable to read stderr from function: %s", err)
+		return nil
+	}
+	return errPipe
+}
+
+// logStderr logs everything read from errPipe one line at a time and keeps its
+// tail, a panic is logged rather than crashing the watchdog. When out is set
+// each line is written to it instead of the log, and given up on once it
+// fails. When sink is set each line is written to it as well, only its first
+// failure is logged so that a sink which reconnects can carry on.
+func logStderr(errPipe io.Reader, tail io.Writer, out io.Writer, sink io.Writer, requestID string) {
+	defer func() {
+		if r := recover(); r != nil {
+			logRequest(requestID, "Recovered from panic reading stderr: %v", r)
+		}
+	}()
+
+	buf := stderrBuffers.Get().(*[]byte)
+	defer stderrBuffers.Put(buf)
+
+	scanner := bufio.NewScanner(io.TeeReader(errPipe, tail))
+	scanner.Buffer(*buf, maxStderrLineSize)
+	scanner.Split(scanStderrLines)
+
+	logRequest(requestID, "Started logging stderr from function.")
+	sinkFailed := false
+	for scanner.Scan() {
+		// The capacity is capped so that append copies rather than
+		// overwriting what the scanner has buffered after the line.
+		line := scanner.Bytes()
+		if out != nil || sink != nil {
+			line = append(line[:len(line):len(line)], '\n')
+		}
+
+		if sink != nil {
+			if _, err := sink.Write(line); err != nil && !sinkFailed {
+				logRequest(requestID, "Unable to write stderr to log sink: %s", err)
+				sinkFailed = true
+			}
+		}
+
+		if out == nil {
+			logRequest(requestID, "stderr: %s", bytes.TrimSuffix(line, []byte("\n")))
+			continue
+		}
+
+		if _, err := out.Write(line); err != nil {
+			logRequest(requestID, "Unable to write stderr to file: %s", err)
+			out = nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		logRequest(requestID, "Error reading stderr: %s", err)
+	}
+}
+
+// scanStderrLines is bufio.ScanLines, except that a line which fills the
+// buffer is returned as it is rather than failing with bufio.ErrTooLong.
+func scanStderrLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && err == nil && len(data) >= maxStderrLineSize {
+		return len(data), data, nil
+	}
+	return advance, token, err
+}
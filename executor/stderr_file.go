@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile appends to path, renaming it to path.1 and starting a new file
+// before a write would take it past maxBytes. Zero maxBytes never rotates.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func openRotatingFile(path string, maxBytes int64) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxBytes: maxBytes}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write writes p in a single call so that lines from concurrent invocations
+// are not interleaved.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return fmt.Errorf("unable to rotate %s: %w", r.path, err)
+	}
+	return r.open()
+}
+
+// Close flushes and closes the file, later writes fail with os.ErrClosed.
+func (r *rotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	file := r.file
+	r.file = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// stderrOutput returns the file stderr is written to, opening it on first
+// use, or nil when StderrFile is not set.
+func (f *ForkFunctionRunner) stderrOutput() (*rotatingFile, error) {
+	if len(f.StderrFile) == 0 {
+		return nil, nil
+	}
+
+	f.stderrFileMutex.Lock()
+	defer f.stderrFileMutex.Unlock()
+
+	if f.stderrFile == nil {
+		file, err := openRotatingFile(f.StderrFile, f.StderrMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open stderr file: %w", err)
+		}
+		f.stderrFile = file
+	}
+	return f.stderrFile, nil
+}
+
+// closeStderrOutput closes the file opened by stderrOutput, if any.
+func (f *ForkFunctionRunner) closeStderrOutput() error {
+	f.stderrFileMutex.Lock()
+	defer f.stderrFileMutex.Unlock()
+
+	if f.stderrFile == nil {
+		return nil
+	}
+	err := f.stderrFile.Close()
+	f.stderrFile = nil
+	return err
+}
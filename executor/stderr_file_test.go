@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_Run_StderrFile(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	path := filepath.Join(t.TempDir(), "stderr.log")
+	f := &ForkFunctionRunner{StderrFile: path}
+
+	for _, line := range []string{"first", "second"} {
+		req := FunctionRequest{
+			Process:     "sh",
+			ProcessArgs: []string{"-c", "echo " + line + " >&2"},
+		}
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("want no error, got: %s", err)
+		}
+	}
+
+	if err := f.Shutdown(context.Background()); err != nil {
+		t.Fatalf("want no error from Shutdown, got: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("want stderr file, got: %s", err)
+	}
+	if string(data) != "first\nsecond\n" {
+		t.Errorf("want stderr appended to the file, got: %q", string(data))
+	}
+	if strings.Contains(logs.String(), "stderr: first") {
+		t.Errorf("want stderr left out of the logs, got: %q", logs.String())
+	}
+}
+
+func TestForkFunctionRunner_Run_StderrFileUnwritable(t *testing.T) {
+	f := &ForkFunctionRunner{StderrFile: filepath.Join(t.TempDir(), "missing", "stderr.log")}
+
+	_, err := f.Run(FunctionRequest{Process: "true"})
+	if err == nil || !strings.Contains(err.Error(), "unable to open stderr file") {
+		t.Errorf("want error opening stderr file, got: %v", err)
+	}
+}
+
+func Test_rotatingFile_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stderr.log")
+	file, err := openRotatingFile(path, 10)
+	if err != nil {
+		t.Fatalf("want file opened, got: %s", err)
+	}
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddddddddddd\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("want no error writing %q, got: %s", line, err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("want no error from Close, got: %s", err)
+	}
+
+	cases := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "current file holds the oversized line", path: path, want: "dddddddddddd\n"},
+		{name: "rotated file holds the line before it", path: path + ".1", want: "cccc\n"},
+	}
+	for _, c := range cases {
+		data, err := ioutil.ReadFile(c.path)
+		if err != nil {
+			t.Fatalf("(%s) want file, got: %s", c.name, err)
+		}
+		if string(data) != c.want {
+			t.Errorf("(%s) want %q, got: %q", c.name, c.want, string(data))
+		}
+	}
+
+	if _, err := file.Write([]byte("late\n")); err != os.ErrClosed {
+		t.Errorf("want os.ErrClosed after Close, got: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_logStderr_LogsLineByLine(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	tail := newRingBuffer(64)
+	logStderr(strings.NewReader("first\nsecond line\n\nlast"), tail, nil, nil, "call-1")
+
+	want := []string{
+		"[call-1] Started logging stderr from function.",
+		"[call-1] stderr: first",
+		"[call-1] stderr: second line",
+		"[call-1] stderr: ",
+		"[call-1] stderr: last",
+	}
+	got := strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n")
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("want log lines %q, got: %q", want, got)
+	}
+
+	if tail.String() != "first\nsecond line\n\nlast" {
+		t.Errorf("want stderr kept unchanged in tail, got: %q", tail.String())
+	}
+}
+
+func Test_logStderr_OversizedLines(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	long := strings.Repeat("x", 128*1024)
+	huge := strings.Repeat("y", maxStderrLineSize+10)
+	logStderr(strings.NewReader("before\n"+long+"\n"+huge+"\nafter\n"), ioutil.Discard, nil, nil, "")
+
+	cases := []struct {
+		name string
+		line string
+	}{
+		{name: "short line", line: "stderr: before\n"},
+		{name: "line above the default scanner limit", line: "stderr: " + long + "\n"},
+		{name: "line above the maximum is split", line: "stderr: " + strings.Repeat("y", maxStderrLineSize) + "\n"},
+		{name: "remainder of the split line", line: "stderr: yyyyyyyyyy\n"},
+		{name: "line after the oversized lines", line: "stderr: after\n"},
+	}
+
+	for _, c := range cases {
+		if count := strings.Count(logs.String(), "\n"+c.line); count != 1 {
+			t.Errorf("(%s) want line logged exactly once, got: %d", c.name, count)
+		}
+	}
+
+	if strings.Contains(logs.String(), "Error reading stderr") {
+		t.Errorf("want no scanner error, got: %q", logs.String()[:100])
+	}
+}
+
+func Benchmark_logStderr(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	stderr := strings.Repeat("a line written by the function to stderr\n", 16)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logStderr(strings.NewReader(stderr), ioutil.Discard, nil, nil, "")
+	}
+}
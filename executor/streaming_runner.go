@@ -1,16 +1,40 @@
 package executor
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"os"
 	"os/exec"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // FunctionRunner runs a function
 type FunctionRunner interface {
-	Run(f FunctionRequest) error
+	Run(f FunctionRequest) (RunResult, error)
+
+	// RunContext runs the function until it completes or ctx is done, ctx
+	// takes the place of f.Context.
+	RunContext(ctx context.Context, f FunctionRequest) (RunResult, error)
+}
+
+// requestContext returns req.Context, or context.Background() when it is not set.
+func requestContext(req FunctionRequest) context.Context {
+	if req.Context == nil {
+		return context.Background()
+	}
+	return req.Context
 }
 
 // FunctionRequest stores request for function execution
@@ -22,86 +46,1525 @@ type FunctionRequest struct {
 	InputReader   io.ReadCloser
 	OutputWriter  io.Writer
 	ContentLength *int64
+
+	// Context bounds the lifetime of the process when calling Run, defaults to
+	// context.Background(). RunContext replaces it with its ctx.
+	Context context.Context
+
+	// Headers, Method and Path of the HTTP request are passed to the process
+	// as Http_ environment variables i.e. Http_Content_Type.
+	Headers map[string][]string
+	Method  string
+	Path    string
+
+	// QueryString is the raw query of the HTTP request, given to the process
+	// as Http_Query and QUERY_STRING.
+	QueryString string
+
+	// WorkingDir is the directory the process is started in, defaults to the watchdog's own.
+	WorkingDir string
+
+	// RequestID is included in log lines and given to the process as X_Call_Id,
+	// one is generated when empty.
+	RequestID string
+
+	// ExecTimeoutOverride replaces the runner's ExecTimeout for this
+	// invocation when non-zero, up to its MaxExecTimeout.
+	ExecTimeoutOverride time.Duration
+
+	// IdempotencyKey makes a request which arrives while another with the same
+	// key is running wait for it and share its result instead of starting the
+	// process again. The body and output are held in memory to be compared
+	// and shared, a different body is rejected with ErrIdempotencyConflict.
+	IdempotencyKey string
 }
 
+// RunResult describes a completed invocation
+type RunResult struct {
+	Duration     time.Duration
+	ExitCode     int // ExitCode is -1 when the process did not exit normally
+	BytesWritten int64
+
+	// StartLatency is how long the process took to start, including preparing
+	// the request, and ExecDuration how long it then ran until it exited.
+	// Duration also includes copying the rest of the output.
+	StartLatency time.Duration
+	ExecDuration time.Duration
+
+	// QueueWait is how long the invocation waited for a MaxInflight slot
+	// before it could start, it is not included in Duration.
+	QueueWait time.Duration
+
+	// Headers and HTTPStatus are set by the function when ParseResponseHeaders
+	// is enabled on the runner, HTTPStatus also with StatusLineMode. Without a Status header HTTPStatus comes from
+	// the runner's StatusMapping, or is zero if there is none. The
+	// HTTPFunctionRunner sets them from the upstream response.
+	Headers    http.Header
+	HTTPStatus int
+
+	// Trailers are set by the function after a TrailerMarker line at the
+	// end of its output.
+	Trailers http.Header
+
+	// CompressedBytes is the size of the response after CompressResponse,
+	// zero when it was not compressed. BytesWritten is the size before.
+	CompressedBytes int64
+
+	// Signal is the signal which terminated the process, zero when it exited
+	// normally. It is set when the runner killed the process too.
+	Signal syscall.Signal
+
+	// UserCPU and SystemCPU are the CPU time used by the process, and
+	// MaxRSSKB its peak resident set size in kilobytes. They are zero where
+	// the platform does not report them, MaxRSSKB is zero on Windows.
+	UserCPU   time.Duration
+	SystemCPU time.Duration
+	MaxRSSKB  int64
+
+	// PID is the process id of the function, zero when it was not started.
+	PID int
+
+	// UsedFallback is set when the runner's FallbackProcess was run as
+	// req.Process could not be started.
+	UsedFallback bool
+}
+
+// StatusRange maps exit codes From to To inclusive onto an HTTP status
+type StatusRange struct {
+	From   int
+	To     int
+	Status int
+}
+
+// defaultStartRetryBackoff is the delay before the first retry of a failed start.
+const defaultStartRetryBackoff = time.Millisecond * 50
+
+// defaultStderrBufferSize is the amount of stderr retained for error reporting.
+const defaultStderrBufferSize = 4 * 1024
+
+// defaultMaxEnvBytes is half of Linux's usual 2MB ARG_MAX, which the
+// environment shares with the arguments.
+const defaultMaxEnvBytes = 1024 * 1024
+
 // ForkFunctionRunner forks a process for each invocation
 type ForkFunctionRunner struct {
 	ExecTimeout time.Duration
+
+	// TimeoutPerByte extends ExecTimeout by this much for each byte of a known
+	// ContentLength, up to MaxExecTimeout when set. It has no effect without
+	// an ExecTimeout. MaxExecTimeout also caps req.ExecTimeoutOverride.
+	TimeoutPerByte time.Duration
+	MaxExecTimeout time.Duration
+
+	// StderrBufferSize is the number of trailing stderr bytes attached to the
+	// error when the process exits with a non-zero status, defaults to 4KB.
+	StderrBufferSize int
+
+	// MaxEnvBytes is the largest environment the process is started with,
+	// counting each "KEY=value" and its terminator, defaults to 1MB. A larger
+	// one fails with ErrEnvTooLarge instead of an exec error, and a negative
+	// value disables the check.
+	MaxEnvBytes int
+
+	// TerminationGracePeriod is how long to wait after sending KillSignal on
+	// ExecTimeout before sending SIGKILL. When zero SIGKILL is sent immediately.
+	TerminationGracePeriod time.Duration
+
+	// KillSignal is sent first when the process is terminated, defaults to
+	// SIGTERM with a TerminationGracePeriod and SIGKILL without one. Without a
+	// grace period there is no SIGKILL fallback for a process that ignores it.
+	KillSignal syscall.Signal
+
+	// KillProcessGroup starts the process in its own process group and signals
+	// the whole group on ExecTimeout so that child processes are not orphaned.
+	// Not supported on Windows.
+	KillProcessGroup bool
+
+	// MaxInflight limits the number of concurrent invocations, zero means no limit.
+	MaxInflight int
+
+	// BlockWhenFull makes Run wait for a free slot instead of returning
+	// ErrTooManyRequests once MaxInflight is reached. ErrQueueTimeout is
+	// returned if the request's context is done first.
+	BlockWhenFull bool
+
+	// MaxQueueLength bounds how many invocations wait for a MaxInflight slot,
+	// and makes Run wait as with BlockWhenFull. Once that many are waiting
+	// Run returns ErrQueueFull at once. Zero means no bound.
+	MaxQueueLength int
+
+	// RateLimit limits invocations to this many per second, in bursts of up to
+	// RateBurst which defaults to 1. Zero means no limit. Over the limit Run
+	// returns ErrRateLimited, or waits for its turn when WaitForToken is set.
+	RateLimit    float64
+	RateBurst    int
+	WaitForToken bool
+
+	// CloseStdinOnEOF closes the process' stdin once the whole request body
+	// has been copied to it, so that it sees end of input even when the
+	// length was not known in advance. Defaults to true, when false stdin is
+	// left open until the process exits.
+	CloseStdinOnEOF *bool
+
+	// StdinBufferSize collects the request body into writes of up to this
+	// many bytes, which are flushed once it has all been read. This saves a
+	// write to the process for each small read, i.e. of a chunked body, but a
+	// process which replies to each line of input may only see it at the end.
+	// Zero writes each read straight through.
+	StdinBufferSize int
+
+	// ResponseChunkSize is the most stdout read and written to
+	// req.OutputWriter at a time, which is flushed after each write when it
+	// is a http.Flusher. Smaller chunks reach a streaming client sooner, and
+	// larger ones copy faster. Zero uses a 32KB buffer.
+	ResponseChunkSize int
+
+	// ReadTimeout kills the process when reading the request body stalls for
+	// longer than this between reads, zero disables it.
+	ReadTimeout time.Duration
+
+	// WriteTimeout kills the process when writing to req.OutputWriter blocks
+	// for longer than this, zero disables it.
+	WriteTimeout time.Duration
+
+	// BackpressureThreshold pauses reading the request body while a write of
+	// the response has been blocked for longer than this, and resumes once
+	// it completes, zero means never. Functions which keep reading their
+	// input while their output is not consumed then buffer at most what was
+	// read before the pause.
+	BackpressureThreshold time.Duration
+
+	// StdinStallTimeout kills the process when writing the request body to
+	// its stdin blocks for longer than this, as it has stopped reading.
+	// ErrStdinStalled is returned, zero disables it.
+	StdinStallTimeout time.Duration
+
+	// ParseResponseHeaders reads CGI-style "Header: value" lines followed by a
+	// blank line from the start of stdout into RunResult.Headers. When
+	// req.OutputWriter is a http.ResponseWriter the headers are also set on it.
+	ParseResponseHeaders bool
+
+	// StatusLineMode reads an optional "HTTP <code>" first line of stdout,
+	// such as "HTTP 404", into RunResult.HTTPStatus and leaves it out of the
+	// body. Output starting with any other line is all body. It is ignored
+	// with ParseResponseHeaders, where a Status header does the same.
+	StatusLineMode bool
+
+	// SniffContentType sets a Content-Type header detected from the first
+	// 512 bytes of the body when the function's headers do not include one.
+	// The body is held back until that much has been written or the process
+	// exits. Only used with ParseResponseHeaders.
+	SniffContentType bool
+
+	// TimeoutResponseBody is written to req.OutputWriter when the exec
+	// timeout kills a function which has not written any output yet, so the
+	// client is told why its response is empty. With ParseResponseHeaders or
+	// StatusLineMode it follows TimeoutResponseStatus, which defaults to 504.
+	// Output which has already started is left as it is.
+	TimeoutResponseBody   string
+	TimeoutResponseStatus int
+
+	// ContentTypeFile is read after each invocation, when the function has
+	// written it, into the Content-Type of RunResult.Headers and is then
+	// removed. The body has been written by then, so this is only of use to
+	// callers which buffer it. "{request_id}" is replaced by the RequestID,
+	// also given to the function as X_Call_Id, for a path unique to each of
+	// several concurrent invocations.
+	ContentTypeFile string
+
+	// TrailerMarker is a line which a function writes after its body to be
+	// followed by CGI-style trailer lines, they are left out of the body and
+	// put in RunResult.Trailers. When req.OutputWriter is a
+	// http.ResponseWriter they are also sent as HTTP trailers, unless the
+	// response has a Content-Length and so is not chunked.
+	TrailerMarker string
+
+	// MaxResponseHeaderBytes limits how much of stdout is scanned for headers,
+	// defaults to 8KB. Output without a blank line within it is all body.
+	MaxResponseHeaderBytes int
+
+	// MaxResponseBytes limits how much of stdout is written to req.OutputWriter,
+	// zero means no limit. Once exceeded the process is killed and
+	// ErrResponseTooLarge returned, unless TruncateResponse is set in which
+	// case the rest of the output is discarded.
+	MaxResponseBytes int64
+	TruncateResponse bool
+
+	// MaxRequestBytes limits the size of the request body, zero means no limit.
+	// A larger ContentLength is rejected before the process starts, otherwise
+	// the process is killed once the limit is passed. ErrRequestTooLarge is returned.
+	MaxRequestBytes int64
+
+	// Logger records each invocation, defaults to TextLogger.
+	Logger Logger
+
+	// Metrics optionally records the outcome and duration of each invocation.
+	Metrics MetricsRecorder
+
+	// ShadowProcess is also run with ShadowArgs for a ShadowSampleRate
+	// fraction of invocations, from 0 to 1, i.e. to try a new version of a
+	// function on real traffic. It is started in the background once the
+	// function has read the whole request body, with the same settings,
+	// environment and a copy of the body bounded by MaxRequestBytes. It
+	// needs a MaxInflight slot of its own, or is skipped. Its output is
+	// discarded and only a failure is logged, the invocation's own result
+	// does not depend on it. Shutdown kills shadows which are still running.
+	ShadowProcess    string
+	ShadowArgs       []string
+	ShadowSampleRate float64
+
+	// MaxLifetimeInvocations refuses new invocations with ErrLifetimeReached
+	// once the function has been run this many times, zero means never.
+	// Invocations which are rejected or replayed from an idempotent one do
+	// not count. OnLifetimeReached is then called when those in flight have
+	// completed, i.e. for the host to exit and be restarted by its
+	// orchestrator to reclaim leaked memory.
+	MaxLifetimeInvocations uint64
+	OnLifetimeReached      func()
+
+	// BeforeExec is called before each invocation and may change the request,
+	// an error is returned from Run without starting the process. AfterExec is
+	// called with the outcome of every call to Run, including rejected ones.
+	BeforeExec func(req *FunctionRequest) error
+	AfterExec  func(req FunctionRequest, result RunResult, err error)
+
+	// OnStart is called with the pid of each process as soon as it has
+	// started, before it is given the request body or its output is read.
+	OnStart func(pid int)
+
+	// Tracer optionally records a span named after req.Process for each
+	// invocation, continuing any trace given in the traceparent header.
+	Tracer Tracer
+
+	// DecompressRequest decodes a request body sent with Content-Encoding: gzip
+	// before it is given to the process, MaxRequestBytes then applies to the
+	// decompressed size. ErrInvalidRequestEncoding is returned for a corrupt body.
+	DecompressRequest bool
+
+	// RequestTransformer rewrites the request body after it is decompressed
+	// and before MaxRequestBytes applies, nil leaves it unchanged. The
+	// process is not started when Transform returns an error.
+	RequestTransformer RequestTransformer
+
+	// CompressResponse gzips the output when the request's Accept-Encoding
+	// header includes gzip and the output is at least MinCompressBytes.
+	CompressResponse bool
+	MinCompressBytes int
+
+	// ExtraOutputWriters are also given the body written to req.OutputWriter,
+	// before any CompressResponse, i.e. to audit responses. An error from one
+	// is logged and no more is written to it, but the invocation carries on.
+	// They are shared by concurrent invocations so must be safe to use from
+	// multiple goroutines.
+	ExtraOutputWriters []io.Writer
+
+	// MergeStderr writes stderr to req.OutputWriter interleaved with stdout in
+	// the order it is written, instead of to the logs.
+	MergeStderr bool
+
+	// StderrFile appends stderr to this file instead of the logs. When it would
+	// grow past StderrMaxBytes it is renamed with a .1 suffix, replacing any
+	// previous one, and a new file started. Zero means no rotation. The file
+	// is closed by Shutdown.
+	StderrFile     string
+	StderrMaxBytes int64
+
+	// LogSink also receives every line of stderr, for instance a log
+	// collector reached through a ReconnectingWriter. A failed write is
+	// logged once per invocation and does not affect the invocation.
+	LogSink io.Writer
+
+	// SuppressStderr discards stderr without reading it, so it is neither
+	// logged nor attached to the error for a non-zero exit. MergeStderr
+	// takes precedence.
+	SuppressStderr bool
+
+	// ExplodeQueryParams also gives each decoded query parameter to the process
+	// as Http_Param_<name>, see queryParamEnvironment for how clashes are handled.
+	ExplodeQueryParams bool
+
+	// BaseEnvironment gives defaults for every invocation, a variable of the
+	// same name in req.Environment, or the inherited environment when that
+	// is nil, takes precedence.
+	BaseEnvironment []string
+
+	// EnvAllowPrefixes and EnvDenyPrefixes filter the environment passed to
+	// the process by variable name before the request's own variables are
+	// added. A variable must match an allowed prefix, when any are set, and
+	// no denied prefix. Deny takes precedence.
+	EnvAllowPrefixes []string
+	EnvDenyPrefixes  []string
+
+	// EnvRename exposes each variable named by a key under the name it maps
+	// to, i.e. {"FUNCTION_NAME": "FN"}, before EnvAllowPrefixes and
+	// EnvDenyPrefixes apply. Variables which are not set are skipped. The
+	// original is kept too unless EnvRenameDropOriginal is set.
+	EnvRename             map[string]string
+	EnvRenameDropOriginal bool
+
+	// EnvTemplates adds a variable for each name, rendered for every
+	// invocation as a text/template of the request's Method, Path,
+	// QueryString, RequestID and Header "Name", i.e. {{.Header "X-Tenant"}}.
+	// A header which was not sent renders as empty. lower, upper and trim
+	// are the only functions available besides the builtins. The variables
+	// are filtered along with the rest of req.Environment.
+	EnvTemplates map[string]string
+
+	// ForwardSignals are relayed to every running process when the watchdog
+	// receives them, i.e. SIGUSR1 to reopen log files. SIGTERM and SIGINT
+	// are not forwarded as they start a graceful shutdown instead.
+	ForwardSignals []os.Signal
+
+	// SecretMounts maps secret names to the files holding their values. Only
+	// the paths are given to the process, as secret_<name>_path, so the values
+	// never appear in its environment.
+	SecretMounts map[string]string
+
+	// StatusMapping sets RunResult.HTTPStatus from the exit code, an exit code
+	// of -1 means the process was killed. Codes which are not mapped are
+	// matched against StatusRanges, then default to 200 for 0 and 500 otherwise.
+	StatusMapping map[int]int
+	StatusRanges  []StatusRange
+
+	// RunAsUID and RunAsGID run the process as another user and group, which
+	// requires the watchdog to run as root. Not supported on Windows.
+	RunAsUID *uint32
+	RunAsGID *uint32
+
+	// Nice sets the scheduling priority of the process from -20, the highest,
+	// to 19. The process is started with nice(1) so that it runs with it from
+	// the start, lowering it below the watchdog's own requires root and
+	// returns ErrNiceNotPermitted otherwise. Not supported on Windows.
+	Nice int
+
+	// MemoryLimitBytes and CPUTimeLimitSeconds set RLIMIT_AS and RLIMIT_CPU on
+	// the process, zero means no limit. ErrResourceLimit is returned when the
+	// process appears to have been stopped by them. Not supported on Windows.
+	MemoryLimitBytes    uint64
+	CPUTimeLimitSeconds uint64
+
+	// MaxStartRetries is how many more times to try starting the process when
+	// it fails for a transient reason such as EAGAIN. The process is never
+	// started again once it has begun executing.
+	MaxStartRetries int
+
+	// StartRetryBackoff is the delay before the first retry, doubling each time,
+	// defaults to 50ms.
+	StartRetryBackoff time.Duration
+
+	// CommandFactory constructs the command for each invocation, defaults to
+	// exec.Command. It is given the process and arguments after CommandPrefix
+	// and resource limits are applied. Only the Path, Args and SysProcAttr of
+	// the command it returns are used, the runner sets everything else.
+	CommandFactory func(name string, args ...string) *exec.Cmd
+
+	// CommandPrefix is run in place of the process, with the process and its
+	// arguments appended, i.e. to wrap it with strace or time. Logs, metrics
+	// and traces still name req.Process.
+	CommandPrefix []string
+
+	// FallbackProcess is run with FallbackArgs, and the same request, when
+	// req.Process cannot be started, i.e. because it is not installed. A
+	// process which starts and then fails is not replaced by it.
+	FallbackProcess string
+	FallbackArgs    []string
+
+	// WarmupCommand is run once by Warmup before any invocations, i.e. to fill
+	// caches the function relies on. Its output is discarded.
+	WarmupCommand []string
+
+	// FailureThreshold opens the circuit breaker after this many consecutive
+	// failed invocations, zero disables it. While open Run returns
+	// ErrCircuitOpen without starting the process, after OpenDuration a single
+	// probe is let through and closes the breaker again if it succeeds.
+	FailureThreshold int
+	OpenDuration     time.Duration
+
+	// LockFilePath is written by CreateLockFile once the runner is ready and
+	// removed by Shutdown so that readiness probes stop routing traffic.
+	LockFilePath string
+
+	// WriteHealthStatus replaces the contents of LockFilePath after each
+	// invocation with a JSON healthStatus describing its outcome.
+	WriteHealthStatus bool
+
+	// start starts the command, defaults to cmd.Start. Used by tests.
+	start func(cmd *exec.Cmd) error
+
+	// configMutex guards the settings Reconfigure changes, and inflight
+	// which is replaced when MaxInflight changes.
+	configMutex sync.RWMutex
+	inflight    chan struct{}
+	queued      int64
+
+	breaker   circuitBreaker
+	limiter   tokenBucket
+	forwarder signalForwarder
+	stats     invocationStats
+
+	stderrFileMutex sync.Mutex
+	stderrFile      *rotatingFile
+
+	envTemplatesOnce sync.Once
+	envTemplates     parsedEnvTemplates
+
+	idempotentMutex sync.Mutex
+	idempotent      map[string]*idempotentCall
+
+	shutdownMutex sync.Mutex
+	shuttingDown  bool
+	invocations   uint64
+	pending       uint64
+	active        sync.WaitGroup
+
+	// shadowContext is cancelled by Shutdown to stop shadow processes.
+	shadowContext context.Context
+	shadowCancel  context.CancelFunc
 }
 
-// Run run a fork for each invocation
-func (f *ForkFunctionRunner) Run(req FunctionRequest) error {
-	log.Printf("Running %s", req.Process)
-	start := time.Now()
-	cmd := exec.Command(req.Process, req.ProcessArgs...)
-	cmd.Env = req.Environment
+// MetricsRecorder observes completed invocations, see the metrics package for a Prometheus implementation.
+type MetricsRecorder interface {
+	ObserveInvocation(success bool, duration time.Duration)
+}
 
-	var timer *time.Timer
-	if f.ExecTimeout > time.Millisecond*0 {
-		timer = time.NewTimer(f.ExecTimeout)
+// QueueWaitRecorder is optionally implemented by a MetricsRecorder to observe
+// how long each invocation waited for a MaxInflight slot.
+type QueueWaitRecorder interface {
+	ObserveQueueWait(wait time.Duration)
+}
 
-		go func() {
-			<-timer.C
+// Run calls RunContext with req.Context, or context.Background() when it is not set
+func (f *ForkFunctionRunner) Run(req FunctionRequest) (RunResult, error) {
+	return f.RunContext(requestContext(req), req)
+}
+
+// RunContext run a fork for each invocation
+func (f *ForkFunctionRunner) RunContext(ctx context.Context, req FunctionRequest) (result RunResult, err error) {
+	req.Context = ctx
+
+	// The first recovers from AfterExec itself, the second runs before it so
+	// that it sees ErrPanic. Until RunContext returns the outcome is ErrPanic,
+	// so the deferred calls in between which release slots and record the
+	// outcome see a panic as a failure. Every return sets them.
+	defer recoverPanic(&req, &result, &err)
+	if f.AfterExec != nil {
+		defer func() {
+			f.AfterExec(req, result, err)
+		}()
+	}
+	defer recoverPanic(&req, &result, &err)
+	result, err = RunResult{ExitCode: -1}, ErrPanic
 
-			log.Printf("Function was killed by ExecTimeout: %s\n", f.ExecTimeout.String())
-			killErr := cmd.Process.Kill()
-			if killErr != nil {
-				fmt.Println("Error killing function due to ExecTimeout", killErr)
+	f.shutdownMutex.Lock()
+	if f.shuttingDown {
+		f.shutdownMutex.Unlock()
+		return RunResult{ExitCode: -1}, ErrShuttingDown
+	}
+	if f.MaxLifetimeInvocations > 0 && f.invocations+f.pending >= f.MaxLifetimeInvocations {
+		f.shutdownMutex.Unlock()
+		return RunResult{ExitCode: -1}, ErrLifetimeReached
+	}
+	// Only an invocation which goes on to run the function counts against
+	// MaxLifetimeInvocations, until then it holds a place so that concurrent
+	// ones cannot go past the limit.
+	f.pending++
+	f.active.Add(1)
+	f.shutdownMutex.Unlock()
+	ran := false
+	defer func() {
+		f.shutdownMutex.Lock()
+		f.pending--
+		if ran {
+			f.invocations++
+		}
+		last := ran && f.MaxLifetimeInvocations > 0 && f.invocations == f.MaxLifetimeInvocations
+		f.shutdownMutex.Unlock()
+
+		f.active.Done()
+		if last {
+			go f.lifetimeReached()
+		}
+	}()
+
+	// Before the body of an idempotent request is buffered, so that it is
+	// limited like any other.
+	if limitErr := f.waitForToken(ctx); limitErr != nil {
+		return RunResult{ExitCode: -1}, limitErr
+	}
+
+	if len(req.IdempotencyKey) > 0 {
+		call, leader, joinErr := f.joinIdempotent(&req)
+		if joinErr != nil {
+			return RunResult{ExitCode: -1}, joinErr
+		}
+		if !leader {
+			return call.replay(req)
+		}
+		defer func() {
+			f.finishIdempotent(req.IdempotencyKey, call, result, err)
+		}()
+	}
+
+	cfg := f.currentConfig()
+
+	queued := time.Now()
+	release, acquireErr := f.acquire(ctx, cfg.MaxInflight)
+	queueWait := time.Since(queued)
+	if recorder, ok := f.Metrics.(QueueWaitRecorder); ok && cfg.MaxInflight > 0 {
+		recorder.ObserveQueueWait(queueWait)
+	}
+	if acquireErr != nil {
+		return RunResult{ExitCode: -1, QueueWait: queueWait}, acquireErr
+	}
+	defer release()
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = TextLogger{}
+	}
+
+	if len(req.RequestID) == 0 {
+		req.RequestID = newRequestID()
+	}
+
+	if f.BeforeExec != nil {
+		if hookErr := f.BeforeExec(&req); hookErr != nil {
+			return RunResult{ExitCode: -1}, hookErr
+		}
+	}
+
+	if f.FailureThreshold > 0 {
+		if !f.breaker.allow(f.OpenDuration) {
+			return RunResult{ExitCode: -1}, ErrCircuitOpen
+		}
+		defer func() {
+			f.breaker.record(err == nil, f.FailureThreshold)
+		}()
+	}
+
+	if f.Tracer != nil {
+		var span Span
+		ctx, span = f.Tracer.Start(ctx, req.Process, traceParent(req.Headers))
+		defer func() {
+			span.SetAttribute("request_id", req.RequestID)
+			span.SetAttribute("duration_seconds", result.Duration.Seconds())
+			span.SetAttribute("exit_code", result.ExitCode)
+			if err != nil {
+				span.RecordError(err)
 			}
+			span.End()
 		}()
 	}
 
-	if timer != nil {
-		defer timer.Stop()
+	f.stats.started()
+	logger.Started(req)
+	defer func() {
+		logger.Completed(req, result, err)
+		f.stats.completed(err == nil, result.Duration)
+	}()
+
+	ran = true
+	result, err = f.run(ctx, req, cfg, f.shadowSampled())
+	result.QueueWait = queueWait
+
+	if f.Metrics != nil {
+		f.Metrics.ObserveInvocation(err == nil, result.Duration)
+	}
+
+	if f.WriteHealthStatus {
+		if statusErr := f.writeHealthStatus(result, err); statusErr != nil {
+			logRequest(req.RequestID, "Unable to write health status: %s", statusErr)
+		}
+	}
+
+	return result, err
+}
+
+// recoverPanic turns a panic into ErrPanic, logging the value and stack
+// rather than returning them to the caller.
+func recoverPanic(req *FunctionRequest, result *RunResult, err *error) {
+	if r := recover(); r != nil {
+		logRequest(req.RequestID, "Recovered from panic: %v\n%s", r, debug.Stack())
+		*result = RunResult{ExitCode: -1}
+		*err = ErrPanic
+	}
+}
+
+// recoverCopy logs a panic in the goroutine copying an invocation's input or
+// output, i.e. from a writer wrapping the response, and calls stop to end the
+// invocation with ErrPanic.
+func recoverCopy(requestID string, stream string, stop func()) {
+	if r := recover(); r != nil {
+		logRequest(requestID, "Recovered from panic copying %s: %v\n%s", stream, r, debug.Stack())
+		stop()
 	}
+}
 
+// run starts the process for req with the settings in cfg, which RunContext
+// took before waiting for a slot so that Reconfigure cannot change them for
+// an invocation which has already been accepted. With shadow set the
+// request is also mirrored to ShadowProcess.
+func (f *ForkFunctionRunner) run(ctx context.Context, req FunctionRequest, cfg RunnerConfig, shadow bool) (RunResult, error) {
+	result := RunResult{ExitCode: -1}
+	start := time.Now()
+
+	// The body as sent is checked against ContentLength even when it is
+	// decompressed and the length is not passed on.
+	declaredLength := int64(-1)
+	if req.ContentLength != nil {
+		declaredLength = *req.ContentLength
+	}
+
+	// The decompressed body no longer matches the request's Content-Length or
+	// Content-Encoding, so neither is passed on.
+	decompress := f.DecompressRequest && req.InputReader != nil &&
+		strings.EqualFold(http.Header(req.Headers).Get("Content-Encoding"), "gzip")
+	if decompress {
+		req.ContentLength = nil
+		req.Headers = withoutHeader(req.Headers, "Content-Encoding")
+	}
+
+	if f.MaxRequestBytes > 0 && req.ContentLength != nil && *req.ContentLength > f.MaxRequestBytes {
+		return result, fmt.Errorf("%w: Content-Length %d exceeds %d bytes", ErrRequestTooLarge, *req.ContentLength, f.MaxRequestBytes)
+	}
+
+	execTimeout := f.execTimeout(req, cfg.ExecTimeout)
+	if execTimeout > time.Millisecond*0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, execTimeout)
+		defer cancel()
+	}
+
+	// kill ends the invocation early with cause reported as the error.
+	ctx, kill := context.WithCancelCause(ctx)
+	defer kill(nil)
+
+	if err := f.checkRequest(req); err != nil {
+		return result, err
+	}
+
+	if err := f.renderEnvTemplates(&req); err != nil {
+		return result, err
+	}
+
+	var stderrOut io.Writer
+	if stderrFile, err := f.stderrOutput(); err != nil {
+		return result, err
+	} else if stderrFile != nil {
+		stderrOut = stderrFile
+	}
+
+	var input io.Reader
 	if req.InputReader != nil {
 		defer req.InputReader.Close()
-		cmd.Stdin = req.InputReader
+		input = req.InputReader
+
+		if declaredLength >= 0 {
+			input = &lengthReader{
+				reader:   input,
+				expected: declaredLength,
+				onMismatch: func(err error) {
+					kill(err)
+				},
+			}
+		}
+
+		if f.ReadTimeout > 0 {
+			input = newDeadlineReader(input, f.ReadTimeout, func() {
+				kill(ErrReadTimeout)
+			})
+		}
+
+		if decompress {
+			gzipReader, gzipErr := gzip.NewReader(input)
+			if gzipErr != nil {
+				return result, fmt.Errorf("%w: %s", ErrInvalidRequestEncoding, gzipErr)
+			}
+			input = &decodeReader{
+				reader: gzipReader,
+				onError: func(err error) {
+					kill(fmt.Errorf("%w: %s", ErrInvalidRequestEncoding, err))
+				},
+			}
+		}
+
+		if f.RequestTransformer != nil {
+			transformed, transformErr := f.RequestTransformer.Transform(input)
+			if transformErr != nil {
+				return result, fmt.Errorf("unable to transform request body: %w", transformErr)
+			}
+			input = transformed
+		}
+
+		if f.MaxRequestBytes > 0 {
+			input = &limitReader{
+				reader: input,
+				max:    f.MaxRequestBytes,
+				onExceed: func() {
+					kill(ErrRequestTooLarge)
+				},
+			}
+		}
+	}
+
+	outputWriter := req.OutputWriter
+	if outputWriter == nil {
+		outputWriter = ioutil.Discard
+	} else if flusher, ok := outputWriter.(http.Flusher); ok {
+		outputWriter = &flushWriter{writer: outputWriter, flusher: flusher}
+	}
+
+	var compressor *compressWriter
+	if f.CompressResponse && acceptsGzip(req.Headers) {
+		compressor = newCompressWriter(outputWriter, req.OutputWriter, f.MinCompressBytes)
+		outputWriter = compressor
+	}
+	if len(f.ExtraOutputWriters) > 0 {
+		outputWriter = &teeWriter{
+			writer:    outputWriter,
+			extras:    f.ExtraOutputWriters,
+			failed:    make([]bool, len(f.ExtraOutputWriters)),
+			requestID: req.RequestID,
+		}
+	}
+	output := &countingWriter{writer: outputWriter}
+
+	// An os.Pipe is used instead of cmd.StdoutPipe so that the copy can
+	// finish draining the pipe after Wait has returned.
+	stdoutPipe, stdoutPipeWriter, pipeErr := os.Pipe()
+	if pipeErr != nil {
+		return result, pipeErr
+	}
+
+	var bodyWriter io.Writer = output
+	if f.MaxResponseBytes > 0 {
+		bodyWriter = &limitWriter{
+			writer:   output,
+			max:      f.MaxResponseBytes,
+			truncate: f.TruncateResponse,
+			onExceed: func() {
+				if f.TruncateResponse {
+					logRequest(req.RequestID, "Truncating response at MaxResponseBytes: %d", f.MaxResponseBytes)
+					return
+				}
+				kill(ErrResponseTooLarge)
+			},
+		}
+	}
+
+	var trailerWriter *cgiTrailerWriter
+	if len(f.TrailerMarker) > 0 {
+		trailerWriter = newCGITrailerWriter(bodyWriter, req.OutputWriter, f.TrailerMarker, f.MaxResponseHeaderBytes)
+		bodyWriter = trailerWriter
+	}
+
+	var stdoutWriter io.Writer = bodyWriter
+	var headerWriter *cgiHeaderWriter
+	if f.ParseResponseHeaders {
+		headerWriter = newCGIHeaderWriter(bodyWriter, req.OutputWriter, f.MaxResponseHeaderBytes)
+		headerWriter.sniff = f.SniffContentType
+		if compressor != nil && headerWriter.responseWriter != nil {
+			headerWriter.writeHeader = compressor.WriteHeader
+		}
+		stdoutWriter = headerWriter
+	}
+
+	var statusWriter *statusLineWriter
+	if f.StatusLineMode && headerWriter == nil {
+		statusWriter = newStatusLineWriter(bodyWriter, req.OutputWriter)
+		if compressor != nil && statusWriter.writeHeader != nil {
+			statusWriter.writeHeader = compressor.WriteHeader
+		}
+		stdoutWriter = statusWriter
+	}
+
+	var writeStalled <-chan struct{}
+	if f.WriteTimeout > 0 {
+		deadlineOutput := newDeadlineWriter(stdoutWriter, f.WriteTimeout, func() {
+			kill(ErrWriteTimeout)
+
+			// Any child still writing to stdout gets EPIPE instead of blocking.
+			stdoutPipe.Close()
+		})
+		stdoutWriter = deadlineOutput
+		writeStalled = deadlineOutput.stalled
+	}
+
+	// The shadow is given the body as the function read it, so that it is
+	// bounded by the same limits and timeouts, once it has all been read.
+	if shadow {
+		shadowReq := req
+		shadowReq.Process, shadowReq.ProcessArgs = f.ShadowProcess, f.ShadowArgs
+		if input == nil {
+			f.startShadow(shadowReq, nil, cfg)
+		} else {
+			input = f.shadowReader(input, shadowReq, cfg)
+		}
+	}
+
+	var pressure *backpressure
+	if f.BackpressureThreshold > 0 && input != nil {
+		pressure = newBackpressure(f.BackpressureThreshold)
+		defer pressure.stop()
+
+		stdoutWriter = pressure.writer(stdoutWriter)
+		// Outside of ReadTimeout, which only bounds the client's own reads.
+		input = pressure.reader(input)
 	}
 
-	cmd.Stdout = req.OutputWriter
+	stderrDone := make(chan struct{})
 
-	errPipe, _ := cmd.StderrPipe()
+	stderrBufferSize := f.StderrBufferSize
+	if stderrBufferSize <= 0 {
+		stderrBufferSize = defaultStderrBufferSize
+	}
+	stderrTail := newRingBuffer(stderrBufferSize)
+
+	// A file left behind by an earlier invocation must not be mistaken for
+	// this one's.
+	contentTypeFile := f.contentTypeFile(req)
+	if len(contentTypeFile) > 0 {
+		os.Remove(contentTypeFile)
+	}
+
+	cmd, stdin, errPipe, startErr := f.startCommand(ctx, req, input != nil, stdoutPipeWriter)
+	for attempt := 0; startErr != nil && attempt < f.MaxStartRetries && isRetryableStartError(startErr); attempt++ {
+		backoff := f.StartRetryBackoff
+		if backoff <= 0 {
+			backoff = defaultStartRetryBackoff
+		}
+		backoff <<= uint(attempt)
+
+		logRequest(req.RequestID, "Retrying start in %s after: %s", backoff, startErr)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		cmd, stdin, errPipe, startErr = f.startCommand(ctx, req, input != nil, stdoutPipeWriter)
+	}
+
+	var notStarted *startFailure
+	if startErr != nil && len(f.FallbackProcess) > 0 && ctx.Err() == nil && errors.As(startErr, &notStarted) {
+		logRequest(req.RequestID, "Running fallback %s as %s could not be started: %s", f.FallbackProcess, req.Process, startErr)
+
+		fallback := req
+		fallback.Process = f.FallbackProcess
+		fallback.ProcessArgs = f.FallbackArgs
+		cmd, stdin, errPipe, startErr = f.startCommand(ctx, fallback, input != nil, stdoutPipeWriter)
+		result.UsedFallback = true
+	}
+
+	stdoutPipeWriter.Close()
+
+	if startErr != nil {
+		stdoutPipe.Close()
+		result.Duration = time.Since(start)
+		return result, startErr
+	}
+
+	started := time.Now()
+	result.StartLatency = started.Sub(start)
+	result.PID = cmd.Process.Pid
+
+	// Called before the request body is written, so that nothing the
+	// function does in response to it is missed by whatever attaches.
+	if f.OnStart != nil {
+		f.OnStart(result.PID)
+	}
+
+	if len(f.ForwardSignals) > 0 {
+		f.forwarder.start(f.ForwardSignals)
+		f.forwarder.add(cmd.Process)
+		defer f.forwarder.remove(cmd.Process)
+	}
 
 	// Prints stderr to console and is picked up by container logging driver.
+	if errPipe != nil {
+		go func() {
+			defer close(stderrDone)
+			logStderr(errPipe, stderrTail, stderrOut, f.LogSink, req.RequestID)
+		}()
+	} else {
+		close(stderrDone)
+	}
+
+	stdoutDone := make(chan error, 1)
 	go func() {
-		log.Println("Started logging stderr from function.")
-		for {
-			errBuff := make([]byte, 256)
+		// Closing the pipe on a write error stops the process with EPIPE.
+		defer stdoutPipe.Close()
+		if pressure != nil {
+			defer pressure.stop()
+		}
+		defer recoverCopy(req.RequestID, "output", func() {
+			kill(ErrPanic)
+			stdoutDone <- ErrPanic
+		})
+		_, copyErr := copyChunked(stdoutWriter, stdoutPipe, f.ResponseChunkSize)
+		if headerWriter != nil {
+			if closeErr := headerWriter.Close(); copyErr == nil {
+				copyErr = closeErr
+			}
+		}
+		if statusWriter != nil {
+			if closeErr := statusWriter.Close(); copyErr == nil {
+				copyErr = closeErr
+			}
+		}
+		if trailerWriter != nil {
+			if closeErr := trailerWriter.Close(); copyErr == nil {
+				copyErr = closeErr
+			}
+		}
+		if compressor != nil {
+			if closeErr := compressor.Close(); copyErr == nil {
+				copyErr = closeErr
+			}
+		}
+		stdoutDone <- copyErr
+	}()
 
-			n, err := errPipe.Read(errBuff)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Error reading stderr: %s", err)
+	// Copied explicitly rather than through cmd.Stdin, so that Wait does not
+	// block on a Read from a stalled client after the process is killed.
+	if stdin != nil {
+		closeStdin := f.CloseStdinOnEOF == nil || *f.CloseStdinOnEOF
+
+		var stdinWriter io.Writer = stdin
+		if f.StdinStallTimeout > 0 {
+			stdinWriter = newDeadlineWriter(stdin, f.StdinStallTimeout, func() {
+				kill(ErrStdinStalled)
+			})
+		}
+
+		go func() {
+			defer recoverCopy(req.RequestID, "input", func() {
+				kill(ErrPanic)
+				stdin.Close()
+			})
+			if f.StdinBufferSize > 0 {
+				buffered := bufio.NewWriterSize(stdinWriter, f.StdinBufferSize)
+				if _, err := copyBuffered(buffered, input); err == nil {
+					buffered.Flush()
 				}
-				break
 			} else {
-				if n > 0 {
-					log.Printf("stderr: %s", errBuff)
+				copyBuffered(stdinWriter, input)
+			}
+			if closeStdin {
+				stdin.Close()
+			}
+		}()
+	}
+
+	// All reads from the stderr pipe must complete before calling Wait.
+	<-stderrDone
+
+	waitErr := cmd.Wait()
+	result.ExecDuration = time.Since(started)
+
+	// Once the process has been killed, a write to a client which has gone
+	// away may never return, so stop waiting for the copy.
+	var killed <-chan struct{}
+	if waitErr != nil {
+		killed = ctx.Done()
+	}
+
+	var copyErr error
+	outputCopied := false
+	select {
+	case copyErr = <-stdoutDone:
+		outputCopied = true
+	case <-writeStalled:
+		copyErr = ErrWriteTimeout
+	case <-killed:
+		stdoutPipe.Close()
+	}
+
+	result.Duration = time.Since(start)
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	result.Signal = exitSignal(cmd.ProcessState)
+	result.UserCPU, result.SystemCPU, result.MaxRSSKB = resourceUsage(cmd.ProcessState)
+	result.BytesWritten = output.Count()
+	if compressor != nil && outputCopied && compressor.Compressed() {
+		result.CompressedBytes = compressor.writer.Count()
+	}
+	if headerWriter != nil && outputCopied {
+		result.Headers = headerWriter.headers
+		result.HTTPStatus = headerWriter.status
+	}
+	if statusWriter != nil && outputCopied {
+		result.HTTPStatus = statusWriter.status
+	}
+	if trailerWriter != nil && outputCopied && trailerWriter.trailers != nil {
+		result.Trailers = trailerWriter.trailers
+		if trailerWriter.responseWriter != nil {
+			for k, v := range trailerWriter.trailers {
+				trailerWriter.responseWriter.Header()[http.TrailerPrefix+k] = v
+			}
+		}
+	}
+	if len(contentTypeFile) > 0 {
+		if contentType, ok, readErr := readContentTypeFile(contentTypeFile); readErr != nil {
+			logRequest(req.RequestID, "Unable to read content type file: %s", readErr)
+		} else if ok {
+			if result.Headers == nil {
+				result.Headers = http.Header{}
+			}
+			result.Headers.Set("Content-Type", contentType)
+		}
+	}
+	if result.HTTPStatus == 0 && (f.StatusMapping != nil || len(f.StatusRanges) > 0) {
+		result.HTTPStatus = f.statusForExitCode(result.ExitCode)
+	}
+
+	if waitErr == nil && copyErr == ErrWriteTimeout {
+		waitErr = copyErr
+	}
+
+	// The process may exit cleanly after being killed for one of the runner's
+	// own limits, the invocation still failed.
+	if waitErr == nil && ctx.Err() != nil {
+		if cause := context.Cause(ctx); cause != context.DeadlineExceeded && cause != context.Canceled {
+			waitErr = cause
+		}
+	}
+
+	if waitErr != nil {
+		if ctx.Err() != nil {
+			cause := context.Cause(ctx)
+			switch cause {
+			case context.DeadlineExceeded:
+				logRequest(req.RequestID, "Function was killed by ExecTimeout: %s\n", execTimeout.String())
+				// The copy usually ends as soon as the pipe is closed, unless
+				// it is blocked writing to the client.
+				if len(f.TimeoutResponseBody) > 0 && !outputCopied {
+					select {
+					case <-stdoutDone:
+						outputCopied = true
+						result.BytesWritten = output.Count()
+					case <-time.After(timeoutResponseWait):
+					}
 				}
+				outputStarted := result.BytesWritten > 0 || !outputCopied ||
+					(headerWriter != nil && headerWriter.headers != nil) || (statusWriter != nil && statusWriter.status != 0)
+				if len(f.TimeoutResponseBody) > 0 && !outputStarted {
+					f.writeTimeoutResponse(req)
+				}
+				return result, &ExecTimeoutError{Elapsed: result.Duration, BytesWritten: result.BytesWritten}
+			case context.Canceled:
+				logRequest(req.RequestID, "Function was killed as the request was cancelled\n")
+			default:
+				logRequest(req.RequestID, "Function was killed: %s\n", cause)
 			}
+			return result, fmt.Errorf("function killed: %w", cause)
+		}
+
+		tail := stderrTail.String()
+		if (f.MemoryLimitBytes > 0 || f.CPUTimeLimitSeconds > 0) && resourceLimitExceeded(cmd.ProcessState, tail, f.CPUTimeLimitSeconds) {
+			logRequest(req.RequestID, "Function exceeded its resource limits: %s\n", waitErr)
+			return result, fmt.Errorf("%w: %s", ErrResourceLimit, waitErr)
+		}
+
+		if result.Signal != 0 {
+			logRequest(req.RequestID, "Function was killed by %s\n", signalReason(result.Signal))
+			waitErr = fmt.Errorf("%w: %s: %w", ErrKilledBySignal, signalReason(result.Signal), waitErr)
+		}
+
+		if len(tail) > 0 {
+			return result, fmt.Errorf("exit error: %w, stderr: %s", waitErr, tail)
+		}
+		return result, waitErr
+	}
+
+	return result, copyErr
+}
+
+// Warmup runs WarmupCommand so that the watchdog can prepare the function
+// during boot, returning an error if it could not be started or failed.
+func (f *ForkFunctionRunner) Warmup() error {
+	if len(f.WarmupCommand) == 0 {
+		return nil
+	}
+
+	log.Printf("Running warmup command: %s", f.WarmupCommand)
+	req := FunctionRequest{
+		Process:      f.WarmupCommand[0],
+		ProcessArgs:  f.WarmupCommand[1:],
+		OutputWriter: ioutil.Discard,
+	}
+
+	if _, err := f.run(context.Background(), req, f.currentConfig(), false); err != nil {
+		return fmt.Errorf("warmup failed: %w", err)
+	}
+	return nil
+}
+
+// timeoutResponseWait bounds how long the output copy of a timed out
+// invocation is waited for before TimeoutResponseBody is given up on.
+const timeoutResponseWait = time.Millisecond * 100
+
+// writeTimeoutResponse writes TimeoutResponseBody to req.OutputWriter, after
+// TimeoutResponseStatus when a ParseResponseHeaders or StatusLineMode
+// function would have set the status itself.
+func (f *ForkFunctionRunner) writeTimeoutResponse(req FunctionRequest) {
+	if req.OutputWriter == nil {
+		return
+	}
+
+	if responseWriter, ok := req.OutputWriter.(http.ResponseWriter); ok && (f.ParseResponseHeaders || f.StatusLineMode) {
+		status := f.TimeoutResponseStatus
+		if status == 0 {
+			status = http.StatusGatewayTimeout
+		}
+		responseWriter.WriteHeader(status)
+	}
+
+	if _, err := io.WriteString(req.OutputWriter, f.TimeoutResponseBody); err != nil {
+		logRequest(req.RequestID, "Unable to write timeout response: %s", err)
+	}
+}
+
+// execTimeout is the request's ExecTimeoutOverride, or timeout scaled by
+// TimeoutPerByte for its ContentLength, capped at MaxExecTimeout.
+func (f *ForkFunctionRunner) execTimeout(req FunctionRequest, timeout time.Duration) time.Duration {
+	if req.ExecTimeoutOverride > 0 {
+		if f.MaxExecTimeout > 0 && req.ExecTimeoutOverride > f.MaxExecTimeout {
+			return f.MaxExecTimeout
+		}
+		return req.ExecTimeoutOverride
+	}
+
+	if timeout <= 0 || f.TimeoutPerByte <= 0 || req.ContentLength == nil || *req.ContentLength <= 0 {
+		return timeout
+	}
+
+	const maxDuration = time.Duration(1<<63 - 1)
+	if *req.ContentLength > int64((maxDuration-timeout)/f.TimeoutPerByte) {
+		timeout = maxDuration
+	} else {
+		timeout += time.Duration(*req.ContentLength) * f.TimeoutPerByte
+	}
+
+	if f.MaxExecTimeout > 0 && timeout > f.MaxExecTimeout {
+		return f.MaxExecTimeout
+	}
+	return timeout
+}
+
+// newCommand builds the command for req, to be stopped by terminate once ctx is done.
+func (f *ForkFunctionRunner) newCommand(ctx context.Context, req FunctionRequest) (*exec.Cmd, error) {
+	process, args := req.Process, req.ProcessArgs
+	if len(f.CommandPrefix) > 0 {
+		args = append(append(append([]string{}, f.CommandPrefix[1:]...), process), args...)
+		process = f.CommandPrefix[0]
+	}
+	if f.MemoryLimitBytes > 0 || f.CPUTimeLimitSeconds > 0 {
+		var err error
+		if process, args, err = resourceLimitCommand(process, args, f.MemoryLimitBytes, f.CPUTimeLimitSeconds); err != nil {
+			return nil, err
+		}
+	}
+	if f.Nice != 0 {
+		var err error
+		if process, args, err = niceCommand(process, args, f.Nice); err != nil {
+			return nil, err
 		}
+	}
+
+	cmd := exec.CommandContext(ctx, process, args...)
+	if f.CommandFactory != nil {
+		// Only CommandContext can tie the process to ctx, so the factory's
+		// command is adopted by the one it returns.
+		custom := f.CommandFactory(process, args...)
+		cmd.Path, cmd.Args, cmd.Err = custom.Path, custom.Args, custom.Err
+		if custom.SysProcAttr != nil {
+			cmd.SysProcAttr = custom.SysProcAttr
+		}
+	}
+	cmd.Env = f.buildEnvironment(req)
+	if err := f.checkEnvSize(cmd.Env); err != nil {
+		return nil, err
+	}
+	cmd.Dir = req.WorkingDir
+
+	if err := setCredential(cmd, f.RunAsUID, f.RunAsGID); err != nil {
+		return nil, err
+	}
+
+	if f.KillProcessGroup {
+		setProcessGroup(cmd)
+	}
+
+	cmd.Cancel = func() error {
+		return f.terminate(cmd)
+	}
+
+	if f.TerminationGracePeriod > 0 {
+		cmd.WaitDelay = f.TerminationGracePeriod
+	}
+	return cmd, nil
+}
+
+// checkEnvSize returns ErrEnvTooLarge when envs, or the watchdog's own
+// environment that a nil envs inherits, is over MaxEnvBytes.
+func (f *ForkFunctionRunner) checkEnvSize(envs []string) error {
+	maxBytes := f.MaxEnvBytes
+	if maxBytes < 0 {
+		return nil
+	}
+	if maxBytes == 0 {
+		maxBytes = defaultMaxEnvBytes
+	}
+
+	if envs == nil {
+		envs = os.Environ()
+	}
+	size := 0
+	for _, env := range envs {
+		size += len(env) + 1
+	}
+	if size > maxBytes {
+		return fmt.Errorf("%w: %d variables take %d bytes, over the limit of %d", ErrEnvTooLarge, len(envs), size, maxBytes)
+	}
+	return nil
+}
+
+// startCommand starts a new command for req writing to stdout, returning its
+// stdin when withStdin is set and its stderr unless MergeStderr or
+// SuppressStderr is set.
+func (f *ForkFunctionRunner) startCommand(ctx context.Context, req FunctionRequest, withStdin bool, stdout *os.File) (*exec.Cmd, io.WriteCloser, io.Reader, error) {
+	cmd, err := f.newCommand(ctx, req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var stdin io.WriteCloser
+	if withStdin {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		// A nil Stdin is connected to the null device, so a function with
+		// no input reads EOF at once instead of the watchdog's own stdin.
+		cmd.Stdin = nil
+	}
+
+	cmd.Stdout = stdout
+
+	var errPipe io.Reader
+	switch {
+	case f.MergeStderr:
+		cmd.Stderr = stdout
+	case f.SuppressStderr:
+		// A nil Stderr is connected to the null device, so unlike a
+		// non-file writer no goroutine is needed to copy it.
+		cmd.Stderr = nil
+	default:
+		errPipe = openStderr(cmd, req.RequestID)
+	}
+
+	start := f.start
+	if start == nil {
+		start = (*exec.Cmd).Start
+	}
+	if err := start(cmd); err != nil {
+		if (f.RunAsUID != nil || f.RunAsGID != nil) && errors.Is(err, syscall.EPERM) {
+			err = fmt.Errorf("%w: %s", ErrRunAsNotPermitted, err)
+		}
+		return nil, nil, nil, &startFailure{err: err}
+	}
+	return cmd, stdin, errPipe, nil
+}
+
+// startFailure is an error from starting the process, i.e. because it could
+// not be found, as opposed to one from preparing or configuring it.
+type startFailure struct {
+	err error
+}
+
+func (e *startFailure) Error() string {
+	return e.err.Error()
+}
+
+func (e *startFailure) Unwrap() error {
+	return e.err
+}
+
+// isRetryableStartError is true for errors from a fork which may succeed
+// when tried again, such as when the process table is full.
+func isRetryableStartError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOMEM)
+}
+
+// statusForExitCode looks up the HTTP status for a process exit code.
+func (f *ForkFunctionRunner) statusForExitCode(exitCode int) int {
+	if status, ok := f.StatusMapping[exitCode]; ok {
+		return status
+	}
+
+	for _, r := range f.StatusRanges {
+		if exitCode >= r.From && exitCode <= r.To {
+			return r.Status
+		}
+	}
+
+	if exitCode == 0 {
+		return http.StatusOK
+	}
+	return http.StatusInternalServerError
+}
+
+// Shutdown stops new invocations from starting and waits for those in flight
+// to complete, returning ctx.Err() if ctx is done first.
+func (f *ForkFunctionRunner) Shutdown(ctx context.Context) error {
+	f.shutdownMutex.Lock()
+	f.shuttingDown = true
+	if f.shadowCancel != nil {
+		f.shadowCancel()
+	}
+	f.shutdownMutex.Unlock()
+
+	if err := f.RemoveLockFile(); err != nil {
+		log.Printf("Unable to remove lock file: %s", err)
+	}
+	f.forwarder.stop()
+
+	defer func() {
+		if err := f.closeStderrOutput(); err != nil {
+			log.Printf("Unable to close stderr file: %s", err)
+		}
+	}()
+
+	drained := make(chan struct{})
+	go func() {
+		f.active.Wait()
+		close(drained)
 	}()
 
-	startErr := cmd.Start()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	if startErr != nil {
-		return startErr
+// Ready is true until Shutdown is called or MaxLifetimeInvocations have
+// been run, counting those which may still run.
+func (f *ForkFunctionRunner) Ready() bool {
+	f.shutdownMutex.Lock()
+	defer f.shutdownMutex.Unlock()
+	return !f.shuttingDown && (f.MaxLifetimeInvocations == 0 || f.invocations+f.pending < f.MaxLifetimeInvocations)
+}
+
+// lifetimeReached calls OnLifetimeReached once every invocation in flight
+// has completed.
+func (f *ForkFunctionRunner) lifetimeReached() {
+	f.active.Wait()
+	if f.OnLifetimeReached != nil {
+		f.OnLifetimeReached()
 	}
+}
 
-	waitErr := cmd.Wait()
-	done := time.Since(start)
-	log.Printf("Took %f secs", done.Seconds())
-	if timer != nil {
-		timer.Stop()
+// inflightSlots returns the channel holding maxInflight slots, replacing it
+// when the limit has changed. A slot is released back to the channel it was
+// taken from, even once Reconfigure has replaced it.
+func (f *ForkFunctionRunner) inflightSlots(maxInflight int) chan struct{} {
+	f.configMutex.Lock()
+	defer f.configMutex.Unlock()
+
+	if cap(f.inflight) != maxInflight {
+		f.inflight = make(chan struct{}, maxInflight)
 	}
+	return f.inflight
+}
 
-	req.InputReader.Close()
+// tryAcquire takes one of maxInflight slots when it is set and one is free,
+// without waiting or counting against MaxQueueLength.
+func (f *ForkFunctionRunner) tryAcquire(maxInflight int) (func(), bool) {
+	if maxInflight <= 0 {
+		return func() {}, true
+	}
 
-	if waitErr != nil {
-		return waitErr
+	inflight := f.inflightSlots(maxInflight)
+	select {
+	case inflight <- struct{}{}:
+		return func() { <-inflight }, true
+	default:
+		return nil, false
+	}
+}
+
+// acquire takes one of maxInflight slots when it is set, the returned func
+// releases it.
+func (f *ForkFunctionRunner) acquire(ctx context.Context, maxInflight int) (func(), error) {
+	if maxInflight <= 0 {
+		return func() {}, nil
+	}
+
+	inflight := f.inflightSlots(maxInflight)
+	release := func() {
+		<-inflight
+	}
+
+	select {
+	case inflight <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if !f.BlockWhenFull && f.MaxQueueLength <= 0 {
+		return nil, ErrTooManyRequests
+	}
+
+	if queued := atomic.AddInt64(&f.queued, 1); f.MaxQueueLength > 0 && queued > int64(f.MaxQueueLength) {
+		atomic.AddInt64(&f.queued, -1)
+		return nil, ErrQueueFull
+	}
+	defer atomic.AddInt64(&f.queued, -1)
+
+	select {
+	case inflight <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrQueueTimeout, ctx.Err())
+	}
+}
+
+// terminate stops the process once its context is done, sending KillSignal
+// first and following up with SIGKILL after any TerminationGracePeriod.
+func (f *ForkFunctionRunner) terminate(cmd *exec.Cmd) error {
+	sig := f.KillSignal
+	if sig == 0 {
+		sig = syscall.SIGTERM
+		if f.TerminationGracePeriod <= 0 {
+			sig = syscall.SIGKILL
+		}
+	}
+
+	if err := f.signal(cmd, sig); err != nil {
+		return err
+	}
+
+	if sig == syscall.SIGKILL || f.TerminationGracePeriod <= 0 {
+		return nil
+	}
+
+	// exec.Cmd only kills the leader once WaitDelay expires, so the rest of
+	// the group needs its own SIGKILL.
+	if f.KillProcessGroup {
+		time.AfterFunc(f.TerminationGracePeriod, func() {
+			f.signal(cmd, syscall.SIGKILL)
+		})
 	}
 
 	return nil
 }
+
+func (f *ForkFunctionRunner) signal(cmd *exec.Cmd, sig syscall.Signal) error {
+	if f.KillProcessGroup {
+		return signalProcessGroup(cmd.Process, sig)
+	}
+	return cmd.Process.Signal(sig)
+}
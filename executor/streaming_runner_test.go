@@ -0,0 +1,2329 @@
+package executor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type closeCountingReader struct {
+	io.Reader
+	closed int
+}
+
+func (r *closeCountingReader) Close() error {
+	r.closed++
+	return nil
+}
+
+func TestForkFunctionRunner_Run_NilInputReader(t *testing.T) {
+	f := ForkFunctionRunner{}
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Run panicked with nil InputReader: %v", r)
+		}
+	}()
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "echo",
+		ProcessArgs:  []string{"hello"},
+		InputReader:  nil,
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if out.String() != "hello\n" {
+		t.Errorf("want output: %q, got: %q", "hello\n", out.String())
+	}
+}
+
+func TestForkFunctionRunner_Run_ClosesInputReaderOnce(t *testing.T) {
+	f := ForkFunctionRunner{}
+
+	input := &closeCountingReader{Reader: strings.NewReader("hello")}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "cat",
+		InputReader:  input,
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if input.closed != 1 {
+		t.Errorf("want InputReader closed once, got: %d", input.closed)
+	}
+
+	if out.String() != "hello" {
+		t.Errorf("want output: %q, got: %q", "hello", out.String())
+	}
+}
+
+func TestForkFunctionRunner_Run_StderrLoggedWithoutPadding(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	f := ForkFunctionRunner{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "printf 'short line' >&2"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if strings.Contains(logs.String(), "\x00") {
+		t.Errorf("want no NUL padding in logs, got: %q", logs.String())
+	}
+
+	if !strings.Contains(logs.String(), "] stderr: short line\n") {
+		t.Errorf("want stderr line in logs, got: %q", logs.String())
+	}
+}
+
+func TestForkFunctionRunner_Run_MergeStderr(t *testing.T) {
+	cases := []struct {
+		name       string
+		merge      bool
+		suppress   bool
+		wantOutput string
+		wantInLogs bool
+	}{
+		{name: "merged", merge: true, wantOutput: "out\nerr\nout again\n"},
+		{name: "default", merge: false, wantOutput: "out\nout again\n", wantInLogs: true},
+		{name: "suppressed", suppress: true, wantOutput: "out\nout again\n"},
+	}
+
+	for _, c := range cases {
+		logs := &bytes.Buffer{}
+		log.SetOutput(logs)
+
+		f := ForkFunctionRunner{MergeStderr: c.merge, SuppressStderr: c.suppress}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", "echo out; echo err >&2; echo out again"},
+			OutputWriter: out,
+		}
+
+		_, err := f.Run(req)
+		log.SetOutput(os.Stderr)
+		if err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+
+		if out.String() != c.wantOutput {
+			t.Errorf("(%s) want output %q, got: %q", c.name, c.wantOutput, out.String())
+		}
+		if inLogs := strings.Contains(logs.String(), "stderr: err"); inLogs != c.wantInLogs {
+			t.Errorf("(%s) want stderr in logs: %t, got logs: %q", c.name, c.wantInLogs, logs.String())
+		}
+		if c.suppress && strings.Contains(logs.String(), "Started logging stderr") {
+			t.Errorf("(%s) want stderr not read, got logs: %q", c.name, logs.String())
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_NonZeroExitIncludesStderrTail(t *testing.T) {
+	f := ForkFunctionRunner{StderrBufferSize: 8}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "printf 'discarded-0123456789' >&2; exit 3"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	_, err := f.Run(req)
+	if err == nil {
+		t.Fatalf("want error for non-zero exit")
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("want wrapped *exec.ExitError, got: %T", err)
+	}
+
+	if !strings.HasSuffix(err.Error(), "stderr: 23456789") {
+		t.Errorf("want truncated stderr tail in error, got: %q", err.Error())
+	}
+}
+
+func TestForkFunctionRunner_Run_CleanExitHasNoStderrInError(t *testing.T) {
+	f := ForkFunctionRunner{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "echo warning >&2"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Errorf("want no error on clean exit, got: %s", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_ExecTimeoutKillsProcess(t *testing.T) {
+	f := ForkFunctionRunner{ExecTimeout: time.Millisecond * 100}
+	req := FunctionRequest{
+		Process:      "sleep",
+		ProcessArgs:  []string{"5"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	start := time.Now()
+	_, err := f.Run(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded, got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("want process killed after ExecTimeout, took: %s", elapsed)
+	}
+}
+
+func TestForkFunctionRunner_Run_ExecTimeoutError(t *testing.T) {
+	f := ForkFunctionRunner{ExecTimeout: time.Millisecond * 200}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "printf partial; exec sleep 5"},
+		OutputWriter: out,
+	}
+
+	_, err := f.Run(req)
+
+	var timeoutErr *ExecTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("want *ExecTimeoutError, got: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want error to wrap context.DeadlineExceeded, got: %v", err)
+	}
+	if timeoutErr.BytesWritten != int64(len("partial")) || out.String() != "partial" {
+		t.Errorf("want %d bytes of partial output, got: %d, %q", len("partial"), timeoutErr.BytesWritten, out.String())
+	}
+	if timeoutErr.Elapsed < time.Millisecond*200 || timeoutErr.Elapsed > time.Second*2 {
+		t.Errorf("want elapsed time close to the timeout, got: %s", timeoutErr.Elapsed)
+	}
+}
+
+func TestForkFunctionRunner_Run_TimeoutResponseBody(t *testing.T) {
+	cases := []struct {
+		name       string
+		script     string
+		headerMode bool
+		wantBody   string
+		wantStatus int
+	}{
+		{name: "no output yet", script: "exec sleep 5", wantBody: "timed out\n", wantStatus: http.StatusOK},
+		{name: "no output yet with headers", script: "exec sleep 5", headerMode: true, wantBody: "timed out\n", wantStatus: http.StatusGatewayTimeout},
+		{name: "already streaming", script: "printf partial; exec sleep 5", wantBody: "partial", wantStatus: http.StatusOK},
+		{name: "headers already sent", script: "printf 'Status: 202\n\n'; exec sleep 5", headerMode: true, wantBody: "", wantStatus: http.StatusAccepted},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{
+			ExecTimeout:          time.Millisecond * 200,
+			TimeoutResponseBody:  "timed out\n",
+			ParseResponseHeaders: c.headerMode,
+		}
+		recorder := httptest.NewRecorder()
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", c.script},
+			OutputWriter: recorder,
+		}
+
+		var timeoutErr *ExecTimeoutError
+		if _, err := f.Run(req); !errors.As(err, &timeoutErr) {
+			t.Fatalf("(%s) want *ExecTimeoutError, got: %v", c.name, err)
+		}
+
+		if body := recorder.Body.String(); body != c.wantBody {
+			t.Errorf("(%s) want body %q, got: %q", c.name, c.wantBody, body)
+		}
+		if recorder.Code != c.wantStatus {
+			t.Errorf("(%s) want status %d, got: %d", c.name, c.wantStatus, recorder.Code)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_CancelledContextKillsProcess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+
+	f := ForkFunctionRunner{ExecTimeout: time.Second * 10}
+	req := FunctionRequest{
+		Process:      "sleep",
+		ProcessArgs:  []string{"5"},
+		OutputWriter: ioutil.Discard,
+		Context:      ctx,
+	}
+
+	_, err := f.Run(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got: %v", err)
+	}
+}
+
+func TestForkFunctionRunner_RunContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+
+	cases := []struct {
+		name    string
+		ctx     context.Context
+		wantErr error
+	}{
+		{name: "cancelled context kills the process", ctx: cancelled, wantErr: context.Canceled},
+		{name: "background context runs to completion", ctx: context.Background()},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{ExecTimeout: time.Second * 10}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", "exec sleep 0.5"},
+			OutputWriter: ioutil.Discard,
+			// RunContext's ctx takes the place of any set on the request.
+			Context: context.TODO(),
+		}
+
+		_, err := f.RunContext(c.ctx, req)
+		if c.wantErr == nil && err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+		}
+		if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+			t.Errorf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+	}
+}
+
+// Every runner must implement FunctionRunner, including RunContext.
+var (
+	_ FunctionRunner = &ForkFunctionRunner{}
+	_ FunctionRunner = &SerializingForkFunctionRunner{}
+	_ FunctionRunner = &FramedForkFunctionRunner{}
+	_ FunctionRunner = &PooledForkFunctionRunner{}
+	_ FunctionRunner = &BatchForkFunctionRunner{}
+	_ FunctionRunner = &HTTPFunctionRunner{}
+	_ FunctionRunner = &StaticFileRunner{}
+	_ FunctionRunner = &MockFunctionRunner{}
+)
+
+// cancellingWriter cancels the request once the first chunk is received, as
+// when a client disconnects mid-response.
+type cancellingWriter struct {
+	once   sync.Once
+	cancel context.CancelFunc
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.once.Do(w.cancel)
+	return len(p), nil
+}
+
+func TestForkFunctionRunner_Run_CancelledMidStreamKillsProcess(t *testing.T) {
+	f := ForkFunctionRunner{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "while true; do echo line; sleep 0.01; done"},
+		OutputWriter: &cancellingWriter{cancel: cancel},
+		Context:      ctx,
+	}
+
+	result, err := f.Run(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got: %v", err)
+	}
+	if result.ExitCode != -1 {
+		t.Errorf("want process to be killed, got exit code: %d", result.ExitCode)
+	}
+}
+
+func TestForkFunctionRunner_Run_CancelledWhileWriteBlocked(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	f := ForkFunctionRunner{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "echo line; exec sleep 5"},
+		OutputWriter: &blockingWriter{unblock: unblock},
+		Context:      ctx,
+	}
+
+	start := time.Now()
+	_, err := f.Run(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("want Run to return once the process is killed, took: %s", elapsed)
+	}
+}
+
+func TestForkFunctionRunner_Run_GracePeriodSendsSIGTERM(t *testing.T) {
+	f := ForkFunctionRunner{
+		ExecTimeout:            time.Millisecond * 200,
+		TerminationGracePeriod: time.Second * 2,
+	}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "trap 'kill $!; echo marker; exit 0' TERM; sleep 5 >/dev/null 2>&1 & wait"},
+		OutputWriter: out,
+	}
+
+	_, err := f.Run(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "marker") {
+		t.Errorf("want marker written by SIGTERM trap, got: %q", out.String())
+	}
+}
+
+func TestForkFunctionRunner_Run_KillSignal(t *testing.T) {
+	f := ForkFunctionRunner{
+		ExecTimeout:            time.Millisecond * 200,
+		TerminationGracePeriod: time.Second * 2,
+		KillSignal:             syscall.SIGQUIT,
+	}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "trap 'kill $!; echo marker; exit 0' QUIT; sleep 5 >/dev/null 2>&1 & wait"},
+		OutputWriter: out,
+	}
+
+	_, err := f.Run(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "marker") {
+		t.Errorf("want marker written by SIGQUIT trap, got: %q", out.String())
+	}
+}
+
+func TestForkFunctionRunner_Run_ReportsResult(t *testing.T) {
+	f := ForkFunctionRunner{}
+
+	out := &countingWriter{writer: ioutil.Discard}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "printf 'hello world'"},
+		OutputWriter: out,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if result.ExitCode != 0 {
+		t.Errorf("want ExitCode: 0, got: %d", result.ExitCode)
+	}
+
+	if result.BytesWritten != 11 || out.Count() != 11 {
+		t.Errorf("want BytesWritten: 11, got: %d (writer saw %d)", result.BytesWritten, out.Count())
+	}
+
+	if result.Duration <= 0 {
+		t.Errorf("want positive Duration, got: %s", result.Duration)
+	}
+}
+
+func TestForkFunctionRunner_Run_ReportsExitCode(t *testing.T) {
+	f := ForkFunctionRunner{}
+	req := FunctionRequest{
+		Process:     "sh",
+		ProcessArgs: []string{"-c", "exit 7"},
+	}
+
+	result, err := f.Run(req)
+	if err == nil {
+		t.Fatalf("want error for non-zero exit")
+	}
+
+	if result.ExitCode != 7 {
+		t.Errorf("want ExitCode: 7, got: %d", result.ExitCode)
+	}
+}
+
+// activeWriter marks an invocation as active from its first write.
+type activeWriter struct {
+	once    sync.Once
+	active  *int64
+	maxSeen *int64
+}
+
+func (w *activeWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		current := atomic.AddInt64(w.active, 1)
+		for {
+			seen := atomic.LoadInt64(w.maxSeen)
+			if current <= seen || atomic.CompareAndSwapInt64(w.maxSeen, seen, current) {
+				break
+			}
+		}
+	})
+	return len(p), nil
+}
+
+func TestForkFunctionRunner_Run_MaxInflightBlocks(t *testing.T) {
+	f := ForkFunctionRunner{MaxInflight: 2, BlockWhenFull: true}
+
+	var active, maxSeen int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := FunctionRequest{
+				Process:      "sh",
+				ProcessArgs:  []string{"-c", "echo started; sleep 0.1"},
+				OutputWriter: &activeWriter{active: &active, maxSeen: &maxSeen},
+			}
+			_, err := f.Run(req)
+			atomic.AddInt64(&active, -1)
+			if err != nil {
+				t.Errorf("want no error when blocking, got: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxSeen > 2 {
+		t.Errorf("want max concurrency <= 2, got: %d", maxSeen)
+	}
+}
+
+func TestForkFunctionRunner_Run_QueueWait(t *testing.T) {
+	metrics := &fakeMetricsRecorder{}
+	f := ForkFunctionRunner{MaxInflight: 1, BlockWhenFull: true, Metrics: metrics}
+
+	busy := make(chan error, 1)
+	go func() {
+		_, err := f.Run(FunctionRequest{Process: "sleep", ProcessArgs: []string{"0.4"}})
+		busy <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	// Times out while the only slot is taken, without starting the process.
+	marker := filepath.Join(t.TempDir(), "ran")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	result, err := f.Run(FunctionRequest{Process: "touch", ProcessArgs: []string{marker}, Context: ctx})
+	if !errors.Is(err, ErrQueueTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want ErrQueueTimeout, got: %v", err)
+	}
+	if result.QueueWait < time.Millisecond*100 {
+		t.Errorf("want QueueWait for the timed out request, got: %s", result.QueueWait)
+	}
+	if _, statErr := os.Stat(marker); !os.IsNotExist(statErr) {
+		t.Errorf("want process not started after ErrQueueTimeout, got: %v", statErr)
+	}
+
+	result, err = f.Run(FunctionRequest{Process: "true"})
+	if err != nil {
+		t.Fatalf("want queued request to run, got: %s", err)
+	}
+	if result.QueueWait < time.Millisecond*100 || result.QueueWait > time.Second {
+		t.Errorf("want QueueWait until the slot was freed, got: %s", result.QueueWait)
+	}
+	if result.Duration >= result.QueueWait {
+		t.Errorf("want Duration %s not to include QueueWait %s", result.Duration, result.QueueWait)
+	}
+	if err := <-busy; err != nil {
+		t.Errorf("want busy request to complete, got: %s", err)
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	if len(metrics.queueWaits) != 3 {
+		t.Errorf("want queue wait observed for every invocation, got: %v", metrics.queueWaits)
+	}
+}
+
+func TestForkFunctionRunner_Run_MaxQueueLength(t *testing.T) {
+	f := ForkFunctionRunner{MaxInflight: 1, MaxQueueLength: 2}
+	sleep := FunctionRequest{Process: "sleep", ProcessArgs: []string{"0.3"}}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Run(sleep); err != nil {
+				t.Errorf("want running and queued requests to complete, got: %s", err)
+			}
+		}()
+	}
+	if !eventually(func() bool { return atomic.LoadInt64(&f.queued) == 2 }) {
+		t.Fatalf("want 2 queued requests, got: %d", atomic.LoadInt64(&f.queued))
+	}
+
+	start := time.Now()
+	_, err := f.Run(FunctionRequest{Process: "true"})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("want ErrQueueFull, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*100 {
+		t.Errorf("want request rejected at once, took: %s", elapsed)
+	}
+
+	wg.Wait()
+	if queued := atomic.LoadInt64(&f.queued); queued != 0 {
+		t.Errorf("want empty queue once all requests completed, got: %d", queued)
+	}
+	if _, err := f.Run(FunctionRequest{Process: "true"}); err != nil {
+		t.Errorf("want request admitted once the queue drained, got: %s", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_MaxInflightRejects(t *testing.T) {
+	f := ForkFunctionRunner{MaxInflight: 1}
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", "echo started; sleep 0.5"},
+			OutputWriter: &notifyWriter{notify: started},
+		}
+		f.Run(req)
+	}()
+	<-started
+
+	_, err := f.Run(FunctionRequest{Process: "true"})
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Errorf("want ErrTooManyRequests, got: %v", err)
+	}
+	<-done
+
+	if _, err := f.Run(FunctionRequest{Process: "true"}); err != nil {
+		t.Errorf("want slot released after completion, got: %s", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_MaxInflightReleasedOnStartFailure(t *testing.T) {
+	f := ForkFunctionRunner{MaxInflight: 1}
+
+	for i := 0; i < 3; i++ {
+		_, err := f.Run(FunctionRequest{Process: "/does/not/exist"})
+		if errors.Is(err, ErrTooManyRequests) {
+			t.Fatalf("want slot released after start failure, got: %s", err)
+		}
+	}
+}
+
+// notifyWriter closes notify on its first write.
+type notifyWriter struct {
+	once   sync.Once
+	notify chan struct{}
+}
+
+func (w *notifyWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.notify)
+	})
+	return len(p), nil
+}
+
+type recordedInvocation struct {
+	success  bool
+	duration time.Duration
+}
+
+type fakeMetricsRecorder struct {
+	mutex       sync.Mutex
+	invocations []recordedInvocation
+	queueWaits  []time.Duration
+}
+
+func (m *fakeMetricsRecorder) ObserveQueueWait(wait time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.queueWaits = append(m.queueWaits, wait)
+}
+
+func (m *fakeMetricsRecorder) ObserveInvocation(success bool, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.invocations = append(m.invocations, recordedInvocation{success: success, duration: duration})
+}
+
+func TestForkFunctionRunner_Run_RecordsMetrics(t *testing.T) {
+	recorder := &fakeMetricsRecorder{}
+	f := ForkFunctionRunner{Metrics: recorder}
+
+	for _, process := range []string{"true", "true", "false"} {
+		f.Run(FunctionRequest{Process: process})
+	}
+
+	if len(recorder.invocations) != 3 {
+		t.Fatalf("want 3 observed invocations, got: %d", len(recorder.invocations))
+	}
+
+	successes := 0
+	for _, invocation := range recorder.invocations {
+		if invocation.success {
+			successes++
+		}
+		if invocation.duration <= 0 {
+			t.Errorf("want non-zero duration, got: %s", invocation.duration)
+		}
+	}
+
+	if successes != 2 {
+		t.Errorf("want 2 successful invocations, got: %d", successes)
+	}
+}
+
+// stallingReader returns its data and then blocks until unblock is closed.
+type stallingReader struct {
+	data    io.Reader
+	unblock chan struct{}
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		<-r.unblock
+	}
+	return n, err
+}
+
+func (r *stallingReader) Close() error {
+	return nil
+}
+
+func TestForkFunctionRunner_Run_ReadTimeoutKillsProcess(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	f := ForkFunctionRunner{
+		ExecTimeout: time.Second * 10,
+		ReadTimeout: time.Millisecond * 100,
+	}
+	req := FunctionRequest{
+		Process:      "cat",
+		InputReader:  &stallingReader{data: strings.NewReader("partial"), unblock: unblock},
+		OutputWriter: ioutil.Discard,
+	}
+
+	start := time.Now()
+	result, err := f.Run(req)
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Fatalf("want ErrReadTimeout, got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("want Run to return soon after ReadTimeout, took: %s", elapsed)
+	}
+
+	if result.ExitCode != -1 {
+		t.Errorf("want process terminated by a signal, got ExitCode: %d", result.ExitCode)
+	}
+}
+
+// blockingWriter blocks every Write until unblock is closed.
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+func TestForkFunctionRunner_Run_WriteTimeoutAbortsInvocation(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	f := ForkFunctionRunner{
+		ExecTimeout:  time.Second * 10,
+		WriteTimeout: time.Millisecond * 100,
+	}
+	req := FunctionRequest{
+		Process:      "head",
+		ProcessArgs:  []string{"-c", "1048576", "/dev/zero"},
+		OutputWriter: &blockingWriter{unblock: unblock},
+	}
+
+	start := time.Now()
+	_, err := f.Run(req)
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Fatalf("want ErrWriteTimeout, got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("want Run to be aborted after WriteTimeout, took: %s", elapsed)
+	}
+}
+
+func TestForkFunctionRunner_Run_StdinStallTimeout(t *testing.T) {
+	// Larger than a pipe's buffer, so the copy blocks unless it is read.
+	body := bytes.Repeat([]byte("x"), 1024*1024)
+
+	cases := []struct {
+		name    string
+		script  string
+		wantErr error
+	}{
+		{name: "ignores stdin", script: "exec sleep 5", wantErr: ErrStdinStalled},
+		{name: "reads stdin", script: "cat >/dev/null"},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{
+			ExecTimeout:       time.Second * 10,
+			StdinStallTimeout: time.Millisecond * 200,
+		}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", c.script},
+			InputReader:  ioutil.NopCloser(bytes.NewReader(body)),
+			OutputWriter: ioutil.Discard,
+		}
+
+		start := time.Now()
+		result, err := f.Run(req)
+		if c.wantErr == nil {
+			if err != nil {
+				t.Errorf("(%s) want no error, got: %s", c.name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, c.wantErr) {
+			t.Fatalf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+		if result.ExitCode != -1 {
+			t.Errorf("(%s) want process to be killed, got exit code: %d", c.name, result.ExitCode)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second*2 {
+			t.Errorf("(%s) want stall detected after StdinStallTimeout, took: %s", c.name, elapsed)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_HeadersInEnvironment(t *testing.T) {
+	f := ForkFunctionRunner{}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "env",
+		OutputWriter: out,
+		Environment:  []string{"fprocess=env"},
+		Headers: map[string][]string{
+			"Content-Type": {"text/plain"},
+			"X-Call-Id":    {"abc123"},
+		},
+		Method: "POST",
+		Path:   "/function/env",
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	want := []string{
+		"fprocess=env",
+		"Http_Content_Type=text/plain",
+		"Http_X_Call_Id=abc123",
+		"Http_Method=POST",
+		"Http_Path=/function/env",
+	}
+
+	env := strings.Split(out.String(), "\n")
+	for _, kv := range want {
+		found := false
+		for _, line := range env {
+			if line == kv {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("want %q in environment, got: %q", kv, out.String())
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_QueryStringInEnvironment(t *testing.T) {
+	cases := []struct {
+		name    string
+		explode bool
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "raw",
+			want: []string{
+				"Http_Query=name=Alex%20S&repeat=1&repeat=2&a-b=dash&a_b=underscore",
+				"QUERY_STRING=name=Alex%20S&repeat=1&repeat=2&a-b=dash&a_b=underscore",
+			},
+			notWant: []string{"Http_Param_name=Alex S"},
+		},
+		{
+			name:    "exploded",
+			explode: true,
+			want: []string{
+				"Http_Query=name=Alex%20S&repeat=1&repeat=2&a-b=dash&a_b=underscore",
+				"Http_Param_name=Alex S",
+				"Http_Param_repeat=1",
+				"Http_Param_a_b=dash",
+			},
+			notWant: []string{"Http_Param_repeat=2", "Http_Param_a_b=underscore"},
+		},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{ExplodeQueryParams: c.explode}
+
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "env",
+			OutputWriter: out,
+			Environment:  []string{},
+			QueryString:  "name=Alex%20S&repeat=1&repeat=2&a-b=dash&a_b=underscore",
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+
+		env := strings.Split(out.String(), "\n")
+		contains := func(kv string) bool {
+			for _, line := range env {
+				if line == kv {
+					return true
+				}
+			}
+			return false
+		}
+
+		for _, kv := range c.want {
+			if !contains(kv) {
+				t.Errorf("(%s) want %q in environment, got: %q", c.name, kv, out.String())
+			}
+		}
+		for _, kv := range c.notWant {
+			if contains(kv) {
+				t.Errorf("(%s) want %q not in environment, got: %q", c.name, kv, out.String())
+			}
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_WorkingDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "working-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want, _ := filepath.EvalSymlinks(dir)
+
+	f := ForkFunctionRunner{}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "pwd",
+		ProcessArgs:  []string{"-P"},
+		OutputWriter: out,
+		WorkingDir:   dir,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if got := strings.TrimSpace(out.String()); got != want {
+		t.Errorf("want cwd: %q, got: %q", want, got)
+	}
+}
+
+func TestForkFunctionRunner_Run_MissingWorkingDir(t *testing.T) {
+	f := ForkFunctionRunner{}
+	req := FunctionRequest{
+		Process:    "pwd",
+		WorkingDir: "/does/not/exist",
+	}
+
+	_, err := f.Run(req)
+	if err == nil || !strings.Contains(err.Error(), "invalid working directory") {
+		t.Fatalf("want descriptive working directory error, got: %v", err)
+	}
+
+	if !os.IsNotExist(errors.Unwrap(err)) {
+		t.Errorf("want wrapped not-exist error, got: %v", err)
+	}
+}
+
+type panickingReader struct{}
+
+func (panickingReader) Read(p []byte) (int, error) {
+	panic("read failed")
+}
+
+func Test_openStderr_FailingPipe(t *testing.T) {
+	cmd := exec.Command("true")
+	cmd.Stderr = &bytes.Buffer{}
+
+	if errPipe := openStderr(cmd, ""); errPipe != nil {
+		t.Errorf("want nil pipe when StderrPipe fails, got: %v", errPipe)
+	}
+}
+
+func Test_logStderr_RecoversFromPanic(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("want panic recovered, got: %v", r)
+		}
+	}()
+
+	logStderr(panickingReader{}, newRingBuffer(16), nil, nil, "")
+}
+
+func TestForkFunctionRunner_Run_RequestID(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	f := ForkFunctionRunner{}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "echo $X_Call_Id; echo oops >&2"},
+		OutputWriter: out,
+		RequestID:    "call-1234",
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if got := strings.TrimSpace(out.String()); got != "call-1234" {
+		t.Errorf("want X_Call_Id: call-1234 in environment, got: %q", got)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if !strings.Contains(line, "[call-1234] ") {
+			t.Errorf("want request ID in every log line, got: %q", line)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_GeneratesRequestID(t *testing.T) {
+	f := ForkFunctionRunner{}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "echo $X_Call_Id"},
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	id := strings.TrimSpace(out.String())
+	if len(id) != 36 || strings.Count(id, "-") != 4 {
+		t.Errorf("want a UUID-like X_Call_Id, got: %q", id)
+	}
+}
+
+func TestForkFunctionRunner_Run_MaxResponseBytes(t *testing.T) {
+	cases := []struct {
+		name     string
+		truncate bool
+		output   string
+		want     string
+		wantErr  error
+	}{
+		{name: "Under limit", output: "12345", want: "12345"},
+		{name: "At limit", output: "1234567890", want: "1234567890"},
+		{name: "Truncated", truncate: true, output: "1234567890abcdef", want: "1234567890"},
+		{name: "Too large", output: "1234567890abcdef", want: "", wantErr: ErrResponseTooLarge},
+	}
+
+	for _, testCase := range cases {
+		f := ForkFunctionRunner{MaxResponseBytes: 10, TruncateResponse: testCase.truncate}
+
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "printf",
+			ProcessArgs:  []string{"%s", testCase.output},
+			OutputWriter: out,
+		}
+
+		result, err := f.Run(req)
+		if testCase.wantErr == nil && err != nil {
+			t.Errorf("(%s) want no error, got: %s", testCase.name, err)
+		}
+		if testCase.wantErr != nil && !errors.Is(err, testCase.wantErr) {
+			t.Errorf("(%s) want error: %s, got: %v", testCase.name, testCase.wantErr, err)
+		}
+
+		if out.String() != testCase.want {
+			t.Errorf("(%s) want output: %q, got: %q", testCase.name, testCase.want, out.String())
+		}
+
+		if result.BytesWritten != int64(len(testCase.want)) {
+			t.Errorf("(%s) want BytesWritten: %d, got: %d", testCase.name, len(testCase.want), result.BytesWritten)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_MaxResponseBytesKillsProcess(t *testing.T) {
+	f := ForkFunctionRunner{MaxResponseBytes: 1024}
+	req := FunctionRequest{
+		Process:      "head",
+		ProcessArgs:  []string{"-c", "104857600", "/dev/zero"},
+		OutputWriter: ioutil.Discard,
+	}
+
+	result, err := f.Run(req)
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("want ErrResponseTooLarge, got: %v", err)
+	}
+
+	if result.BytesWritten > 1024 {
+		t.Errorf("want at most 1024 bytes written, got: %d", result.BytesWritten)
+	}
+}
+
+func TestForkFunctionRunner_Run_MaxRequestBytes(t *testing.T) {
+	tooLarge := int64(11)
+	atLimit := int64(10)
+
+	cases := []struct {
+		name          string
+		body          string
+		contentLength *int64
+		wantErr       bool
+		wantOutput    string
+	}{
+		{name: "Rejected before start", body: "hello world", contentLength: &tooLarge, wantErr: true},
+		{name: "Rejected while streaming", body: strings.Repeat("x", 1024*1024), wantErr: true},
+		{name: "Exactly at limit", body: "0123456789", contentLength: &atLimit, wantOutput: "0123456789"},
+		{name: "At limit without Content-Length", body: "0123456789", wantOutput: "0123456789"},
+	}
+
+	for _, testCase := range cases {
+		f := ForkFunctionRunner{MaxRequestBytes: 10}
+
+		body := strings.NewReader(testCase.body)
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:       "cat",
+			InputReader:   ioutil.NopCloser(body),
+			ContentLength: testCase.contentLength,
+			OutputWriter:  out,
+		}
+
+		_, err := f.Run(req)
+		if testCase.wantErr {
+			if !errors.Is(err, ErrRequestTooLarge) {
+				t.Errorf("(%s) want ErrRequestTooLarge, got: %v", testCase.name, err)
+			}
+			if testCase.contentLength != nil && body.Len() != len(testCase.body) {
+				t.Errorf("(%s) want body left unread when rejected before start", testCase.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("(%s) want no error, got: %s", testCase.name, err)
+		}
+
+		if out.String() != testCase.wantOutput {
+			t.Errorf("(%s) want output: %q, got: %q", testCase.name, testCase.wantOutput, out.String())
+		}
+	}
+}
+
+func startLongInvocation(f *ForkFunctionRunner, sleep string) (chan struct{}, chan error) {
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", "echo started; sleep " + sleep},
+			OutputWriter: &notifyWriter{notify: started},
+		}
+		_, err := f.Run(req)
+		done <- err
+	}()
+	return started, done
+}
+
+func TestForkFunctionRunner_Shutdown_WaitsForInflight(t *testing.T) {
+	f := &ForkFunctionRunner{}
+
+	started, done := startLongInvocation(f, "0.3")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := f.Shutdown(ctx); err != nil {
+		t.Fatalf("want Shutdown to drain, got: %s", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("want in-flight invocation to complete, got: %s", err)
+		}
+	default:
+		t.Errorf("want Shutdown to return after the in-flight invocation")
+	}
+
+	if _, err := f.Run(FunctionRequest{Process: "true"}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("want ErrShuttingDown after Shutdown, got: %v", err)
+	}
+}
+
+func TestForkFunctionRunner_Shutdown_DeadlineExpires(t *testing.T) {
+	f := &ForkFunctionRunner{}
+
+	started, done := startLongInvocation(f, "1")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	if err := f.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got: %v", err)
+	}
+	<-done
+}
+
+func TestForkFunctionRunner_Run_MaxLifetimeInvocations(t *testing.T) {
+	reached := make(chan struct{})
+	f := &ForkFunctionRunner{
+		MaxLifetimeInvocations: 3,
+		OnLifetimeReached: func() {
+			close(reached)
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := f.Run(FunctionRequest{Process: "true"}); err != nil {
+			t.Fatalf("want invocation %d accepted, got: %s", i+1, err)
+		}
+	}
+
+	started, done := startLongInvocation(f, "0.2")
+	<-started
+
+	if _, err := f.Run(FunctionRequest{Process: "true"}); !errors.Is(err, ErrLifetimeReached) {
+		t.Errorf("want ErrLifetimeReached past the limit, got: %v", err)
+	}
+	if f.Ready() {
+		t.Errorf("want runner not ready once the limit is reached")
+	}
+
+	select {
+	case <-reached:
+		t.Errorf("want OnLifetimeReached called only once in-flight invocations complete")
+	default:
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("want in-flight invocation completed, got: %s", err)
+	}
+
+	select {
+	case <-reached:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("want OnLifetimeReached called after the last invocation")
+	}
+}
+
+func TestForkFunctionRunner_Run_MaxLifetimeInvocationsSkipsRejected(t *testing.T) {
+	cases := []struct {
+		name    string
+		runner  *ForkFunctionRunner
+		process string
+		wantErr error
+	}{
+		{
+			name:    "Rate limited",
+			runner:  &ForkFunctionRunner{RateLimit: 0.001},
+			process: "true",
+			wantErr: ErrRateLimited,
+		},
+		{
+			name:    "Circuit open",
+			runner:  &ForkFunctionRunner{FailureThreshold: 1, OpenDuration: time.Minute},
+			process: "false",
+			wantErr: ErrCircuitOpen,
+		},
+	}
+
+	for _, testCase := range cases {
+		reached := make(chan struct{})
+		f := testCase.runner
+		f.MaxLifetimeInvocations = 2
+		f.OnLifetimeReached = func() {
+			close(reached)
+		}
+
+		f.Run(FunctionRequest{Process: testCase.process})
+		for i := 0; i < 3; i++ {
+			if _, err := f.Run(FunctionRequest{Process: testCase.process}); !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("(%s) want %v, got: %v", testCase.name, testCase.wantErr, err)
+			}
+		}
+
+		if !f.Ready() {
+			t.Errorf("(%s) want runner still ready, rejected invocations do not count", testCase.name)
+		}
+		if f.invocations != 1 {
+			t.Errorf("(%s) want 1 invocation counted, got: %d", testCase.name, f.invocations)
+		}
+		select {
+		case <-reached:
+			t.Errorf("(%s) want OnLifetimeReached not called", testCase.name)
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+}
+
+// recordingFlusher records how much output had been written at each Flush.
+type recordingFlusher struct {
+	bytes.Buffer
+	flushedAt []int
+}
+
+func (r *recordingFlusher) Flush() {
+	r.flushedAt = append(r.flushedAt, r.Len())
+}
+
+func TestForkFunctionRunner_Run_FlushesEachChunk(t *testing.T) {
+	f := ForkFunctionRunner{}
+
+	out := &recordingFlusher{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "printf 'a'; sleep 0.1; printf 'b'; sleep 0.1; printf 'c'"},
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	want := []int{1, 2, 3}
+	if fmt.Sprint(out.flushedAt) != fmt.Sprint(want) {
+		t.Errorf("want flushes after each chunk at %v, got: %v", want, out.flushedAt)
+	}
+}
+
+func TestForkFunctionRunner_Run_DecompressRequest(t *testing.T) {
+	compressed := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(compressed)
+	gzipWriter.Write([]byte(strings.Repeat("hello world ", 1000)))
+	gzipWriter.Close()
+
+	cases := []struct {
+		name       string
+		body       []byte
+		wantOutput string
+		anyOutput  bool // partial output depends on when the process is killed
+		wantErr    error
+	}{
+		{
+			name:       "valid",
+			body:       compressed.Bytes(),
+			wantOutput: "started\n" + strings.Repeat("hello world ", 1000),
+		},
+		{
+			name:       "not gzip",
+			body:       []byte("plain text"),
+			wantOutput: "",
+			wantErr:    ErrInvalidRequestEncoding,
+		},
+		{
+			name:      "truncated",
+			body:      compressed.Bytes()[:compressed.Len()/2],
+			anyOutput: true,
+			wantErr:   ErrInvalidRequestEncoding,
+		},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{DecompressRequest: true}
+
+		contentLength := int64(len(c.body))
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:       "sh",
+			ProcessArgs:   []string{"-c", "echo started; cat"},
+			InputReader:   ioutil.NopCloser(bytes.NewReader(c.body)),
+			ContentLength: &contentLength,
+			OutputWriter:  out,
+			Headers: map[string][]string{
+				"Content-Encoding": {"gzip"},
+			},
+		}
+
+		_, err := f.Run(req)
+		if c.wantErr == nil && err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+		}
+		if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+			t.Errorf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+
+		if !c.anyOutput && out.String() != c.wantOutput {
+			t.Errorf("(%s) want output %q, got: %q", c.name, c.wantOutput, out.String())
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_StatusMapping(t *testing.T) {
+	f := ForkFunctionRunner{
+		StatusMapping: map[int]int{
+			2: 400,
+			3: 404,
+		},
+		StatusRanges: []StatusRange{
+			{From: 10, To: 19, Status: 503},
+		},
+	}
+
+	cases := []struct {
+		name       string
+		exitCode   string
+		wantStatus int
+	}{
+		{name: "success", exitCode: "0", wantStatus: 200},
+		{name: "mapped", exitCode: "3", wantStatus: 404},
+		{name: "range", exitCode: "15", wantStatus: 503},
+		{name: "unmapped", exitCode: "7", wantStatus: 500},
+	}
+
+	for _, c := range cases {
+		req := FunctionRequest{
+			Process:     "sh",
+			ProcessArgs: []string{"-c", "exit " + c.exitCode},
+		}
+
+		result, _ := f.Run(req)
+		if result.HTTPStatus != c.wantStatus {
+			t.Errorf("(%s) want HTTPStatus %d, got: %d", c.name, c.wantStatus, result.HTTPStatus)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_StatusHeaderOverridesMapping(t *testing.T) {
+	f := ForkFunctionRunner{
+		ParseResponseHeaders: true,
+		StatusMapping:        map[int]int{0: 202},
+	}
+
+	req := FunctionRequest{
+		Process:     "printf",
+		ProcessArgs: []string{"Status: 201\n\ncreated"},
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if result.HTTPStatus != 201 {
+		t.Errorf("want HTTPStatus from Status header 201, got: %d", result.HTTPStatus)
+	}
+}
+
+func TestForkFunctionRunner_Warmup(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "warm")
+
+	cases := []struct {
+		name    string
+		command []string
+		wantErr bool
+	}{
+		{name: "runs command", command: []string{"sh", "-c", "echo ignored; touch " + marker}},
+		{name: "start failure", command: []string{"/does/not/exist"}, wantErr: true},
+		{name: "non-zero exit", command: []string{"sh", "-c", "exit 1"}, wantErr: true},
+		{name: "no command"},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{WarmupCommand: c.command}
+
+		err := f.Warmup()
+		if c.wantErr && err == nil {
+			t.Errorf("(%s) want error", c.name)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+		}
+	}
+
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("want warmup command to have run, got: %s", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_RetriesTransientStartFailures(t *testing.T) {
+	cases := []struct {
+		name         string
+		failures     int
+		failWith     error
+		maxRetries   int
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "succeeds after retries", failures: 2, failWith: syscall.EAGAIN, maxRetries: 3, wantAttempts: 3},
+		{name: "retries exhausted", failures: 5, failWith: syscall.EAGAIN, maxRetries: 2, wantErr: syscall.EAGAIN, wantAttempts: 3},
+		{name: "not retryable", failures: 1, failWith: os.ErrPermission, maxRetries: 3, wantErr: os.ErrPermission, wantAttempts: 1},
+	}
+
+	for _, c := range cases {
+		attempts := 0
+		f := ForkFunctionRunner{
+			MaxStartRetries:   c.maxRetries,
+			StartRetryBackoff: time.Millisecond,
+			start: func(cmd *exec.Cmd) error {
+				attempts++
+				if attempts <= c.failures {
+					return c.failWith
+				}
+				return cmd.Start()
+			},
+		}
+
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "cat",
+			InputReader:  ioutil.NopCloser(strings.NewReader("hello")),
+			OutputWriter: out,
+		}
+
+		_, err := f.Run(req)
+		if c.wantErr == nil {
+			if err != nil {
+				t.Errorf("(%s) want no error, got: %s", c.name, err)
+			}
+			if out.String() != "hello" {
+				t.Errorf("(%s) want output %q, got: %q", c.name, "hello", out.String())
+			}
+		} else if !errors.Is(err, c.wantErr) {
+			t.Errorf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+
+		if attempts != c.wantAttempts {
+			t.Errorf("(%s) want %d start attempts, got: %d", c.name, c.wantAttempts, attempts)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_BodyLengthMismatch(t *testing.T) {
+	cases := []struct {
+		name          string
+		body          string
+		contentLength int64
+		wantErr       error
+	}{
+		{name: "exact length", body: "hello", contentLength: 5},
+		{name: "short body", body: "hello", contentLength: 10, wantErr: ErrShortBody},
+		{name: "long body", body: "hello world", contentLength: 5, wantErr: ErrLongBody},
+		{name: "empty body with length", body: "", contentLength: 1, wantErr: ErrShortBody},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{ExecTimeout: time.Second * 5}
+
+		contentLength := c.contentLength
+		req := FunctionRequest{
+			Process: "sh",
+			// Keeps running after stdin ends, so only the runner can stop it early.
+			ProcessArgs:   []string{"-c", "cat >/dev/null; exec sleep 5"},
+			InputReader:   ioutil.NopCloser(strings.NewReader(c.body)),
+			ContentLength: &contentLength,
+		}
+		if c.wantErr == nil {
+			req.ProcessArgs = []string{"-c", "cat >/dev/null"}
+		}
+
+		start := time.Now()
+		_, err := f.Run(req)
+		if c.wantErr == nil && err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+		}
+		if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+			t.Errorf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second*2 {
+			t.Errorf("(%s) want to fail fast, took: %s", c.name, elapsed)
+		}
+	}
+}
+
+func TestForkFunctionRunner_execTimeout(t *testing.T) {
+	length := func(n int64) *int64 {
+		return &n
+	}
+
+	cases := []struct {
+		name          string
+		runner        *ForkFunctionRunner
+		contentLength *int64
+		override      time.Duration
+		want          time.Duration
+	}{
+		{
+			name:          "scaled by content length",
+			runner:        &ForkFunctionRunner{ExecTimeout: time.Second, TimeoutPerByte: time.Millisecond},
+			contentLength: length(500),
+			want:          time.Millisecond * 1500,
+		},
+		{
+			name:          "capped",
+			runner:        &ForkFunctionRunner{ExecTimeout: time.Second, TimeoutPerByte: time.Millisecond, MaxExecTimeout: time.Second * 2},
+			contentLength: length(5000),
+			want:          time.Second * 2,
+		},
+		{
+			name:   "unknown content length",
+			runner: &ForkFunctionRunner{ExecTimeout: time.Second, TimeoutPerByte: time.Millisecond},
+			want:   time.Second,
+		},
+		{
+			name:          "no exec timeout",
+			runner:        &ForkFunctionRunner{TimeoutPerByte: time.Millisecond},
+			contentLength: length(500),
+			want:          0,
+		},
+		{
+			name:          "overflow",
+			runner:        &ForkFunctionRunner{ExecTimeout: time.Second, TimeoutPerByte: time.Hour, MaxExecTimeout: time.Minute},
+			contentLength: length(1 << 62),
+			want:          time.Minute,
+		},
+		{
+			name:          "override replaces scaled timeout",
+			runner:        &ForkFunctionRunner{ExecTimeout: time.Second, TimeoutPerByte: time.Millisecond},
+			contentLength: length(500),
+			override:      time.Millisecond * 200,
+			want:          time.Millisecond * 200,
+		},
+		{
+			name:     "override without exec timeout",
+			runner:   &ForkFunctionRunner{},
+			override: time.Second * 30,
+			want:     time.Second * 30,
+		},
+		{
+			name:     "override clamped",
+			runner:   &ForkFunctionRunner{ExecTimeout: time.Second, MaxExecTimeout: time.Second * 5},
+			override: time.Hour,
+			want:     time.Second * 5,
+		},
+	}
+
+	for _, c := range cases {
+		got := c.runner.execTimeout(FunctionRequest{ContentLength: c.contentLength, ExecTimeoutOverride: c.override}, c.runner.ExecTimeout)
+		if got != c.want {
+			t.Errorf("(%s) want %s, got: %s", c.name, c.want, got)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_TimeoutPerByteExtendsDeadline(t *testing.T) {
+	f := ForkFunctionRunner{
+		ExecTimeout:    time.Millisecond * 100,
+		TimeoutPerByte: time.Millisecond * 100,
+	}
+
+	contentLength := int64(5)
+	req := FunctionRequest{
+		Process:       "sh",
+		ProcessArgs:   []string{"-c", "cat >/dev/null; sleep 0.3"},
+		InputReader:   ioutil.NopCloser(strings.NewReader("hello")),
+		ContentLength: &contentLength,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Errorf("want deadline extended to 600ms, got: %s", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_ExecTimeoutOverride(t *testing.T) {
+	cases := []struct {
+		name        string
+		execTimeout time.Duration
+		override    time.Duration
+		max         time.Duration
+		wantKilled  bool
+	}{
+		{name: "override shortens deadline", execTimeout: time.Second * 5, override: time.Millisecond * 100, wantKilled: true},
+		{name: "override lengthens deadline", execTimeout: time.Millisecond * 100, override: time.Second * 5},
+		{name: "override clamped to max", execTimeout: time.Millisecond * 100, override: time.Second * 5, max: time.Millisecond * 150, wantKilled: true},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{ExecTimeout: c.execTimeout, MaxExecTimeout: c.max}
+		req := FunctionRequest{
+			Process:             "sh",
+			ProcessArgs:         []string{"-c", "exec sleep 0.4"},
+			ExecTimeoutOverride: c.override,
+		}
+
+		_, err := f.Run(req)
+		if killed := errors.Is(err, context.DeadlineExceeded); killed != c.wantKilled {
+			t.Errorf("(%s) want killed: %t, got: %v", c.name, c.wantKilled, err)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_Hooks(t *testing.T) {
+	var calls []string
+	var after RunResult
+	var afterErr error
+
+	f := ForkFunctionRunner{
+		BeforeExec: func(req *FunctionRequest) error {
+			calls = append(calls, "before")
+			req.Environment = append(req.Environment, "ADDED_BY_HOOK=yes")
+			return nil
+		},
+		AfterExec: func(req FunctionRequest, result RunResult, err error) {
+			calls = append(calls, "after")
+			after, afterErr = result, err
+		},
+	}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "printf $ADDED_BY_HOOK; exit 3"},
+		Environment:  []string{},
+		OutputWriter: out,
+	}
+
+	result, err := f.Run(req)
+
+	if fmt.Sprint(calls) != "[before after]" {
+		t.Errorf("want hooks called in order, got: %v", calls)
+	}
+	if out.String() != "yes" {
+		t.Errorf("want BeforeExec to change the environment, got: %q", out.String())
+	}
+	if after.ExitCode != 3 || after.ExitCode != result.ExitCode || afterErr != err {
+		t.Errorf("want AfterExec to see the final result, got: %+v, %v", after, afterErr)
+	}
+}
+
+func TestForkFunctionRunner_Run_OnStart(t *testing.T) {
+	var started int
+	f := ForkFunctionRunner{
+		OnStart: func(pid int) {
+			started = pid
+		},
+	}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "echo $$"},
+		OutputWriter: out,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if started <= 0 || strconv.Itoa(started) != strings.TrimSpace(out.String()) {
+		t.Errorf("want OnStart called with pid %q, got: %d", strings.TrimSpace(out.String()), started)
+	}
+	if result.PID != started {
+		t.Errorf("want PID %d, got: %d", started, result.PID)
+	}
+}
+
+func TestForkFunctionRunner_Run_RecoversFromPanic(t *testing.T) {
+	panicking := true
+	var afterErr error
+	f := &ForkFunctionRunner{
+		MaxInflight: 1,
+		BeforeExec: func(req *FunctionRequest) error {
+			if panicking {
+				var hook func()
+				hook()
+			}
+			return nil
+		},
+		AfterExec: func(req FunctionRequest, result RunResult, err error) {
+			afterErr = err
+		},
+	}
+
+	result, err := f.Run(FunctionRequest{Process: "true"})
+	if !errors.Is(err, ErrPanic) {
+		t.Fatalf("want ErrPanic, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "nil pointer") {
+		t.Errorf("want the panic left out of the error, got: %s", err)
+	}
+	if result.ExitCode != -1 || afterErr != err {
+		t.Errorf("want ExitCode -1 and AfterExec to see ErrPanic, got: %d, %v", result.ExitCode, afterErr)
+	}
+
+	// The MaxInflight slot was released, or this would be rejected.
+	panicking = false
+	if _, err := f.Run(FunctionRequest{Process: "true"}); err != nil {
+		t.Errorf("want the next invocation to run, got: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := f.Shutdown(ctx); err != nil {
+		t.Errorf("want nothing left in flight, got: %s", err)
+	}
+}
+
+// panickingTransformer panics when the request body is transformed, which
+// happens once run has started.
+type panickingTransformer struct{}
+
+func (panickingTransformer) Transform(body io.Reader) (io.Reader, error) {
+	panic("transform failed")
+}
+
+func TestForkFunctionRunner_Run_PanicRecordedAsFailure(t *testing.T) {
+	tracer := &memoryTracer{}
+	f := &ForkFunctionRunner{
+		FailureThreshold:   1,
+		OpenDuration:       time.Minute,
+		Tracer:             tracer,
+		RequestTransformer: panickingTransformer{},
+	}
+
+	req := FunctionRequest{
+		Process:     "cat",
+		InputReader: ioutil.NopCloser(strings.NewReader("body")),
+	}
+	if _, err := f.Run(req); !errors.Is(err, ErrPanic) {
+		t.Fatalf("want ErrPanic, got: %v", err)
+	}
+
+	if len(tracer.spans) != 1 || !tracer.spans[0].ended || tracer.spans[0].err != ErrPanic {
+		t.Errorf("want the span ended with ErrPanic, got: %+v", tracer.spans)
+	}
+	if stats := f.Stats(); stats.ActiveInvocations != 0 || stats.TotalFailures != 1 {
+		t.Errorf("want the panic counted as a failed invocation, got: %+v", stats)
+	}
+
+	f.RequestTransformer = nil
+	if _, err := f.Run(FunctionRequest{Process: "true"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("want the breaker opened by the panic, got: %v", err)
+	}
+}
+
+// panickingWriter panics on the first Write.
+type panickingWriter struct{}
+
+func (panickingWriter) Write(p []byte) (int, error) {
+	panic("write failed")
+}
+
+func TestForkFunctionRunner_Run_PanicWhileCopying(t *testing.T) {
+	cases := []struct {
+		name string
+		req  FunctionRequest
+	}{
+		{
+			name: "output writer",
+			req: FunctionRequest{
+				Process:      "sh",
+				ProcessArgs:  []string{"-c", "echo hello; exec sleep 5"},
+				OutputWriter: panickingWriter{},
+			},
+		},
+		{
+			name: "input reader",
+			req: FunctionRequest{
+				Process:      "cat",
+				InputReader:  ioutil.NopCloser(panickingReader{}),
+				OutputWriter: ioutil.Discard,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		f := &ForkFunctionRunner{ExecTimeout: time.Second * 10}
+
+		start := time.Now()
+		if _, err := f.Run(c.req); !errors.Is(err, ErrPanic) {
+			t.Errorf("(%s) want ErrPanic, got: %v", c.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second*3 {
+			t.Errorf("(%s) want the process killed after the panic, took: %s", c.name, elapsed)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_PanicReplayedToIdempotentFollower(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	f := &ForkFunctionRunner{
+		BeforeExec: func(req *FunctionRequest) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-release
+				panic("hook failed")
+			}
+			return nil
+		},
+	}
+
+	run := func() chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := f.Run(FunctionRequest{Process: "true", IdempotencyKey: "key-1"})
+			done <- err
+		}()
+		return done
+	}
+
+	leader := run()
+	if !eventually(func() bool { return atomic.LoadInt32(&calls) == 1 }) {
+		t.Fatalf("want the leader to reach BeforeExec")
+	}
+	follower := run()
+
+	// The follower waits for the leader's outcome once it has joined.
+	time.Sleep(time.Millisecond * 200)
+	close(release)
+
+	if err := <-leader; !errors.Is(err, ErrPanic) {
+		t.Errorf("want ErrPanic for the leader, got: %v", err)
+	}
+	if err := <-follower; !errors.Is(err, ErrPanic) {
+		t.Errorf("want ErrPanic replayed to the follower, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("want the follower not to run the function, got %d calls", n)
+	}
+}
+
+func TestForkFunctionRunner_Run_BeforeExecErrorPreventsExecution(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	hookErr := errors.New("denied")
+
+	var afterErr error
+	f := ForkFunctionRunner{
+		BeforeExec: func(req *FunctionRequest) error {
+			return hookErr
+		},
+		AfterExec: func(req FunctionRequest, result RunResult, err error) {
+			afterErr = err
+		},
+	}
+
+	req := FunctionRequest{
+		Process:     "touch",
+		ProcessArgs: []string{marker},
+	}
+
+	if _, err := f.Run(req); err != hookErr {
+		t.Errorf("want BeforeExec error, got: %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("want process not to be started, got: %v", err)
+	}
+	if afterErr != hookErr {
+		t.Errorf("want AfterExec to see the BeforeExec error, got: %v", afterErr)
+	}
+}
+
+// chunkedReader returns data a few bytes at a time, like a request body sent
+// with chunked transfer encoding.
+type chunkedReader struct {
+	data []byte
+	size int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.size
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestForkFunctionRunner_Run_StreamsChunkedBodyToEOF(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+
+	f := ForkFunctionRunner{}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "wc -c; echo eof"},
+		InputReader:  ioutil.NopCloser(&chunkedReader{data: body, size: 7}),
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	want := fmt.Sprintf("%d\neof\n", len(body))
+	if strings.TrimLeft(out.String(), " ") != want {
+		t.Errorf("want whole body then EOF seen, got: %q", out.String())
+	}
+}
+
+func TestForkFunctionRunner_Run_CloseStdinOnEOF(t *testing.T) {
+	keepOpen := false
+	closeOnEOF := true
+
+	cases := []struct {
+		name       string
+		closeStdin *bool
+		want       string
+	}{
+		{name: "default", want: "closed\n"},
+		{name: "explicitly closed", closeStdin: &closeOnEOF, want: "closed\n"},
+		{name: "left open", closeStdin: &keepOpen, want: "open\n"},
+	}
+
+	// cat keeps running for as long as stdin is open, it is given stdin
+	// through fd 3 as background jobs otherwise read from /dev/null.
+	script := `exec 3<&0
+cat <&3 >/dev/null & pid=$!
+sleep 0.3
+if kill -0 $pid 2>/dev/null; then echo open; kill $pid; else echo closed; fi`
+
+	for _, c := range cases {
+		f := &ForkFunctionRunner{CloseStdinOnEOF: c.closeStdin}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", script},
+			InputReader:  ioutil.NopCloser(&chunkedReader{data: []byte("body"), size: 1}),
+			OutputWriter: out,
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+		if out.String() != c.want {
+			t.Errorf("(%s) want %q, got: %q", c.name, c.want, out.String())
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_NoInputReadsEOF(t *testing.T) {
+	// The watchdog's own stdin is left open, so a function attached to it
+	// would block until the ExecTimeout.
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdinWriter.Close()
+	defer stdinReader.Close()
+
+	stdin := os.Stdin
+	os.Stdin = stdinReader
+	defer func() { os.Stdin = stdin }()
+
+	f := ForkFunctionRunner{ExecTimeout: time.Second * 2}
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "wc -c; echo eof"},
+		OutputWriter: out,
+	}
+
+	start := time.Now()
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("want function to see EOF promptly, took: %s", took)
+	}
+	if want := "0\neof\n"; strings.TrimLeft(out.String(), " ") != want {
+		t.Errorf("want %q, got: %q", want, out.String())
+	}
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestForkFunctionRunner_Run_ExtraOutputWriters(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	audit := &bytes.Buffer{}
+	f := ForkFunctionRunner{
+		ExtraOutputWriters: []io.Writer{failingWriter{err: errors.New("sink unavailable")}, audit},
+	}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "sh",
+		ProcessArgs:  []string{"-c", "echo first; sleep 0.1; echo second"},
+		OutputWriter: out,
+	}
+
+	result, err := f.Run(req)
+	if err != nil {
+		t.Fatalf("want a failing extra writer not to fail the invocation, got: %s", err)
+	}
+
+	if out.String() != "first\nsecond\n" {
+		t.Errorf("want whole output written, got: %q", out.String())
+	}
+	if audit.String() != out.String() {
+		t.Errorf("want extra writer to receive identical bytes %q, got: %q", out.String(), audit.String())
+	}
+	if result.BytesWritten != int64(out.Len()) {
+		t.Errorf("want BytesWritten %d, got: %d", out.Len(), result.BytesWritten)
+	}
+	if strings.Count(logs.String(), "sink unavailable") != 1 {
+		t.Errorf("want the failing extra writer logged once, got: %q", logs.String())
+	}
+
+	_, err = f.Run(FunctionRequest{
+		Process:      "echo",
+		ProcessArgs:  []string{"hello"},
+		OutputWriter: failingWriter{err: errors.New("client gone")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "client gone") {
+		t.Errorf("want an error on the primary writer to fail the invocation, got: %v", err)
+	}
+}
+
+func TestForkFunctionRunner_Run_FallbackProcess(t *testing.T) {
+	cases := []struct {
+		name         string
+		process      string
+		args         []string
+		wantOutput   string
+		wantErr      bool
+		wantFallback bool
+	}{
+		{name: "missing primary", process: "/does/not/exist", wantOutput: "fallback: body", wantFallback: true},
+		{name: "primary starts", process: "cat", wantOutput: "body"},
+		{name: "primary fails after starting", process: "sh", args: []string{"-c", "cat; exit 1"}, wantOutput: "body", wantErr: true},
+	}
+
+	for _, c := range cases {
+		f := &ForkFunctionRunner{
+			FallbackProcess: "sh",
+			FallbackArgs:    []string{"-c", "printf 'fallback: '; cat"},
+		}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      c.process,
+			ProcessArgs:  c.args,
+			InputReader:  ioutil.NopCloser(strings.NewReader("body")),
+			OutputWriter: out,
+		}
+
+		result, err := f.Run(req)
+		if (err != nil) != c.wantErr {
+			t.Errorf("(%s) want error: %t, got: %v", c.name, c.wantErr, err)
+		}
+		if out.String() != c.wantOutput {
+			t.Errorf("(%s) want output %q, got: %q", c.name, c.wantOutput, out.String())
+		}
+		if result.UsedFallback != c.wantFallback {
+			t.Errorf("(%s) want UsedFallback %t, got: %t", c.name, c.wantFallback, result.UsedFallback)
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_CommandPrefix(t *testing.T) {
+	cases := []struct {
+		name   string
+		prefix []string
+		want   string
+	}{
+		{name: "no prefix", want: "hello world\n"},
+		{name: "wrapper", prefix: []string{"sh", "-c", `echo "wrapped: $*"; exec "$@"`, "wrapper"}, want: "wrapped: echo hello world\nhello world\n"},
+	}
+
+	for _, c := range cases {
+		logs := &bytes.Buffer{}
+		log.SetOutput(logs)
+
+		f := &ForkFunctionRunner{CommandPrefix: c.prefix}
+		out := &bytes.Buffer{}
+		_, err := f.Run(FunctionRequest{
+			Process:      "echo",
+			ProcessArgs:  []string{"hello", "world"},
+			OutputWriter: out,
+		})
+		log.SetOutput(os.Stderr)
+
+		if err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+		if out.String() != c.want {
+			t.Errorf("(%s) want %q, got: %q", c.name, c.want, out.String())
+		}
+		if !strings.Contains(logs.String(), "Running echo") || strings.Contains(logs.String(), "Running sh") {
+			t.Errorf("(%s) want logs to name the real process, got: %q", c.name, logs.String())
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_StartLatencyAndExecDuration(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	f := ForkFunctionRunner{
+		// A slow fork, i.e. on an overloaded node
+		start: func(cmd *exec.Cmd) error {
+			time.Sleep(time.Millisecond * 300)
+			return cmd.Start()
+		},
+	}
+
+	result, err := f.Run(FunctionRequest{Process: "sleep", ProcessArgs: []string{"0.1"}})
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if result.StartLatency < time.Millisecond*300 {
+		t.Errorf("want StartLatency to include the stalled start, got: %s", result.StartLatency)
+	}
+	if result.ExecDuration < time.Millisecond*100 || result.ExecDuration >= time.Millisecond*300 {
+		t.Errorf("want ExecDuration of the process alone, got: %s", result.ExecDuration)
+	}
+	if result.Duration < result.StartLatency+result.ExecDuration {
+		t.Errorf("want Duration %s to cover both, got: %s and %s", result.Duration, result.StartLatency, result.ExecDuration)
+	}
+	if !strings.Contains(logs.String(), ", start: ") || !strings.Contains(logs.String(), ", exec: ") {
+		t.Errorf("want both durations logged, got: %q", logs.String())
+	}
+}
+
+// TestCommandFactoryHelperProcess is not a real test, it is run by
+// TestForkFunctionRunner_Run_CommandFactory in place of the function.
+func TestCommandFactoryHelperProcess(t *testing.T) {
+	if os.Getenv("WANT_FACTORY_HELPER") != "1" {
+		return
+	}
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	body, _ := ioutil.ReadAll(os.Stdin)
+	fmt.Printf("helper ran %q with %s", args[1:], body)
+	os.Exit(0)
+}
+
+func TestForkFunctionRunner_Run_CommandFactory(t *testing.T) {
+	var mutex sync.Mutex
+	var constructed [][]string
+
+	f := ForkFunctionRunner{
+		ExecTimeout: time.Second * 5,
+		CommandFactory: func(name string, args ...string) *exec.Cmd {
+			mutex.Lock()
+			constructed = append(constructed, append([]string{name}, args...))
+			mutex.Unlock()
+
+			helperArgs := append([]string{"-test.run=^TestCommandFactoryHelperProcess$", "--", name}, args...)
+			return exec.Command(os.Args[0], helperArgs...)
+		},
+	}
+
+	out := &bytes.Buffer{}
+	req := FunctionRequest{
+		Process:      "/does/not/exist",
+		ProcessArgs:  []string{"--flag", "value"},
+		Environment:  append(os.Environ(), "WANT_FACTORY_HELPER=1"),
+		InputReader:  ioutil.NopCloser(strings.NewReader("body")),
+		OutputWriter: out,
+	}
+
+	if _, err := f.Run(req); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	want := `helper ran ["/does/not/exist" "--flag" "value"] with body`
+	if out.String() != want {
+		t.Errorf("want %q, got: %q", want, out.String())
+	}
+	if len(constructed) != 1 || strings.Join(constructed[0], " ") != "/does/not/exist --flag value" {
+		t.Errorf("want factory given the process and its arguments, got: %q", constructed)
+	}
+
+	// The runner still bounds the lifetime of a command from the factory.
+	f.ExecTimeout = time.Millisecond * 100
+	f.CommandFactory = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("sleep", "5")
+	}
+	if _, err := f.Run(FunctionRequest{Process: "anything"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want ExecTimeout to kill the factory's command, got: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Tracer starts a span around each invocation. It is small enough for an
+// OpenTelemetry TracerProvider to be adapted to it without the executor
+// depending on a tracing SDK.
+type Tracer interface {
+	// Start begins a span named name, a child of parent when parent is valid.
+	Start(ctx context.Context, name string, parent SpanContext) (context.Context, Span)
+}
+
+// Span is a single traced invocation
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// RecordError marks the span as failed.
+	RecordError(err error)
+	End()
+}
+
+// SpanContext identifies the caller's span, as sent in a W3C traceparent header
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// Valid is true when the SpanContext came from a well-formed traceparent.
+func (s SpanContext) Valid() bool {
+	return len(s.TraceID) == 32 && len(s.SpanID) == 16
+}
+
+// ParseTraceParent reads a W3C traceparent header value, the zero SpanContext
+// is returned when it is malformed.
+func ParseTraceParent(value string) SpanContext {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}
+	}
+
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 {
+		return SpanContext{}
+	}
+	if !isHex(traceID) || !isHex(spanID) || !isHex(flags) ||
+		traceID == strings.Repeat("0", 32) || spanID == strings.Repeat("0", 16) {
+		return SpanContext{}
+	}
+
+	flagBits, _ := hex.DecodeString(flags)
+	return SpanContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Sampled: flagBits[0]&1 == 1,
+	}
+}
+
+func isHex(value string) bool {
+	_, err := hex.DecodeString(value)
+	return err == nil && strings.ToLower(value) == value
+}
+
+// traceParent extracts the caller's span from the request headers.
+func traceParent(headers map[string][]string) SpanContext {
+	return ParseTraceParent(http.Header(headers).Get("Traceparent"))
+}
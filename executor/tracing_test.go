@@ -0,0 +1,118 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	name       string
+	parent     SpanContext
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+// memoryTracer keeps every span in memory, like an in-memory exporter.
+type memoryTracer struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+func (m *memoryTracer) Start(ctx context.Context, name string, parent SpanContext) (context.Context, Span) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	span := &recordedSpan{name: name, parent: parent, attributes: map[string]interface{}{}}
+	m.spans = append(m.spans, span)
+	return ctx, span
+}
+
+func TestForkFunctionRunner_Run_RecordsSpan(t *testing.T) {
+	tracer := &memoryTracer{}
+	f := ForkFunctionRunner{Tracer: tracer}
+
+	cases := []struct {
+		name         string
+		args         []string
+		wantExitCode int
+		wantErr      bool
+	}{
+		{name: "success", args: []string{"-c", "exit 0"}, wantExitCode: 0},
+		{name: "failure", args: []string{"-c", "exit 3"}, wantExitCode: 3, wantErr: true},
+	}
+
+	for i, c := range cases {
+		req := FunctionRequest{
+			Process:     "sh",
+			ProcessArgs: c.args,
+			Headers: map[string][]string{
+				"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			},
+		}
+		f.Run(req)
+
+		if len(tracer.spans) != i+1 {
+			t.Fatalf("(%s) want %d spans, got: %d", c.name, i+1, len(tracer.spans))
+		}
+		span := tracer.spans[i]
+
+		if span.name != "sh" {
+			t.Errorf("(%s) want span named %q, got: %q", c.name, "sh", span.name)
+		}
+		if !span.ended {
+			t.Errorf("(%s) want span to be ended", c.name)
+		}
+		if span.parent.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.parent.SpanID != "00f067aa0ba902b7" || !span.parent.Sampled {
+			t.Errorf("(%s) want parent from traceparent header, got: %+v", c.name, span.parent)
+		}
+		if span.attributes["exit_code"] != c.wantExitCode {
+			t.Errorf("(%s) want exit_code attribute %d, got: %v", c.name, c.wantExitCode, span.attributes["exit_code"])
+		}
+		if _, ok := span.attributes["duration_seconds"]; !ok {
+			t.Errorf("(%s) want duration_seconds attribute", c.name)
+		}
+		if (span.err != nil) != c.wantErr {
+			t.Errorf("(%s) want error recorded: %t, got: %v", c.name, c.wantErr, span.err)
+		}
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	cases := []struct {
+		name      string
+		value     string
+		wantValid bool
+		wantTrace string
+	}{
+		{name: "valid", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantValid: true, wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "empty", value: ""},
+		{name: "short trace id", value: "00-4bf92f35-00f067aa0ba902b7-01"},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "uppercase", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "invalid version", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}
+
+	for _, c := range cases {
+		span := ParseTraceParent(c.value)
+		if span.Valid() != c.wantValid {
+			t.Errorf("(%s) want valid %t, got: %+v", c.name, c.wantValid, span)
+		}
+		if span.TraceID != c.wantTrace {
+			t.Errorf("(%s) want trace id %q, got: %q", c.name, c.wantTrace, span.TraceID)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package executor
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// RequestTransformer rewrites a request body before it is given to the
+// process, for instance to decrypt or normalise it.
+type RequestTransformer interface {
+	Transform(body io.Reader) (io.Reader, error)
+}
+
+// NopTransformer passes the request body through unchanged.
+type NopTransformer struct{}
+
+// Transform returns body as it is.
+func (NopTransformer) Transform(body io.Reader) (io.Reader, error) {
+	return body, nil
+}
+
+// GzipTransformer decompresses a gzip request body regardless of its
+// Content-Encoding, see DecompressRequest to only decode bodies which
+// declare it.
+type GzipTransformer struct{}
+
+// Transform reads the gzip header from body, returning an error when it
+// is not gzip.
+func (GzipTransformer) Transform(body io.Reader) (io.Reader, error) {
+	return gzip.NewReader(body)
+}
@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// upperTransformer uppercases the whole body, or fails with err when set.
+type upperTransformer struct {
+	err error
+}
+
+func (u upperTransformer) Transform(body io.Reader) (io.Reader, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(bytes.ToUpper(data)), nil
+}
+
+func TestForkFunctionRunner_Run_RequestTransformer(t *testing.T) {
+	gzipped := &bytes.Buffer{}
+	writer := gzip.NewWriter(gzipped)
+	writer.Write([]byte("compressed body"))
+	writer.Close()
+
+	cases := []struct {
+		name        string
+		transformer RequestTransformer
+		body        []byte
+		want        string
+	}{
+		{name: "uppercase", transformer: upperTransformer{}, body: []byte("hello world"), want: "HELLO WORLD"},
+		{name: "no-op", transformer: NopTransformer{}, body: []byte("hello world"), want: "hello world"},
+		{name: "gzip", transformer: GzipTransformer{}, body: gzipped.Bytes(), want: "compressed body"},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{RequestTransformer: c.transformer}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "cat",
+			InputReader:  ioutil.NopCloser(bytes.NewReader(c.body)),
+			OutputWriter: out,
+		}
+
+		if _, err := f.Run(req); err != nil {
+			t.Fatalf("(%s) want no error, got: %s", c.name, err)
+		}
+		if out.String() != c.want {
+			t.Errorf("(%s) want %q, got: %q", c.name, c.want, out.String())
+		}
+	}
+}
+
+func TestForkFunctionRunner_Run_RequestTransformerError(t *testing.T) {
+	cases := []struct {
+		name        string
+		transformer RequestTransformer
+		wantErr     error
+	}{
+		{name: "transformer error", transformer: upperTransformer{err: errors.New("cannot decrypt")}},
+		{name: "not gzip", transformer: GzipTransformer{}, wantErr: gzip.ErrHeader},
+	}
+
+	for _, c := range cases {
+		f := ForkFunctionRunner{RequestTransformer: c.transformer}
+		out := &bytes.Buffer{}
+		req := FunctionRequest{
+			Process:      "sh",
+			ProcessArgs:  []string{"-c", "echo started"},
+			InputReader:  ioutil.NopCloser(strings.NewReader("plain body")),
+			OutputWriter: out,
+		}
+
+		_, err := f.Run(req)
+		if err == nil || !strings.Contains(err.Error(), "unable to transform request body") {
+			t.Errorf("(%s) want transform error, got: %v", c.name, err)
+		}
+		if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+			t.Errorf("(%s) want %v, got: %v", c.name, c.wantErr, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("(%s) want process not started, got output: %q", c.name, out.String())
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Validate checks that req is well-formed and that its process can be found,
+// without starting it. A request which passes may still fail when run.
+func (f *ForkFunctionRunner) Validate(req FunctionRequest) error {
+	if len(req.Process) == 0 {
+		return errors.New("invalid request: no process given")
+	}
+	if _, err := exec.LookPath(req.Process); err != nil {
+		return fmt.Errorf("invalid process: %w", err)
+	}
+
+	if err := checkEnvironment(req.Environment); err != nil {
+		return err
+	}
+
+	if req.ContentLength != nil {
+		length := *req.ContentLength
+		switch {
+		case length < -1:
+			return fmt.Errorf("invalid Content-Length %d: must not be negative", length)
+		case length > 0 && req.InputReader == nil:
+			return fmt.Errorf("invalid Content-Length %d: the request has no body", length)
+		case f.MaxRequestBytes > 0 && length > f.MaxRequestBytes:
+			return fmt.Errorf("%w: Content-Length %d exceeds %d bytes", ErrRequestTooLarge, length, f.MaxRequestBytes)
+		}
+	}
+
+	return f.checkRequest(req)
+}
+
+// checkRequest checks the parts of req and the runner's settings which would
+// otherwise only fail once the process is started.
+func (f *ForkFunctionRunner) checkRequest(req FunctionRequest) error {
+	if len(req.WorkingDir) > 0 {
+		info, err := os.Stat(req.WorkingDir)
+		if err != nil {
+			return fmt.Errorf("invalid working directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid working directory: %s is not a directory", req.WorkingDir)
+		}
+	}
+
+	if err := checkSecretMounts(f.SecretMounts); err != nil {
+		return err
+	}
+
+	if f.Nice < -20 || f.Nice > 19 {
+		return fmt.Errorf("invalid Nice %d: must be between -20 and 19", f.Nice)
+	}
+	return nil
+}
+
+// checkEnvironment checks that each variable is a NAME=value pair which can be
+// passed to a process.
+func checkEnvironment(envs []string) error {
+	for _, env := range envs {
+		if strings.IndexByte(env, '=') <= 0 {
+			return fmt.Errorf("invalid environment variable %q: want NAME=value", env)
+		}
+		if strings.IndexByte(env, 0) >= 0 {
+			return fmt.Errorf("invalid environment variable %q: contains a NUL byte", env)
+		}
+	}
+	return nil
+}
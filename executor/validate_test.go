@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForkFunctionRunner_Validate(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	body := func() FunctionRequest {
+		return FunctionRequest{
+			Process:     "touch",
+			ProcessArgs: []string{marker},
+			InputReader: ioutil.NopCloser(strings.NewReader("body")),
+		}
+	}
+	length := func(n int64) *int64 { return &n }
+
+	cases := []struct {
+		name    string
+		runner  *ForkFunctionRunner
+		req     func() FunctionRequest
+		wantErr string
+	}{
+		{name: "valid", req: func() FunctionRequest {
+			req := body()
+			req.ContentLength = length(4)
+			req.Environment = []string{"PATH=/usr/bin:/bin", "EMPTY="}
+			return req
+		}},
+		{name: "unknown length", req: func() FunctionRequest {
+			req := body()
+			req.ContentLength = length(-1)
+			return req
+		}},
+		{name: "no process", req: func() FunctionRequest { return FunctionRequest{} }, wantErr: "no process given"},
+		{name: "missing executable", req: func() FunctionRequest {
+			return FunctionRequest{Process: "does-not-exist-on-path"}
+		}, wantErr: "invalid process"},
+		{name: "negative length", req: func() FunctionRequest {
+			req := body()
+			req.ContentLength = length(-2)
+			return req
+		}, wantErr: "must not be negative"},
+		{name: "length without a body", req: func() FunctionRequest {
+			return FunctionRequest{Process: "true", ContentLength: length(10)}
+		}, wantErr: "the request has no body"},
+		{name: "length over MaxRequestBytes", runner: &ForkFunctionRunner{MaxRequestBytes: 2}, req: func() FunctionRequest {
+			req := body()
+			req.ContentLength = length(4)
+			return req
+		}, wantErr: ErrRequestTooLarge.Error()},
+		{name: "malformed environment", req: func() FunctionRequest {
+			req := body()
+			req.Environment = []string{"NO_VALUE"}
+			return req
+		}, wantErr: "want NAME=value"},
+		{name: "environment without a name", req: func() FunctionRequest {
+			req := body()
+			req.Environment = []string{"=value"}
+			return req
+		}, wantErr: "want NAME=value"},
+		{name: "missing working directory", req: func() FunctionRequest {
+			req := body()
+			req.WorkingDir = filepath.Join(t.TempDir(), "missing")
+			return req
+		}, wantErr: "invalid working directory"},
+		{name: "runner settings", runner: &ForkFunctionRunner{Nice: 40}, req: body, wantErr: "invalid Nice"},
+	}
+
+	for _, c := range cases {
+		runner := c.runner
+		if runner == nil {
+			runner = &ForkFunctionRunner{}
+		}
+
+		err := runner.Validate(c.req())
+		if len(c.wantErr) == 0 && err != nil {
+			t.Errorf("(%s) want no error, got: %s", c.name, err)
+		}
+		if len(c.wantErr) > 0 && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("(%s) want error containing %q, got: %v", c.name, c.wantErr, err)
+		}
+	}
+
+	if _, err := ioutil.ReadFile(marker); err == nil {
+		t.Errorf("want Validate never to start the process")
+	}
+	if err := (&ForkFunctionRunner{MaxRequestBytes: 2}).Validate(FunctionRequest{
+		Process: "true", InputReader: ioutil.NopCloser(strings.NewReader("")), ContentLength: length(3),
+	}); !errors.Is(err, ErrRequestTooLarge) {
+		t.Errorf("want ErrRequestTooLarge, got: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// countingWriter counts the bytes successfully written to the wrapped writer.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	atomic.AddInt64(&c.count, int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes written so far.
+func (c *countingWriter) Count() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+// teeWriter copies each write to extras after writer, only a failure on
+// writer fails the write. An extra which fails is skipped from then on.
+type teeWriter struct {
+	writer    io.Writer
+	extras    []io.Writer
+	failed    []bool
+	requestID string
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.writer.Write(p)
+	for i, extra := range t.extras {
+		if t.failed[i] || n == 0 {
+			continue
+		}
+		if _, extraErr := extra.Write(p[:n]); extraErr != nil {
+			logRequest(t.requestID, "Unable to write output to extra writer %d: %s", i, extraErr)
+			t.failed[i] = true
+		}
+	}
+	return n, err
+}
+
+// flushWriter flushes after every write so that streamed output reaches the
+// client as the function produces it rather than when a buffer fills.
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if n > 0 {
+		w.flusher.Flush()
+	}
+	return n, err
+}
+
+// deadlineWriter fails the invocation when a single Write blocks for longer than timeout.
+type deadlineWriter struct {
+	*stallTimer
+	writer io.Writer
+}
+
+func newDeadlineWriter(writer io.Writer, timeout time.Duration, onTimeout func()) *deadlineWriter {
+	return &deadlineWriter{
+		stallTimer: newStallTimer(timeout, onTimeout),
+		writer:     writer,
+	}
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	d.begin()
+	n, err := d.writer.Write(p)
+	if d.end() {
+		return n, ErrWriteTimeout
+	}
+	return n, err
+}
+
+// limitWriter passes at most max bytes through to writer. Beyond that it
+// either discards the rest when truncate is set, or calls onExceed and fails.
+type limitWriter struct {
+	writer   io.Writer
+	max      int64
+	truncate bool
+	onExceed func()
+
+	written  int64
+	exceeded bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	remaining := l.max - l.written
+	if int64(len(p)) <= remaining {
+		n, err := l.writer.Write(p)
+		l.written += int64(n)
+		return n, err
+	}
+
+	if !l.exceeded {
+		l.exceeded = true
+		l.onExceed()
+	}
+
+	if !l.truncate {
+		return 0, ErrResponseTooLarge
+	}
+
+	if remaining > 0 {
+		n, err := l.writer.Write(p[:remaining])
+		l.written += int64(n)
+		if err != nil {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
+	"time"
 
 	"github.com/openfaas-incubator/of-watchdog/config"
 	"github.com/openfaas-incubator/of-watchdog/executor"
@@ -16,7 +20,7 @@ import (
 func main() {
 	watchdogConfig, configErr := config.New(os.Environ())
 	if configErr != nil {
-		fmt.Fprintf(os.Stderr, configErr.Error())
+		fmt.Fprintf(os.Stderr, "%s", configErr.Error())
 		os.Exit(-1)
 	}
 
@@ -32,7 +36,8 @@ func main() {
 		MaxHeaderBytes: 1 << 20, // Max header of 1MB
 	}
 
-	requestHandler := buildRequestHandler(watchdogConfig)
+	requestHandler, shutdown, runner := buildRequestHandler(watchdogConfig)
+	health := &executor.HealthHandler{Runner: runner}
 
 	log.Printf("OperationalMode: %s\n", config.WatchdogMode(watchdogConfig.OperationalMode))
 
@@ -40,16 +45,60 @@ func main() {
 		log.Panic(err.Error())
 	}
 
+	http.Handle("/_/health", health)
+	http.Handle("/_/health/deep", health)
 	http.HandleFunc("/", requestHandler)
-	log.Fatal(s.ListenAndServe())
+	listenUntilShutdown(s, shutdown, health, watchdogConfig.HealthcheckInterval, watchdogConfig.GracefulWait)
 }
 
-func buildRequestHandler(watchdogConfig config.WatchdogConfig) http.HandlerFunc {
+// shutdownFunc waits for the runner's in-flight invocations until ctx is done
+type shutdownFunc func(ctx context.Context) error
+
+// listenUntilShutdown serves until SIGTERM, then removes the lock file, fails
+// health probes and keeps serving for healthcheckInterval before draining for
+// up to gracefulWait.
+func listenUntilShutdown(s *http.Server, shutdown shutdownFunc, health *executor.HealthHandler, healthcheckInterval time.Duration, gracefulWait time.Duration) {
+	go func() {
+		if err := s.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	<-sig
+
+	log.Printf("SIGTERM received, removing lock file and waiting %s for health checks", healthcheckInterval)
+	if err := os.Remove(lockFilePath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Unable to remove lock file: %s", err)
+	}
+	health.Drain()
+	time.Sleep(healthcheckInterval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracefulWait)
+	defer cancel()
+
+	log.Printf("Draining in-flight requests for up to %s", gracefulWait)
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("Unable to drain HTTP server: %s", err)
+	}
+	if err := shutdown(ctx); err != nil {
+		log.Printf("Unable to drain function runner: %s", err)
+	}
+}
+
+// buildRequestHandler also returns the runner which reports readiness to
+// health probes, nil when the mode has none.
+func buildRequestHandler(watchdogConfig config.WatchdogConfig) (http.HandlerFunc, shutdownFunc, executor.FunctionRunner) {
 	var requestHandler http.HandlerFunc
+	var runner executor.FunctionRunner
+	shutdown := func(ctx context.Context) error {
+		return nil
+	}
 
 	switch watchdogConfig.OperationalMode {
 	case config.ModeStreaming:
-		requestHandler = makeForkRequestHandler(watchdogConfig)
+		requestHandler, shutdown, runner = makeForkRequestHandler(watchdogConfig)
 		break
 	case config.ModeSerializing:
 		requestHandler = makeSerializingForkRequestHandler(watchdogConfig)
@@ -58,18 +107,22 @@ func buildRequestHandler(watchdogConfig config.WatchdogConfig) http.HandlerFunc
 		requestHandler = makeAfterBurnRequestHandler(watchdogConfig)
 		break
 	case config.ModeHTTP:
-		requestHandler = makeHTTPRequestHandler(watchdogConfig)
+		requestHandler, shutdown, runner = makeHTTPRequestHandler(watchdogConfig)
 		break
 	default:
 		log.Panicf("unknown watchdog mode: %d", watchdogConfig.OperationalMode)
 		break
 	}
 
-	return requestHandler
+	return requestHandler, shutdown, runner
+}
+
+func lockFilePath() string {
+	return filepath.Join(os.TempDir(), ".lock")
 }
 
 func lock() error {
-	lockFile := filepath.Join(os.TempDir(), ".lock")
+	lockFile := lockFilePath()
 	log.Printf("Writing lock file at: %s", lockFile)
 	return ioutil.WriteFile(lockFile, nil, 0600)
 
@@ -115,12 +168,6 @@ func makeSerializingForkRequestHandler(watchdogConfig config.WatchdogConfig) fun
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		var environment []string
-
-		if watchdogConfig.InjectCGIHeaders {
-			environment = getEnvironment(r)
-		}
-
 		commandName, arguments := watchdogConfig.Process()
 		req := executor.FunctionRequest{
 			Process:       commandName,
@@ -128,29 +175,30 @@ func makeSerializingForkRequestHandler(watchdogConfig config.WatchdogConfig) fun
 			InputReader:   r.Body,
 			ContentLength: &r.ContentLength,
 			OutputWriter:  w,
-			Environment:   environment,
+			RequestID:     r.Header.Get("X-Call-Id"),
+		}
+
+		if watchdogConfig.InjectCGIHeaders {
+			injectCGIHeaders(&req, r)
 		}
 
 		w.Header().Set("Content-Type", watchdogConfig.ContentType)
-		err := functionInvoker.Run(req, w)
+		_, err := functionInvoker.RunContext(r.Context(), req)
 		if err != nil {
 			log.Println(err)
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
 		}
 	}
 }
 
-func makeForkRequestHandler(watchdogConfig config.WatchdogConfig) func(http.ResponseWriter, *http.Request) {
-	functionInvoker := executor.ForkFunctionRunner{
-		ExecTimeout: watchdogConfig.ExecTimeout,
+func makeForkRequestHandler(watchdogConfig config.WatchdogConfig) (http.HandlerFunc, shutdownFunc, executor.FunctionRunner) {
+	functionInvoker := &executor.ForkFunctionRunner{
+		ExecTimeout:    watchdogConfig.ExecTimeout,
+		ForwardSignals: watchdogConfig.ForwardSignals,
 	}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		var environment []string
-
-		if watchdogConfig.InjectCGIHeaders {
-			environment = getEnvironment(r)
-		}
+	requestHandler := func(w http.ResponseWriter, r *http.Request) {
 
 		commandName, arguments := watchdogConfig.Process()
 		req := executor.FunctionRequest{
@@ -158,11 +206,15 @@ func makeForkRequestHandler(watchdogConfig config.WatchdogConfig) func(http.Resp
 			ProcessArgs:  arguments,
 			InputReader:  r.Body,
 			OutputWriter: w,
-			Environment:  environment,
+			RequestID:    r.Header.Get("X-Call-Id"),
+		}
+
+		if watchdogConfig.InjectCGIHeaders {
+			injectCGIHeaders(&req, r)
 		}
 
 		w.Header().Set("Content-Type", watchdogConfig.ContentType)
-		err := functionInvoker.Run(req)
+		_, err := functionInvoker.RunContext(r.Context(), req)
 		if err != nil {
 			log.Println(err.Error())
 
@@ -171,54 +223,46 @@ func makeForkRequestHandler(watchdogConfig config.WatchdogConfig) func(http.Resp
 			// w.Write([]byte(err.Error()))
 		}
 	}
-}
 
-func getEnvironment(r *http.Request) []string {
-	var envs []string
+	return requestHandler, functionInvoker.Shutdown, functionInvoker
+}
 
-	envs = os.Environ()
-	for k, v := range r.Header {
-		kv := fmt.Sprintf("Http_%s=%s", strings.Replace(k, "-", "_", -1), v[0])
-		envs = append(envs, kv)
-	}
-	envs = append(envs, fmt.Sprintf("Http_Method=%s", r.Method))
+// injectCGIHeaders passes the request's headers, method, path and query to the function's environment
+func injectCGIHeaders(req *executor.FunctionRequest, r *http.Request) {
+	req.Headers = r.Header
+	req.Method = r.Method
+	req.Path = r.URL.Path
+	req.QueryString = r.URL.RawQuery
+}
 
-	if len(r.URL.RawQuery) > 0 {
-		envs = append(envs, fmt.Sprintf("Http_Query=%s", r.URL.RawQuery))
-	}
+func makeHTTPRequestHandler(watchdogConfig config.WatchdogConfig) (http.HandlerFunc, shutdownFunc, executor.FunctionRunner) {
+	commandName, arguments := watchdogConfig.Process()
 
-	if len(r.URL.Path) > 0 {
-		envs = append(envs, fmt.Sprintf("Http_Path=%s", r.URL.Path))
+	upstreamURL, err := url.Parse(watchdogConfig.UpstreamURL)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	return envs
-}
-
-func makeHTTPRequestHandler(watchdogConfig config.WatchdogConfig) func(http.ResponseWriter, *http.Request) {
-	commandName, arguments := watchdogConfig.Process()
-	functionInvoker := executor.HTTPFunctionRunner{
-		ExecTimeout: watchdogConfig.ExecTimeout,
-		Process:     commandName,
-		ProcessArgs: arguments,
+	functionInvoker := &executor.HTTPFunctionRunner{
+		ExecTimeout:    watchdogConfig.ExecTimeout,
+		Process:        commandName,
+		ProcessArgs:    arguments,
+		UpstreamURL:    upstreamURL,
+		ForwardSignals: watchdogConfig.ForwardSignals,
 	}
 
 	fmt.Printf("Forking - %s %s\n", commandName, arguments)
-	functionInvoker.Start()
-
-	return func(w http.ResponseWriter, r *http.Request) {
+	if err := functionInvoker.Start(); err != nil {
+		log.Fatal(err)
+	}
 
-		req := executor.FunctionRequest{
-			Process:      commandName,
-			ProcessArgs:  arguments,
-			InputReader:  r.Body,
-			OutputWriter: w,
-		}
+	requestHandler := func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Body != nil {
 			defer r.Body.Close()
 		}
 
-		err := functionInvoker.Run(req, r.ContentLength, r, w)
+		err := functionInvoker.Proxy(r, w)
 
 		if err != nil {
 			w.WriteHeader(500)
@@ -226,4 +270,8 @@ func makeHTTPRequestHandler(watchdogConfig config.WatchdogConfig) func(http.Resp
 		}
 
 	}
+
+	return requestHandler, func(ctx context.Context) error {
+		return functionInvoker.Close()
+	}, functionInvoker
 }
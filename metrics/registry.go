@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the duration histogram.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry collects invocation metrics and serves them in the Prometheus text format.
+type Registry struct {
+	mutex sync.Mutex
+
+	buckets      []float64
+	bucketCounts []uint64
+	durationSum  float64
+	durationN    uint64
+
+	successes uint64
+	failures  uint64
+
+	queueWaitSum float64
+	queueWaitN   uint64
+}
+
+// NewRegistry creates a Registry using DefaultBuckets.
+func NewRegistry() *Registry {
+	return &Registry{
+		buckets:      DefaultBuckets,
+		bucketCounts: make([]uint64, len(DefaultBuckets)),
+	}
+}
+
+// ObserveInvocation counts an invocation by result and records its duration.
+func (r *Registry) ObserveInvocation(success bool, duration time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if success {
+		r.successes++
+	} else {
+		r.failures++
+	}
+
+	seconds := duration.Seconds()
+	for i, upperBound := range r.buckets {
+		if seconds <= upperBound {
+			r.bucketCounts[i]++
+		}
+	}
+	r.durationSum += seconds
+	r.durationN++
+}
+
+// ObserveQueueWait records how long an invocation waited for a free inflight slot.
+func (r *Registry) ObserveQueueWait(wait time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.queueWaitSum += wait.Seconds()
+	r.queueWaitN++
+}
+
+// Invocations returns the number of successful and failed invocations observed.
+func (r *Registry) Invocations() (success uint64, failure uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.successes, r.failures
+}
+
+// DurationSum returns the count and total of all observed durations.
+func (r *Registry) DurationSum() (uint64, time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.durationN, time.Duration(r.durationSum * float64(time.Second))
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format, mount it on /metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP function_invocations_total Total number of function invocations.")
+	fmt.Fprintln(w, "# TYPE function_invocations_total counter")
+	fmt.Fprintf(w, "function_invocations_total{result=\"success\"} %d\n", r.successes)
+	fmt.Fprintf(w, "function_invocations_total{result=\"failure\"} %d\n", r.failures)
+
+	fmt.Fprintln(w, "# HELP function_duration_seconds Duration of function invocations.")
+	fmt.Fprintln(w, "# TYPE function_duration_seconds histogram")
+	for i, upperBound := range r.buckets {
+		fmt.Fprintf(w, "function_duration_seconds_bucket{le=\"%s\"} %d\n", formatFloat(upperBound), r.bucketCounts[i])
+	}
+	fmt.Fprintf(w, "function_duration_seconds_bucket{le=\"+Inf\"} %d\n", r.durationN)
+	fmt.Fprintf(w, "function_duration_seconds_sum %s\n", formatFloat(r.durationSum))
+	fmt.Fprintf(w, "function_duration_seconds_count %d\n", r.durationN)
+
+	fmt.Fprintln(w, "# HELP function_queue_wait_seconds Time invocations waited for a free inflight slot.")
+	fmt.Fprintln(w, "# TYPE function_queue_wait_seconds summary")
+	fmt.Fprintf(w, "function_queue_wait_seconds_sum %s\n", formatFloat(r.queueWaitSum))
+	fmt.Fprintf(w, "function_queue_wait_seconds_count %d\n", r.queueWaitN)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
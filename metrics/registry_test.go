@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry_ObserveInvocation(t *testing.T) {
+	r := NewRegistry()
+
+	r.ObserveInvocation(true, time.Millisecond*20)
+	r.ObserveInvocation(true, time.Second*3)
+	r.ObserveInvocation(false, time.Millisecond)
+
+	success, failure := r.Invocations()
+	if success != 2 || failure != 1 {
+		t.Errorf("want 2 successes and 1 failure, got: %d and %d", success, failure)
+	}
+
+	count, sum := r.DurationSum()
+	if count != 3 || sum < time.Second*3 {
+		t.Errorf("want 3 durations summing over 3s, got: %d and %s", count, sum)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.ObserveInvocation(true, time.Millisecond*20)
+	r.ObserveInvocation(false, time.Second*3)
+	r.ObserveQueueWait(time.Millisecond * 500)
+	r.ObserveQueueWait(time.Millisecond * 250)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := []string{
+		`function_invocations_total{result="success"} 1`,
+		`function_invocations_total{result="failure"} 1`,
+		`function_duration_seconds_bucket{le="0.01"} 0`,
+		`function_duration_seconds_bucket{le="0.025"} 1`,
+		`function_duration_seconds_bucket{le="5"} 2`,
+		`function_duration_seconds_bucket{le="+Inf"} 2`,
+		`function_duration_seconds_count 2`,
+		`function_queue_wait_seconds_sum 0.75`,
+		`function_queue_wait_seconds_count 2`,
+	}
+
+	for _, line := range want {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("want line %q in:\n%s", line, w.Body.String())
+		}
+	}
+}